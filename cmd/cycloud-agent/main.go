@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/agent"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	var config agent.Config
+	var tags, gpuModel string
+	var gpuCount, gpuVRAM int
+	flag.StringVar(&config.Server, "server", getEnv("CYCLOUD_SERVER", "http://localhost:3001"), "Cycloud server URL")
+	flag.StringVar(&config.APIKey, "api-key", os.Getenv("CYCLOUD_API_KEY"), "API key of the supplier, defaults to $CYCLOUD_API_KEY")
+	flag.StringVar(&config.StatePath, "state", "cycloud-agent.json", "file the registered resource ID is kept in")
+	flag.StringVar(&config.DataDir, "data-dir", "/", "directory whose filesystem is offered as storage")
+	flag.BoolVar(&config.AutoAvailable, "auto-available", true, "list the resource whenever it is idle")
+	flag.DurationVar(&config.BenchmarkTime, "benchmark-time", 2*time.Second, "duration of each CPU and memory benchmark pass")
+	flag.Float64Var(&config.Resource.CostPerMinute, "cost-per-hour", 1, "cost per hour of the resource")
+	flag.IntVar(&config.Resource.Bandwidth, "bandwidth", 100, "bandwidth in Mbps")
+	flag.StringVar(&config.Resource.Region, "region", "", "region, e.g. eu-west")
+	flag.StringVar(&config.Resource.Zone, "zone", "", "zone, e.g. eu-west-1a")
+	flag.StringVar(&config.Resource.CUDAVersion, "cuda-version", "", "CUDA version of the GPUs")
+	flag.StringVar(&tags, "tags", "", "comma separated tags")
+	flag.StringVar(&gpuModel, "gpu-model", "", "GPU model, e.g. NVIDIA A100")
+	flag.IntVar(&gpuCount, "gpu-count", 0, "number of GPUs")
+	flag.IntVar(&gpuVRAM, "gpu-vram", 0, "VRAM per GPU in GB")
+	flag.Parse()
+
+	if config.APIKey == "" {
+		log.Fatal("An API key is required, create one from the Cycloud dashboard")
+	}
+	if tags != "" {
+		config.Resource.Tags = strings.Split(tags, ",")
+	}
+	if gpuModel != "" && gpuCount > 0 {
+		config.Resource.GPUs = []models.GPU{{Model: gpuModel, Count: gpuCount, VRAM: gpuVRAM}}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := agent.New(config).Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Agent stopped")
+}
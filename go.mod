@@ -10,4 +10,9 @@ require (
 	github.com/lib/pq v1.10.9
 )
 
-require github.com/gorilla/websocket v1.5.3
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.9.0
+)
+
+require golang.org/x/sys v0.10.0 // indirect
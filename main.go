@@ -12,8 +12,10 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
 	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
 	"github.com/gunrgnhsr/Cycloud/pkg/handlers"
+	"github.com/gunrgnhsr/Cycloud/pkg/mailer"
 )
 
 func addDBToContext(db *sql.DB, r *http.Request) *http.Request {
@@ -35,20 +37,96 @@ func main() {
 		panic(err)
 	}
 
+	// Send account emails with the mailer chosen in the environment
+	handlers.SetMailer(mailer.FromEnv())
+
+	// Sign tokens with keys shared by every server, in the database unless JWT_KEY_STORE=file
+	var keyStore auth.KeyStore = pkg.NewJWTKeyStore(db)
+	if os.Getenv("JWT_KEY_STORE") == "file" {
+		keyPath := os.Getenv("JWT_KEY_FILE")
+		if keyPath == "" {
+			keyPath = "jwt-keys.json"
+		}
+		keyStore = auth.FileKeyStore{Path: keyPath}
+	}
+	keyRing, err := auth.KeyRingFromEnv(keyStore)
+	if err != nil {
+		panic(err)
+	}
+	if err = keyRing.Load(time.Now()); err != nil {
+		panic(err)
+	}
+	auth.SetKeyRing(keyRing)
+
+	// Count failed logins in the database so every server throttles alike, unless LOGIN_ATTEMPT_STORE=memory
+	var attemptStore auth.AttemptStore = pkg.NewLoginAttemptStore(db)
+	if os.Getenv("LOGIN_ATTEMPT_STORE") == "memory" {
+		attemptStore = auth.NewMemoryAttemptStore()
+	}
+	loginThrottle := auth.LoginThrottleFromEnv(attemptStore)
+	handlers.SetLoginThrottle(loginThrottle)
+
 	muxRouter := mux.NewRouter()
 
 	// Apply logging middleware
 	muxRouter.Use(loggingMiddleware)
 
+	// Reject callers whose roles lack the permission of the route
+	muxRouter.Use(handlers.AuthorizeRoute)
+
 	// Set up routes and middleware
 	muxRouter.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		handlers.Login(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		handlers.Register(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/verify-email/{token}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.VerifyEmail(w, addDBToContext(db, r))
+	})
+
 	muxRouter.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
 		handlers.Logout(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/refresh-token", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RefreshToken(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/verify-login", func(w http.ResponseWriter, r *http.Request) {
+		handlers.VerifyLogin(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-sessions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetSessions(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/revoke-session/{sid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeSession(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-2fa-status", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetTOTPStatus(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/enroll-2fa", func(w http.ResponseWriter, r *http.Request) {
+		handlers.EnrollTOTP(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/confirm-2fa", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ConfirmTOTP(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/disable-2fa", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DisableTOTP(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/regenerate-recovery-codes", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RegenerateRecoveryCodes(w, addDBToContext(db, r))
+	})
+
 	muxRouter.HandleFunc("/get-user-resources", func(w http.ResponseWriter, r *http.Request) {
 		handlers.GetUserResource(w, addDBToContext(db, r))
 	})
@@ -57,6 +135,22 @@ func main() {
 		handlers.CreateResource(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/bulk-user-resources", func(w http.ResponseWriter, r *http.Request) {
+		handlers.BulkResources(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/add-resource-template", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AddResourceTemplate(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-resource-templates", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetResourceTemplates(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/delete-resource-template/{tid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteResourceTemplate(w, addDBToContext(db, r))
+	})
+
 	muxRouter.HandleFunc("/delete-user-resource/{rid}", func(w http.ResponseWriter, r *http.Request) {
 		handlers.DeleteResource(w, addDBToContext(db, r))
 	})
@@ -65,10 +159,58 @@ func main() {
 		handlers.UpdateResourceAvailability(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/update-user-resource/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateResource(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-resource-revisions/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetResourceRevisions(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-pricing-rules/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPricingRules(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/set-pricing-rules/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SetPricingRules(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-workload-policy/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetWorkloadPolicy(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/set-workload-policy/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SetWorkloadPolicy(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-acceptance-policy/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAcceptancePolicy(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/set-acceptance-policy/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SetAcceptancePolicy(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/suggest-price", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SuggestPrice(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/price-history", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPriceHistory(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/price-percentiles", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPricePercentiles(w, addDBToContext(db, r))
+	})
+
 	muxRouter.HandleFunc("/available-resources/{rid}/{direction}", func(w http.ResponseWriter, r *http.Request) {
 		handlers.GetResources(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/search-resources", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SearchResources(w, addDBToContext(db, r))
+	})
+
 	muxRouter.HandleFunc("/get-resource/{rid}", func(w http.ResponseWriter, r *http.Request) {
 		handlers.GetLoanRequestResourceSpec(w, addDBToContext(db, r))
 	})
@@ -81,10 +223,116 @@ func main() {
 		handlers.GetUserBids(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/get-loan-request-spec/{bidId}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetLoanRequestAcceptedSpec(w, addDBToContext(db, r))
+	})
+
 	muxRouter.HandleFunc("/delete-loan-request/{bidId}", func(w http.ResponseWriter, r *http.Request) {
 		handlers.RemoveUserBid(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/add-pool", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AddPool(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-user-pools", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetUserPools(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/add-pool-members/{pid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AddPoolMembers(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/remove-pool-member/{pid}/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RemovePoolMember(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/delete-pool/{pid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeletePool(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-pool-leases/{pid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPoolLeases(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/available-pools", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAvailablePools(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/place-pool-loan-request", func(w http.ResponseWriter, r *http.Request) {
+		handlers.PlacePoolBid(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/add-api-key", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AddAPIKey(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-api-keys", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAPIKeys(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/revoke-api-key/{kid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeAPIKey(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/register-agent", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RegisterAgent(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/agent-heartbeat/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AgentHeartbeat(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/agent-hardware-report/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SubmitHardwareReport(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-hardware-report/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetHardwareReport(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/drain-resource/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DrainResource(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/end-resource-maintenance/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.EndResourceMaintenance(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/schedule-resource-maintenance/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ScheduleResourceMaintenance(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/cancel-resource-maintenance/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CancelResourceMaintenance(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/rate-lease/{bidId}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RateLease(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-resource-ratings/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetResourceRatings(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/open-dispute/{bidId}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.OpenDispute(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-disputes", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetUserDisputes(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-dispute/{did}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetDispute(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/get-ledger", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetLedger(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/.well-known/jwks.json", handlers.GetJWKS)
+
 	muxRouter.HandleFunc("/get-info", func(w http.ResponseWriter, r *http.Request) {
 			handlers.GetUserInfo(w, addDBToContext(db, r))
 	})
@@ -101,6 +349,70 @@ func main() {
 		handlers.PassConnectionOffer(w, addDBToContext(db, r))
 	})
 
+	muxRouter.HandleFunc("/admin/purge-archived-resources", func(w http.ResponseWriter, r *http.Request) {
+		handlers.PurgeArchivedResources(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/disputes", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetOpenDisputes(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/resolve-dispute/{did}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ResolveDispute(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/grant-role/{uid}/{role}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GrantRole(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/revoke-role/{uid}/{role}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeRole(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/require-supplier-2fa", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RequireSupplierTOTP(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/users", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListUsers(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/suspend-user/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SuspendUser(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/unsuspend-user/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UnsuspendUser(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/adjust-credits/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AdjustCredits(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/end-lease/{bidId}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ForceEndLease(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/cancel-auction/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CancelAuction(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/remove-resource/{rid}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RemoveResource(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetSystemStats(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/audit-log", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAuditLog(w, addDBToContext(db, r))
+	})
+
+	muxRouter.HandleFunc("/admin/login-events", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetLoginEvents(w, addDBToContext(db, r))
+	})
+
 	// Create a server instance
 	server := &http.Server{
 		Addr:    ":3001",
@@ -123,6 +435,21 @@ func main() {
 		}
 	}()
 
+	// Take resources whose supplier agent went offline off the market
+	go handlers.MonitorAgentHeartbeats(db)
+
+	// Drain and restore resources as their maintenance windows start and end
+	go handlers.RunMaintenanceScheduler(db)
+
+	// Pay suppliers once the dispute window of their leases is over
+	go handlers.RunEscrowRelease(db)
+
+	// Rotate the JWT signing key and pick up the keys rotated by other servers
+	go keyRing.RunRotation(time.Minute)
+
+	// Drop the failed logins too old to matter
+	go loginThrottle.RunPruning(10 * time.Minute)
+
 	// Start the server
 	fmt.Println("Server listening on port 3001")
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
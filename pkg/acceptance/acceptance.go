@@ -0,0 +1,84 @@
+package acceptance
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/workload"
+)
+
+const (
+	maxRenters    = 1000 // per allow or block list
+	maxReputation = 100
+)
+
+var workloadTypes = map[string]bool{
+	models.WorkloadInteractive: true,
+	models.WorkloadBatch:       true,
+	models.WorkloadService:     true,
+}
+
+// Validate checks that an acceptance policy is well formed
+func Validate(policy models.AcceptancePolicy) error {
+	if len(policy.AllowedRenters) > maxRenters || len(policy.BlockedRenters) > maxRenters {
+		return fmt.Errorf("at most %d renters may be allowed or blocked", maxRenters)
+	}
+	allowed := map[string]bool{}
+	for _, uid := range policy.AllowedRenters {
+		if uid == "" {
+			return errors.New("allowed renters must not be empty")
+		}
+		allowed[uid] = true
+	}
+	for _, uid := range policy.BlockedRenters {
+		if uid == "" {
+			return errors.New("blocked renters must not be empty")
+		}
+		if allowed[uid] {
+			return errors.New("renter " + uid + " is both allowed and blocked")
+		}
+	}
+	if policy.MinReputation < 0 || policy.MinReputation > maxReputation {
+		return fmt.Errorf("minimum reputation must be between 0 and %d", maxReputation)
+	}
+	for _, workloadType := range policy.AllowedWorkloadTypes {
+		if !workloadTypes[workloadType] {
+			return errors.New("unknown workload type " + workloadType + ", expected interactive, batch or service")
+		}
+	}
+	if policy.MaxDuration < 0 {
+		return errors.New("max duration must not be negative")
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Check decides whether a policy accepts a loan request by renter, whose reputation is given, the error gives the
+// reason of a rejection
+func Check(policy models.AcceptancePolicy, renter string, reputation float64, bid models.Bid) error {
+	if contains(policy.BlockedRenters, renter) {
+		return errors.New("the supplier does not accept loan requests from you")
+	}
+	if len(policy.AllowedRenters) > 0 && !contains(policy.AllowedRenters, renter) {
+		return errors.New("the supplier only accepts loan requests from selected renters")
+	}
+	if reputation < policy.MinReputation {
+		return fmt.Errorf("the supplier requires a reputation of at least %.0f, yours is %.0f", policy.MinReputation, reputation)
+	}
+	if len(policy.AllowedWorkloadTypes) > 0 && !contains(policy.AllowedWorkloadTypes, workload.Type(bid.Workload)) {
+		return errors.New("the supplier does not accept " + workload.Type(bid.Workload) + " workloads")
+	}
+	if policy.MaxDuration > 0 && bid.Duration > policy.MaxDuration {
+		return fmt.Errorf("the supplier accepts leases of at most %d hours", policy.MaxDuration)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package acceptance
+
+import (
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestValidate(t *testing.T) {
+	valid := models.AcceptancePolicy{AllowedRenters: []string{"1"}, BlockedRenters: []string{"2"}, MinReputation: 80, AllowedWorkloadTypes: []string{models.WorkloadBatch}, MaxDuration: 24}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Expected valid policy, got %v", err)
+	}
+
+	invalid := []models.AcceptancePolicy{
+		{AllowedRenters: []string{"1"}, BlockedRenters: []string{"1"}},
+		{MinReputation: 101},
+		{AllowedWorkloadTypes: []string{"gpu"}},
+		{MaxDuration: -1},
+	}
+	for _, policy := range invalid {
+		if err := Validate(policy); err == nil {
+			t.Errorf("Expected %+v to be invalid", policy)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	policy := models.AcceptancePolicy{BlockedRenters: []string{"2"}, MinReputation: 80, AllowedWorkloadTypes: []string{models.WorkloadBatch}, MaxDuration: 24}
+	batch := models.Bid{Duration: 10, Workload: &models.Workload{Image: "docker.io/library/python"}}
+
+	if err := Check(policy, "1", 90, batch); err != nil {
+		t.Errorf("Expected loan request to be accepted, got %v", err)
+	}
+
+	rejections := []struct {
+		renter     string
+		reputation float64
+		bid        models.Bid
+	}{
+		{"2", 100, batch},
+		{"1", 70, batch},
+		{"1", 90, models.Bid{Duration: 10}},
+		{"1", 90, models.Bid{Duration: 48, Workload: batch.Workload}},
+	}
+	for _, rejection := range rejections {
+		if err := Check(policy, rejection.renter, rejection.reputation, rejection.bid); err == nil {
+			t.Errorf("Expected %+v to be rejected", rejection)
+		}
+	}
+
+	allowList := models.AcceptancePolicy{AllowedRenters: []string{"1"}}
+	if err := Check(allowList, "3", 100, models.Bid{}); err == nil {
+		t.Errorf("Expected renter outside the allow list to be rejected")
+	}
+}
@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// Config holds the settings of a supplier agent.
+type Config struct {
+	Server        string          // base URL of the Cycloud server
+	APIKey        string          // API key of the supplier
+	StatePath     string          // file the registered resource ID is kept in across restarts
+	DataDir       string          // directory whose filesystem is offered as storage
+	Resource      models.Resource // spec the supplier sets, hardware facts are measured by the agent
+	AutoAvailable bool            // list the resource whenever it is idle
+	BenchmarkTime time.Duration   // duration of each CPU and memory benchmark pass
+}
+
+// Agent registers the machine it runs on as a resource and keeps it online with heartbeats.
+type Agent struct {
+	config   Config
+	client   *http.Client
+	rid      string
+	key      ed25519.PrivateKey // signs the hardware reports
+	interval time.Duration
+}
+
+type agentState struct {
+	RID     string `json:"rid"`
+	KeySeed []byte `json:"keySeed"`
+}
+
+type heartbeatStatus struct {
+	Available         bool    `json:"available"`
+	Computing         bool    `json:"computing"`
+	HeartbeatInterval float64 `json:"heartbeatInterval"` // in seconds
+}
+
+func New(config Config) *Agent {
+	config.Server = strings.TrimRight(config.Server, "/")
+	return &Agent{config: config, client: &http.Client{Timeout: 30 * time.Second}, interval: 30 * time.Second}
+}
+
+// post sends an authenticated JSON request to the server and decodes its JSON response into result
+func (a *Agent) post(path string, body interface{}, result interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, a.config.Server+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-API-Key", a.config.APIKey)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// loadState restores the registered resource ID and signing key, generating the key on the first run
+func (a *Agent) loadState() error {
+	var state agentState
+	encoded, err := os.ReadFile(a.config.StatePath)
+	if err == nil {
+		err = json.Unmarshal(encoded, &state)
+		if err != nil {
+			return errors.New("invalid agent state file: " + err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	a.rid = state.RID
+	if len(state.KeySeed) == ed25519.SeedSize {
+		a.key = ed25519.NewKeyFromSeed(state.KeySeed)
+		return nil
+	}
+	_, a.key, err = ed25519.GenerateKey(rand.Reader)
+	return err
+}
+
+func (a *Agent) saveState() error {
+	encoded, err := json.Marshal(agentState{RID: a.rid, KeySeed: a.key.Seed()})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.config.StatePath, encoded, 0600)
+}
+
+// withFacts returns the resource with the measured hardware facts
+func withFacts(resource models.Resource, facts models.HardwareFacts) models.Resource {
+	resource.CPUCores = facts.CPUCores
+	resource.Memory = facts.Memory
+	resource.Storage = facts.Storage
+	resource.CPUArch = facts.CPUArch
+	resource.CPUModel = facts.CPUModel
+	resource.OS = facts.OS
+	return resource
+}
+
+// Register measures the hardware and registers it, creating the resource on the first run
+func (a *Agent) Register() error {
+	facts, err := DetectHardware(a.config.DataDir)
+	if err != nil {
+		return err
+	}
+	if err = a.loadState(); err != nil {
+		return err
+	}
+
+	var registered struct {
+		RID               string  `json:"rid"`
+		HeartbeatInterval float64 `json:"heartbeatInterval"`
+	}
+	err = a.post("/register-agent", map[string]interface{}{
+		"rid":      a.rid,
+		"resource": withFacts(a.config.Resource, facts),
+		"agentKey": base64.StdEncoding.EncodeToString(a.key.Public().(ed25519.PublicKey)),
+	}, &registered)
+	if err != nil {
+		return errors.New("failed to register agent: " + err.Error())
+	}
+
+	a.rid = registered.RID
+	if registered.HeartbeatInterval > 0 {
+		a.interval = time.Duration(registered.HeartbeatInterval * float64(time.Second))
+	}
+	return a.saveState()
+}
+
+// ReportHardware measures the hardware, benchmarks it and sends the signed report
+func (a *Agent) ReportHardware() error {
+	facts, err := DetectHardware(a.config.DataDir)
+	if err != nil {
+		return err
+	}
+	benchmark, err := RunBenchmark(a.config.DataDir, a.config.BenchmarkTime)
+	if err != nil {
+		return errors.New("benchmark failed: " + err.Error())
+	}
+
+	report, err := json.Marshal(models.HardwareReport{RID: a.rid, Facts: facts, Benchmark: benchmark, MeasuredAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Verified    bool                        `json:"verified"`
+		Divergences []models.HardwareDivergence `json:"divergences"`
+	}
+	err = a.post("/agent-hardware-report/"+a.rid, map[string]interface{}{
+		"report":    json.RawMessage(report),
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(a.key, report)),
+	}, &result)
+	if err != nil {
+		return errors.New("failed to send hardware report: " + err.Error())
+	}
+
+	if result.Verified {
+		log.Printf("Hardware verified: %d cores, %d GB memory, %d GB storage, CPU %.0f MB/s, memory %.0f MB/s, disk %.0f MB/s",
+			facts.CPUCores, facts.Memory, facts.Storage, benchmark.CPUScore, benchmark.MemoryBandwidth, benchmark.DiskWrite)
+	}
+	for _, divergence := range result.Divergences {
+		log.Printf("Claimed %s %s diverges from the measured %s", divergence.Field, divergence.Claimed, divergence.Measured)
+	}
+	return nil
+}
+
+// Heartbeat reports the agent online, available asks for the resource to be listed or unlisted when not nil
+func (a *Agent) Heartbeat(available *bool) (heartbeatStatus, error) {
+	var status heartbeatStatus
+	err := a.post("/agent-heartbeat/"+a.rid, map[string]interface{}{"available": available}, &status)
+	return status, err
+}
+
+// Run registers the agent and sends heartbeats until ctx is done, the resource is unlisted on the way out
+func (a *Agent) Run(ctx context.Context) error {
+	err := a.Register()
+	if err != nil {
+		return err
+	}
+	log.Printf("Registered as resource %s, sending heartbeats every %v", a.rid, a.interval)
+
+	// An unverified resource is still listed, the report only earns it the verified badge
+	if err = a.ReportHardware(); err != nil {
+		log.Printf("Hardware verification failed: %v", err)
+	}
+
+	var available *bool
+	if a.config.AutoAvailable {
+		available = new(bool)
+		*available = true
+	}
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		status, err := a.Heartbeat(available)
+		if err != nil {
+			log.Printf("Heartbeat failed: %v", err)
+		} else if status.HeartbeatInterval > 0 {
+			interval := time.Duration(status.HeartbeatInterval * float64(time.Second))
+			if interval != a.interval {
+				a.interval = interval
+				ticker.Reset(interval)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			unavailable := false
+			if _, err := a.Heartbeat(&unavailable); err != nil {
+				log.Printf("Failed to unlist resource %s: %v", a.rid, err)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const (
+	bytesPerMB         = 1 << 20
+	cpuBenchmarkBlock  = 1 * bytesPerMB
+	memoryBenchmarkBuf = 64 * bytesPerMB
+	diskBenchmarkChunk = 4 * bytesPerMB
+	diskBenchmarkSize  = 64 * bytesPerMB
+)
+
+// benchmarkCPU returns the SHA-256 throughput of all cores hashing for duration, in MB/s
+func benchmarkCPU(duration time.Duration) float64 {
+	workers := runtime.NumCPU()
+	hashed := make([]int, workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			block := make([]byte, cpuBenchmarkBlock)
+			for time.Since(start) < duration {
+				sum := sha256.Sum256(block)
+				block[0] = sum[0]
+				hashed[worker] += len(block)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, bytes := range hashed {
+		total += bytes
+	}
+	return float64(total) / bytesPerMB / time.Since(start).Seconds()
+}
+
+// benchmarkMemory returns the bandwidth of copying a buffer larger than the CPU caches for duration, in MB/s
+func benchmarkMemory(duration time.Duration) float64 {
+	source := make([]byte, memoryBenchmarkBuf)
+	destination := make([]byte, memoryBenchmarkBuf)
+	for i := range source {
+		source[i] = byte(i)
+	}
+
+	copied := 0
+	start := time.Now()
+	for time.Since(start) < duration {
+		copied += copy(destination, source)
+	}
+	// Reading and writing both move the buffer through memory
+	return float64(2*copied) / bytesPerMB / time.Since(start).Seconds()
+}
+
+// benchmarkDisk returns the sequential write throughput of a synced file in dir, in MB/s
+func benchmarkDisk(dir string) (float64, error) {
+	file, err := os.CreateTemp(dir, "cycloud-benchmark-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	chunk := make([]byte, diskBenchmarkChunk)
+	for i := range chunk {
+		chunk[i] = byte(i * 31)
+	}
+	start := time.Now()
+	for written := 0; written < diskBenchmarkSize; written += len(chunk) {
+		if _, err := file.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return float64(diskBenchmarkSize) / bytesPerMB / time.Since(start).Seconds(), nil
+}
+
+// RunBenchmark runs the short CPU, memory and disk benchmark, each CPU and memory pass lasts for duration
+func RunBenchmark(dataDir string, duration time.Duration) (models.Benchmark, error) {
+	var benchmark models.Benchmark
+	benchmark.CPUScore = benchmarkCPU(duration)
+	benchmark.MemoryBandwidth = benchmarkMemory(duration)
+
+	diskWrite, err := benchmarkDisk(dataDir)
+	if err != nil {
+		return benchmark, err
+	}
+	benchmark.DiskWrite = diskWrite
+	return benchmark, nil
+}
@@ -0,0 +1,12 @@
+package agent
+
+import "syscall"
+
+// diskSize returns the size in bytes of the filesystem holding path
+func diskSize(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Blocks * uint64(stat.Bsize), nil
+}
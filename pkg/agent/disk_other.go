@@ -0,0 +1,10 @@
+//go:build !linux
+
+package agent
+
+import "errors"
+
+// diskSize returns the size in bytes of the filesystem holding path
+func diskSize(path string) (uint64, error) {
+	return 0, errors.New("storage detection is only supported on linux")
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const bytesPerGB = 1 << 30
+
+// parseMemInfo returns the total memory in GB from the content of /proc/meminfo
+func parseMemInfo(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, errors.New("invalid MemTotal in meminfo")
+			}
+			// Round to the nearest GB, part of the memory is reserved by the kernel
+			return int((kb*1024 + bytesPerGB/2) / bytesPerGB), nil
+		}
+	}
+	return 0, errors.New("MemTotal not found in meminfo")
+}
+
+// parseCPUModel returns the CPU model name from the content of /proc/cpuinfo
+func parseCPUModel(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "model name", "Model", "cpu model":
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// parseOSRelease returns the OS image, e.g. "ubuntu-22.04", from the content of /etc/os-release
+func parseOSRelease(r io.Reader) string {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found {
+			values[key] = strings.Trim(value, `"'`)
+		}
+	}
+	if values["ID"] == "" {
+		return ""
+	}
+	if values["VERSION_ID"] == "" {
+		return values["ID"]
+	}
+	return values["ID"] + "-" + values["VERSION_ID"]
+}
+
+func readFile(path string, parse func(io.Reader) string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	return parse(file)
+}
+
+// DetectHardware measures the CPU, memory, storage and OS of the machine the agent runs on. Storage is the size of
+// the filesystem holding dataDir.
+func DetectHardware(dataDir string) (models.HardwareFacts, error) {
+	var facts models.HardwareFacts
+	facts.CPUCores = runtime.NumCPU()
+	facts.CPUArch = runtime.GOARCH
+
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return facts, errors.New("failed to read memory size: " + err.Error())
+	}
+	facts.Memory, err = parseMemInfo(file)
+	file.Close()
+	if err != nil {
+		return facts, err
+	}
+
+	storage, err := diskSize(dataDir)
+	if err != nil {
+		return facts, errors.New("failed to read storage size: " + err.Error())
+	}
+	facts.Storage = int(storage / bytesPerGB)
+
+	facts.CPUModel = readFile("/proc/cpuinfo", parseCPUModel)
+	facts.OS = readFile("/etc/os-release", parseOSRelease)
+	return facts, nil
+}
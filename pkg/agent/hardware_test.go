@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMemInfo(t *testing.T) {
+	memory, err := parseMemInfo(strings.NewReader("MemTotal:       16303580 kB\nMemFree:         1200000 kB\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse meminfo: %v", err)
+	}
+	if memory != 16 {
+		t.Errorf("Expected 16 GB, got %d", memory)
+	}
+
+	if _, err := parseMemInfo(strings.NewReader("MemFree: 1 kB\n")); err == nil {
+		t.Errorf("Expected an error without MemTotal")
+	}
+}
+
+func TestParseCPUModel(t *testing.T) {
+	cpuinfo := "processor\t: 0\nvendor_id\t: AuthenticAMD\nmodel\t\t: 1\nmodel name\t: AMD EPYC 7763 64-Core Processor\n"
+	if model := parseCPUModel(strings.NewReader(cpuinfo)); model != "AMD EPYC 7763 64-Core Processor" {
+		t.Errorf("Unexpected CPU model %q", model)
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	release := "NAME=\"Ubuntu\"\nVERSION_ID=\"22.04\"\nID=ubuntu\n"
+	if image := parseOSRelease(strings.NewReader(release)); image != "ubuntu-22.04" {
+		t.Errorf("Expected ubuntu-22.04, got %q", image)
+	}
+	if image := parseOSRelease(strings.NewReader("ID=arch\n")); image != "arch" {
+		t.Errorf("Expected arch, got %q", image)
+	}
+}
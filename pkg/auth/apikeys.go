@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// APIKeyPrefix marks a string as a Cycloud API key
+const APIKeyPrefix = "cyk_"
+
+// DefaultAPIKeyScopes are given to keys created without scopes, they fit the supplier agent
+var DefaultAPIKeyScopes = []string{string(PermissionRunAgents)}
+
+// GenerateAPIKey returns a new random API key and the hash it is stored under
+func GenerateAPIKey() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	key := APIKeyPrefix + hex.EncodeToString(secret)
+	return key, HashString(key), nil
+}
+
+// ValidateScopes checks that every scope of an API key is a permission granted by the roles of its owner
+func ValidateScopes(scopes []string, roles []string) error {
+	if len(scopes) == 0 {
+		return errors.New("api key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !knownPermission(Permission(scope)) {
+			return errors.New("unknown scope " + scope)
+		}
+		if !HasPermission(roles, Permission(scope)) {
+			return errors.New("scope " + scope + " is not granted by the roles of the user")
+		}
+	}
+	return nil
+}
+
+// ScopesAllow reports whether the scopes of an API key include the permission
+func ScopesAllow(scopes []string, permission Permission) bool {
+	for _, scope := range scopes {
+		if Permission(scope) == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to generate API key: %v", err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) != len(APIKeyPrefix)+64 {
+		t.Errorf("Unexpected API key format %q", key)
+	}
+	if hash != HashString(key) || hash == key {
+		t.Errorf("Expected the key to be stored hashed")
+	}
+
+	other, _, _ := GenerateAPIKey()
+	if other == key {
+		t.Errorf("Expected distinct API keys")
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	supplier := []string{RoleSupplier}
+	tests := []struct {
+		scopes  []string
+		roles   []string
+		wantErr bool
+	}{
+		{nil, supplier, true},
+		{DefaultAPIKeyScopes, supplier, false},
+		{[]string{"resources:supply", "resources:browse"}, supplier, false},
+		{[]string{"resources:rent"}, supplier, true},
+		{[]string{"resources:write"}, supplier, true},
+		{[]string{"admin:stats"}, supplier, true},
+		{[]string{"admin:stats"}, []string{RoleAdmin}, false},
+	}
+	for _, test := range tests {
+		err := ValidateScopes(test.scopes, test.roles)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ValidateScopes(%v, %v) = %v, wantErr %v", test.scopes, test.roles, err, test.wantErr)
+		}
+	}
+}
+
+func TestScopesAllow(t *testing.T) {
+	scopes := []string{string(PermissionBrowseResources), string(PermissionRunAgents)}
+	if !ScopesAllow(scopes, PermissionRunAgents) {
+		t.Errorf("Expected scopes %v to allow %s", scopes, PermissionRunAgents)
+	}
+	if ScopesAllow(scopes, PermissionSupplyResources) {
+		t.Errorf("Expected scopes %v not to allow %s", scopes, PermissionSupplyResources)
+	}
+}
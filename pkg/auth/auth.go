@@ -3,29 +3,34 @@ package auth
 import (
  "time"
  "fmt"
- "crypto/rand"
  "crypto/sha256"
  "encoding/hex"
 
  "github.com/golang-jwt/jwt"
 )
 
-// Generate a random key for signing JWTs
-func generateRandomKey() ([]byte, error) {
- key := make([]byte, 32) // 256-bit key
- if _, err := rand.Read(key); err != nil {
-    return nil, err
- }
- return key, nil
+// tokenLifetime is the longest a JWT is valid
+const tokenLifetime = 1 * time.Hour
+
+// keyRing signs and verifies the JWTs, until SetKeyRing is called it keeps random HS256 keys in memory
+var keyRing = NewKeyRing(&memoryKeyStore{keys: map[string]StoredKey{}}, HS256, defaultKeyRotation, defaultKeyOverlap)
+
+// SetKeyRing sets the key ring JWTs are signed and verified with
+func SetKeyRing(ring *KeyRing) {
+ keyRing = ring
 }
 
-var jwtKey, _ = generateRandomKey() // Generate a strong, randomly generated key
+// JWKS returns the public keys JWTs are verified with as a JSON Web Key Set
+func JWKS() map[string]interface{} {
+ return keyRing.JWKS()
+}
 
 // Claims struct to define the claims in the JWT
 type Claims struct {
     Username string `json:"username"`
-    Role     string `json:"role"` // e.g., "supplier" or "user"
-    jwt.StandardClaims
+    Roles    []string `json:"roles"` // e.g., "renter" and "supplier"
+    SessionID string `json:"sid"` // the session the token was issued for
+    jwt.StandardClaims // the subject is the user the session belongs to
 }
 
 // HashString generates a SHA-256 hash of the input string
@@ -35,21 +40,22 @@ func HashString(input string) string {
     return hex.EncodeToString(hash.Sum(nil))
 }
 
-// GenerateJWT generates a new JWT token for the given user
-func GenerateJWT(username, role string) (string, error) {
+// GenerateJWT generates a new short-lived access token for the given user's session
+func GenerateJWT(username string, roles []string, uid string, sid string) (string, error) {
     
     
-    expirationTime := time.Now().Add(1 * time.Hour) // Token expires in 1 hour
+    expirationTime := time.Now().Add(AccessTokenLifetime())
     claims := &Claims{
     Username: username,
-    Role:     role,
+    Roles:    roles,
+    SessionID: sid,
     StandardClaims: jwt.StandardClaims{
+    Subject: uid,
     ExpiresAt: expirationTime.Unix(),
     },
     }
 
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    tokenString, err := token.SignedString(jwtKey)
+    tokenString, err := keyRing.Sign(claims)
     if err != nil {
     return "", err
     }
@@ -60,12 +66,7 @@ func GenerateJWT(username, role string) (string, error) {
 // ValidateJWT validates the given JWT token
 func ValidateJWT(tokenString string) (*Claims, error) {
  claims := &Claims{}
- token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-  if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-   return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-  }
-  return jwtKey, nil
- })
+ token, err := keyRing.Verify(tokenString, claims)
 
  if err != nil {
   return nil, err
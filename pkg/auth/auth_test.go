@@ -1,6 +1,7 @@
 package auth
 
 import (
+ "reflect"
  "testing"
  "time"
 
@@ -9,16 +10,16 @@ import (
 
 func TestGenerateJWT(t *testing.T) {
  username := "testuser"
- role := "user"
- tokenString, err := GenerateJWT(username, role)
+ roles := []string{RoleRenter, RoleSupplier}
+ uid := "7"
+ sid := "1"
+ tokenString, err := GenerateJWT(username, roles, uid, sid)
  if err != nil {
   t.Fatalf("Failed to generate JWT: %v", err)
  }
 
  // Parse the token to check the claims
- token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-  return jwtKey, nil
- })
+ token, err := keyRing.Verify(tokenString, &Claims{})
  if err != nil {
   t.Fatalf("Failed to parse JWT: %v", err)
  }
@@ -27,8 +28,14 @@ func TestGenerateJWT(t *testing.T) {
   if claims.Username != username {
    t.Errorf("Expected username %s, got %s", username, claims.Username)
   }
-  if claims.Role != role {
-   t.Errorf("Expected role %s, got %s", role, claims.Role)
+  if !reflect.DeepEqual(claims.Roles, roles) {
+   t.Errorf("Expected roles %v, got %v", roles, claims.Roles)
+  }
+  if claims.Subject != uid {
+   t.Errorf("Expected user %s, got %s", uid, claims.Subject)
+  }
+  if claims.SessionID != sid {
+   t.Errorf("Expected session %s, got %s", sid, claims.SessionID)
   }
  } else {
   t.Errorf("Invalid JWT token")
@@ -38,8 +45,8 @@ func TestGenerateJWT(t *testing.T) {
 func TestValidateJWT(t *testing.T) {
  // Generate a valid token
  username := "testuser"
- role := "user"
- tokenString, _ := GenerateJWT(username, role)
+ roles := []string{RoleRenter, RoleSupplier}
+ tokenString, _ := GenerateJWT(username, roles, "7", "1")
 
  // Validate the token
  claims, err := ValidateJWT(tokenString)
@@ -50,15 +57,14 @@ func TestValidateJWT(t *testing.T) {
  if claims.Username != username {
   t.Errorf("Expected username %s, got %s", username, claims.Username)
  }
- if claims.Role != role {
-  t.Errorf("Expected role %s, got %s", role, claims.Role)
+ if !reflect.DeepEqual(claims.Roles, roles) {
+  t.Errorf("Expected roles %v, got %v", roles, claims.Roles)
  }
 
  // Test with an expired token
- expiredToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+ expiredTokenString, _ := keyRing.Sign(jwt.StandardClaims{
   ExpiresAt: time.Now().Add(-1 * time.Hour).Unix(),
  })
- expiredTokenString, _ := expiredToken.SignedString(jwtKey)
 
  _, err = ValidateJWT(expiredTokenString)
  if err == nil {
@@ -0,0 +1,497 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+)
+
+// Signing algorithms of the JWT keys, chosen with JWT_ALGORITHM
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	defaultKeyRotation = 7 * 24 * time.Hour
+	defaultKeyOverlap  = 24 * time.Hour
+	minKeyOverlap      = tokenLifetime // retired keys must outlive the tokens they signed
+	keyReloadInterval  = 10 * time.Second
+	rsaKeyBits         = 2048
+)
+
+// StoredKey is a JWT signing key as kept by a KeyStore.
+type StoredKey struct {
+	KID       string     `json:"kid"`
+	Algorithm string     `json:"alg"`
+	Material  []byte     `json:"material"` // the HMAC secret or the PKCS #8 private key
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"` // when a newer key took over signing
+}
+
+// KeyStore keeps the JWT signing keys shared by the servers.
+type KeyStore interface {
+	LoadKeys() ([]StoredKey, error)
+	SaveKey(key StoredKey) error // inserts or replaces the key with the same kid
+	DeleteKey(kid string) error
+}
+
+// KeyStoreLocker is a KeyStore shared by servers that keeps them from rotating at the same time, two servers whose key
+// is due at once would otherwise both generate a new one.
+type KeyStoreLocker interface {
+	LockKeys() (func(), error) // waits until no other server rotates, calling the returned function lets them
+}
+
+// FileKeyStore keeps the JWT signing keys in a JSON file only the server can read.
+type FileKeyStore struct {
+	Path string
+}
+
+func (s FileKeyStore) LoadKeys() ([]StoredKey, error) {
+	content, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return []StoredKey{}, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read key file")
+	}
+	keys := []StoredKey{}
+	if err = json.Unmarshal(content, &keys); err != nil {
+		return nil, errors.New("failed to decode key file")
+	}
+	return keys, nil
+}
+
+func (s FileKeyStore) writeKeys(keys []StoredKey) error {
+	content, err := json.Marshal(keys)
+	if err != nil {
+		return errors.New("failed to encode keys")
+	}
+
+	// Replace the file at once so a reader never sees it half written
+	file, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return errors.New("failed to write key file")
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		return errors.New("failed to write key file")
+	}
+	if err = file.Close(); err != nil {
+		return errors.New("failed to write key file")
+	}
+	if err = os.Rename(file.Name(), s.Path); err != nil {
+		return errors.New("failed to write key file")
+	}
+	return nil
+}
+
+func (s FileKeyStore) SaveKey(key StoredKey) error {
+	keys, err := s.LoadKeys()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if keys[i].KID == key.KID {
+			keys[i] = key
+			return s.writeKeys(keys)
+		}
+	}
+	return s.writeKeys(append(keys, key))
+}
+
+func (s FileKeyStore) DeleteKey(kid string) error {
+	keys, err := s.LoadKeys()
+	if err != nil {
+		return err
+	}
+	kept := []StoredKey{}
+	for _, key := range keys {
+		if key.KID != kid {
+			kept = append(kept, key)
+		}
+	}
+	return s.writeKeys(kept)
+}
+
+// memoryKeyStore keeps the keys of a single process, tokens do not survive a restart
+type memoryKeyStore struct {
+	mutex sync.Mutex
+	keys  map[string]StoredKey
+}
+
+func (s *memoryKeyStore) LoadKeys() ([]StoredKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := []StoredKey{}
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *memoryKeyStore) SaveKey(key StoredKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys[key.KID] = key
+	return nil
+}
+
+func (s *memoryKeyStore) DeleteKey(kid string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.keys, kid)
+	return nil
+}
+
+// GenerateKey returns a new random signing key for the algorithm
+func GenerateKey(algorithm string, now time.Time) (StoredKey, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return StoredKey{}, err
+	}
+	key := StoredKey{KID: hex.EncodeToString(id), Algorithm: algorithm, CreatedAt: now}
+
+	var private interface{}
+	switch algorithm {
+	case HS256:
+		key.Material = make([]byte, 32) // 256-bit key
+		if _, err := rand.Read(key.Material); err != nil {
+			return key, err
+		}
+		return key, nil
+	case RS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return key, err
+		}
+		private = rsaKey
+	case EdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return key, err
+		}
+		private = edKey
+	default:
+		return key, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+
+	material, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return key, err
+	}
+	key.Material = material
+	return key, nil
+}
+
+// signingKey is a StoredKey decoded for signing and verifying
+type signingKey struct {
+	StoredKey
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func decodeKey(key StoredKey) (signingKey, error) {
+	decoded := signingKey{StoredKey: key}
+	if key.Algorithm == HS256 {
+		decoded.method, decoded.signKey, decoded.verifyKey = jwt.SigningMethodHS256, key.Material, key.Material
+		return decoded, nil
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(key.Material)
+	if err != nil {
+		return decoded, errors.New("invalid key material")
+	}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if key.Algorithm == RS256 {
+			decoded.method, decoded.signKey, decoded.verifyKey = jwt.SigningMethodRS256, private, &private.PublicKey
+			return decoded, nil
+		}
+	case ed25519.PrivateKey:
+		if key.Algorithm == EdDSA {
+			decoded.method, decoded.signKey, decoded.verifyKey = jwt.SigningMethodEdDSA, private, private.Public()
+			return decoded, nil
+		}
+	}
+	return decoded, errors.New("key material does not match its algorithm")
+}
+
+// KeyRing signs tokens with its newest key and verifies them with every key still in its overlap window, so tokens
+// outlive a rotation and are accepted by all the servers sharing the key store.
+type KeyRing struct {
+	mutex     sync.RWMutex
+	store     KeyStore
+	algorithm string
+	rotation  time.Duration // how long a key signs before a new one replaces it
+	overlap   time.Duration // how long a replaced key keeps verifying tokens
+	keys      map[string]signingKey
+	current   string
+	loadedAt  time.Time
+	rotating  sync.Mutex
+}
+
+func NewKeyRing(store KeyStore, algorithm string, rotation time.Duration, overlap time.Duration) *KeyRing {
+	if overlap < minKeyOverlap {
+		overlap = minKeyOverlap
+	}
+	return &KeyRing{store: store, algorithm: algorithm, rotation: rotation, overlap: overlap, keys: map[string]signingKey{}}
+}
+
+// KeyRingFromEnv returns a key ring over the store using JWT_ALGORITHM (EdDSA by default), JWT_KEY_ROTATION_HOURS and
+// JWT_KEY_OVERLAP_HOURS.
+func KeyRingFromEnv(store KeyStore) (*KeyRing, error) {
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	switch algorithm {
+	case "":
+		algorithm = EdDSA
+	case HS256, RS256, EdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %s, expected one of HS256, RS256, EdDSA", algorithm)
+	}
+	return NewKeyRing(store, algorithm, env.Duration("JWT_KEY_ROTATION_HOURS", defaultKeyRotation, time.Hour, env.Positive), env.Duration("JWT_KEY_OVERLAP_HOURS", defaultKeyOverlap, time.Hour, env.Positive)), nil
+}
+
+// reload replaces the keys of the ring with the ones of the store, dropping keys past their overlap window
+func (k *KeyRing) reload(now time.Time) error {
+	stored, err := k.store.LoadKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]signingKey{}
+	current := ""
+	for _, key := range stored {
+		if key.RetiredAt != nil && key.RetiredAt.Add(k.overlap).Before(now) {
+			continue
+		}
+		decoded, err := decodeKey(key)
+		if err != nil {
+			log.Printf("Skipping JWT key %s: %v", key.KID, err)
+			continue
+		}
+		keys[key.KID] = decoded
+		if key.RetiredAt == nil && (current == "" || key.CreatedAt.After(keys[current].CreatedAt)) {
+			current = key.KID
+		}
+	}
+
+	k.mutex.Lock()
+	k.keys, k.current, k.loadedAt = keys, current, now
+	k.mutex.Unlock()
+	return nil
+}
+
+// due reports whether the ring has no signing key, its signing key is past the rotation or uses another algorithm
+// than the ring's
+func (k *KeyRing) due(now time.Time) bool {
+	k.mutex.RLock()
+	current, ok := k.keys[k.current]
+	k.mutex.RUnlock()
+	return !ok || current.Algorithm != k.algorithm || !current.CreatedAt.Add(k.rotation).After(now)
+}
+
+// lockRotation keeps the other rotations of the process, and of the servers sharing a KeyStoreLocker, out until the
+// returned function is called
+func (k *KeyRing) lockRotation() (func(), error) {
+	k.rotating.Lock()
+	locker, ok := k.store.(KeyStoreLocker)
+	if !ok {
+		return k.rotating.Unlock, nil
+	}
+	unlock, err := locker.LockKeys()
+	if err != nil {
+		k.rotating.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		k.rotating.Unlock()
+	}, nil
+}
+
+// Load reads the keys of the store, then rotates when the signing key is due
+func (k *KeyRing) Load(now time.Time) error {
+	if err := k.reload(now); err != nil {
+		return err
+	}
+	if !k.due(now) {
+		return nil
+	}
+
+	unlock, err := k.lockRotation()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another server may have rotated while this one waited for the lock
+	if err = k.reload(now); err != nil {
+		return err
+	}
+	if !k.due(now) {
+		return nil
+	}
+	return k.rotate(now)
+}
+
+// Rotate makes a new key the signing key. The previous keys keep verifying tokens for the overlap window, after
+// which they are deleted from the store.
+func (k *KeyRing) Rotate(now time.Time) error {
+	unlock, err := k.lockRotation()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return k.rotate(now)
+}
+
+// rotate replaces the signing key, the caller holds the rotation lock
+func (k *KeyRing) rotate(now time.Time) error {
+	key, err := GenerateKey(k.algorithm, now)
+	if err != nil {
+		return err
+	}
+	if err = k.store.SaveKey(key); err != nil {
+		return err
+	}
+
+	stored, err := k.store.LoadKeys()
+	if err != nil {
+		return err
+	}
+	for _, old := range stored {
+		switch {
+		case old.KID == key.KID:
+		case old.RetiredAt == nil:
+			retiredAt := now
+			old.RetiredAt = &retiredAt
+			err = k.store.SaveKey(old)
+		case old.RetiredAt.Add(k.overlap).Before(now):
+			err = k.store.DeleteKey(old.KID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return k.reload(now)
+}
+
+// Sign returns the token of the claims signed with the current key, named by the kid header
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	k.mutex.RLock()
+	key, ok := k.keys[k.current]
+	k.mutex.RUnlock()
+	if !ok {
+		if err := k.Load(time.Now()); err != nil {
+			return "", err
+		}
+		k.mutex.RLock()
+		key, ok = k.keys[k.current]
+		k.mutex.RUnlock()
+		if !ok {
+			return "", errors.New("no signing key")
+		}
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.signKey)
+}
+
+// lookup returns the key of a kid, reading the store again when the kid is unknown in case another server rotated
+func (k *KeyRing) lookup(kid string) (signingKey, bool) {
+	k.mutex.RLock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.loadedAt) > keyReloadInterval
+	k.mutex.RUnlock()
+	if ok || !stale {
+		return key, ok
+	}
+
+	if err := k.reload(time.Now()); err != nil {
+		log.Printf("Failed to reload JWT keys: %v", err)
+		return key, false
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok = k.keys[kid]
+	return key, ok
+}
+
+// Verify parses a token into claims, checking its signature with the key named by its kid header
+func (k *KeyRing) Verify(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verifyKey, nil
+	})
+}
+
+// JWKS returns the public keys of the ring as a JSON Web Key Set. HS256 keys are secret and never published.
+func (k *KeyRing) JWKS() map[string]interface{} {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	kids := []string{}
+	for kid := range k.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := []map[string]string{}
+	for _, kid := range kids {
+		jwk := map[string]string{"kid": kid, "alg": k.keys[kid].Algorithm, "use": "sig"}
+		switch public := k.keys[kid].verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// RunRotation periodically picks up the keys rotated by other servers and rotates the signing key when it is due.
+// It never returns.
+func (k *KeyRing) RunRotation(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := k.Load(time.Now()); err != nil {
+			log.Printf("Failed to rotate JWT keys: %v", err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	for _, algorithm := range []string{HS256, RS256, EdDSA} {
+		store := FileKeyStore{Path: filepath.Join(t.TempDir(), "keys.json")}
+		ring := NewKeyRing(store, algorithm, time.Hour, 2*time.Hour)
+		now := time.Now()
+		if err := ring.Load(now); err != nil {
+			t.Fatalf("Failed to load %s key ring: %v", algorithm, err)
+		}
+
+		claims := jwt.StandardClaims{Subject: "1", ExpiresAt: now.Add(time.Hour).Unix()}
+		before, err := ring.Sign(claims)
+		if err != nil {
+			t.Fatalf("Failed to sign with %s: %v", algorithm, err)
+		}
+
+		// Another server sharing the store verifies the token and rotates after the rotation interval
+		other := NewKeyRing(store, algorithm, time.Hour, 2*time.Hour)
+		if _, err = other.Verify(before, &jwt.StandardClaims{}); err != nil {
+			t.Errorf("Expected %s token to verify on another server, got %v", algorithm, err)
+		}
+		if err = other.Load(now.Add(90 * time.Minute)); err != nil {
+			t.Fatalf("Failed to rotate %s key ring: %v", algorithm, err)
+		}
+		after, _ := other.Sign(claims)
+
+		// The first server picks the new key up, the old one still verifies during the overlap
+		if err = ring.Load(now.Add(91 * time.Minute)); err != nil {
+			t.Fatalf("Failed to reload %s key ring: %v", algorithm, err)
+		}
+		for _, token := range []string{before, after} {
+			if _, err = ring.Verify(token, &jwt.StandardClaims{}); err != nil {
+				t.Errorf("Expected %s token to verify during the overlap, got %v", algorithm, err)
+			}
+		}
+
+		// Past the overlap the retired key is gone
+		if err = ring.Load(now.Add(4 * time.Hour)); err != nil {
+			t.Fatalf("Failed to reload %s key ring: %v", algorithm, err)
+		}
+		if _, err = ring.Verify(before, &jwt.StandardClaims{}); err == nil {
+			t.Errorf("Expected %s token of a retired key to be rejected after the overlap", algorithm)
+		}
+		// Only the new key and the one it just replaced are left in the store
+		keys, _ := store.LoadKeys()
+		if len(keys) != 2 {
+			t.Errorf("Expected retired %s keys past the overlap to be deleted, got %d keys", algorithm, len(keys))
+		}
+	}
+}
+
+// lockingKeyStore is a shared key store whose lock lets another server rotate while the caller waits for it
+type lockingKeyStore struct {
+	*memoryKeyStore
+	whileWaiting func()
+}
+
+func (s *lockingKeyStore) LockKeys() (func(), error) {
+	if s.whileWaiting != nil {
+		s.whileWaiting()
+		s.whileWaiting = nil
+	}
+	return func() {}, nil
+}
+
+func TestKeyRingRotatesOnceAcrossServers(t *testing.T) {
+	shared := &memoryKeyStore{keys: map[string]StoredKey{}}
+	store := &lockingKeyStore{memoryKeyStore: shared}
+	ring := NewKeyRing(store, EdDSA, time.Hour, 2*time.Hour)
+	other := NewKeyRing(shared, EdDSA, time.Hour, 2*time.Hour)
+	now := time.Now()
+	if err := ring.Load(now); err != nil {
+		t.Fatalf("Failed to load key ring: %v", err)
+	}
+
+	// Both servers find the key due, the other one gets the lock first
+	due := now.Add(90 * time.Minute)
+	store.whileWaiting = func() {
+		if err := other.Load(due); err != nil {
+			t.Fatalf("Failed to rotate key ring: %v", err)
+		}
+	}
+	if err := ring.Load(due); err != nil {
+		t.Fatalf("Failed to load key ring: %v", err)
+	}
+
+	keys, _ := shared.LoadKeys()
+	if len(keys) != 2 {
+		t.Errorf("Expected a single rotation, got %d keys", len(keys))
+	}
+	if ring.current != other.current {
+		t.Errorf("Expected both servers to sign with the rotated key, got %s and %s", ring.current, other.current)
+	}
+}
+
+func TestKeyRingRejectsForeignTokens(t *testing.T) {
+	ring := NewKeyRing(&memoryKeyStore{keys: map[string]StoredKey{}}, EdDSA, time.Hour, time.Hour)
+	foreign := NewKeyRing(&memoryKeyStore{keys: map[string]StoredKey{}}, EdDSA, time.Hour, time.Hour)
+	token, err := foreign.Sign(jwt.StandardClaims{})
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	ring.Load(time.Now())
+	if _, err = ring.Verify(token, &jwt.StandardClaims{}); err == nil {
+		t.Errorf("Expected token of an unknown key to be rejected")
+	}
+
+	// A token signed with HS256 using the public key as secret must not pass as the EdDSA key
+	kid := ring.current
+	public := ring.keys[kid].verifyKey
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{})
+	forged.Header["kid"] = kid
+	forgedString, _ := forged.SignedString([]byte(public.(ed25519.PublicKey)))
+	if _, err = ring.Verify(forgedString, &jwt.StandardClaims{}); err == nil {
+		t.Errorf("Expected token with another algorithm than its key to be rejected")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ring := NewKeyRing(&memoryKeyStore{keys: map[string]StoredKey{}}, RS256, time.Hour, time.Hour)
+	if err := ring.Load(time.Now()); err != nil {
+		t.Fatalf("Failed to load key ring: %v", err)
+	}
+	ring.algorithm = EdDSA
+	if err := ring.Rotate(time.Now()); err != nil {
+		t.Fatalf("Failed to rotate key ring: %v", err)
+	}
+	ring.algorithm = HS256
+	if err := ring.Rotate(time.Now()); err != nil {
+		t.Fatalf("Failed to rotate key ring: %v", err)
+	}
+
+	keys := ring.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 2 {
+		t.Fatalf("Expected the RSA and Ed25519 keys only, got %v", keys)
+	}
+	for _, key := range keys {
+		switch key["kty"] {
+		case "RSA":
+			if key["alg"] != RS256 || key["n"] == "" || key["e"] != "AQAB" {
+				t.Errorf("Unexpected RSA key %v", key)
+			}
+		case "OKP":
+			if key["alg"] != EdDSA || key["crv"] != "Ed25519" || key["x"] == "" {
+				t.Errorf("Unexpected Ed25519 key %v", key)
+			}
+		default:
+			t.Errorf("Unexpected key %v", key)
+		}
+	}
+}
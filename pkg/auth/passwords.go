@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms, chosen with PASSWORD_HASH_ALGORITHM
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+const (
+	defaultArgon2Memory  = 64 * 1024 // in KiB
+	defaultArgon2Time    = 1
+	defaultArgon2Threads = 4
+	argon2SaltLength     = 16
+	argon2KeyLength      = 32
+)
+
+// PasswordPolicy holds the algorithm and cost new password hashes are made with.
+type PasswordPolicy struct {
+	Algorithm     string
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+	BcryptCost    int
+}
+
+// fitsUint accepts the counts that fit an unsigned integer of the given bits
+func fitsUint(bits int) func(float64) bool {
+	return func(value float64) bool {
+		return env.Count(value) && value < math.Exp2(float64(bits))
+	}
+}
+
+// LoadPasswordPolicy reads the password hashing policy from the environment, falling back to argon2id defaults.
+func LoadPasswordPolicy() PasswordPolicy {
+	policy := PasswordPolicy{
+		Algorithm:     Argon2id,
+		Argon2Memory:  uint32(env.Number("ARGON2_MEMORY_KIB", defaultArgon2Memory, fitsUint(32))),
+		Argon2Time:    uint32(env.Number("ARGON2_TIME", defaultArgon2Time, fitsUint(32))),
+		Argon2Threads: uint8(env.Number("ARGON2_THREADS", defaultArgon2Threads, fitsUint(8))),
+		BcryptCost:    int(env.Number("BCRYPT_COST", float64(bcrypt.DefaultCost), fitsUint(8))),
+	}
+	if os.Getenv("PASSWORD_HASH_ALGORITHM") == Bcrypt {
+		policy.Algorithm = Bcrypt
+	}
+	if policy.BcryptCost < bcrypt.MinCost || policy.BcryptCost > bcrypt.MaxCost {
+		policy.BcryptCost = bcrypt.DefaultCost
+	}
+	return policy
+}
+
+// HashPassword hashes a password with a random salt. The hash carries its algorithm and parameters, e.g.
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>.
+func (p PasswordPolicy) HashPassword(password string) (string, error) {
+	if p.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		if err != nil {
+			return "", errors.New("failed to hash password")
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.New("failed to hash password")
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, argon2KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2Hash is a decoded argon2id password hash
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func decodeArgon2Hash(hash string) (argon2Hash, error) {
+	var decoded argon2Hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return decoded, errors.New("invalid password hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return decoded, errors.New("invalid password hash")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.memory, &decoded.time, &decoded.threads); err != nil {
+		return decoded, errors.New("invalid password hash")
+	}
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return decoded, errors.New("invalid password hash")
+	}
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(decoded.key) == 0 {
+		return decoded, errors.New("invalid password hash")
+	}
+	return decoded, nil
+}
+
+// isLegacyHash tells whether a stored hash is an unsalted SHA-256 hex digest made by HashString
+func isLegacyHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyPassword compares a password with a stored hash in constant time. It also tells whether the hash should be
+// replaced, because it is a legacy SHA-256 hash or was made with another algorithm or cost than the policy's.
+func (p PasswordPolicy) VerifyPassword(password string, hash string) (bool, bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$"+Argon2id+"$"):
+		decoded, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, false, err
+		}
+		key := argon2.IDKey([]byte(password), decoded.salt, decoded.time, decoded.memory, decoded.threads, uint32(len(decoded.key)))
+		if subtle.ConstantTimeCompare(key, decoded.key) != 1 {
+			return false, false, nil
+		}
+		outdated := p.Algorithm != Argon2id || decoded.memory != p.Argon2Memory || decoded.time != p.Argon2Time || decoded.threads != p.Argon2Threads
+		return true, outdated, nil
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, errors.New("invalid password hash")
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err != nil || p.Algorithm != Bcrypt || cost != p.BcryptCost, nil
+	case isLegacyHash(hash):
+		matches := subtle.ConstantTimeCompare([]byte(HashString(password)), []byte(hash)) == 1
+		return matches, matches, nil
+	}
+	return false, false, errors.New("invalid password hash")
+}
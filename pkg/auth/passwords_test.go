@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// testPolicy keeps the hashing cheap enough for tests
+var testPolicy = PasswordPolicy{Algorithm: Argon2id, Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1, BcryptCost: 4}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := testPolicy.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Expected the hash to carry its parameters, got %s", hash)
+	}
+
+	other, _ := testPolicy.HashPassword("secret")
+	if other == hash {
+		t.Errorf("Expected hashes of the same password to be salted")
+	}
+
+	matches, rehash, err := testPolicy.VerifyPassword("secret", hash)
+	if err != nil || !matches || rehash {
+		t.Errorf("Expected password to match without rehash, got %v, %v, %v", matches, rehash, err)
+	}
+	matches, _, err = testPolicy.VerifyPassword("wrong", hash)
+	if err != nil || matches {
+		t.Errorf("Expected wrong password not to match, got %v, %v", matches, err)
+	}
+
+	stronger := testPolicy
+	stronger.Argon2Time = 2
+	if _, rehash, _ = stronger.VerifyPassword("secret", hash); !rehash {
+		t.Errorf("Expected a hash with a lower cost to be rehashed")
+	}
+}
+
+func TestBcryptPassword(t *testing.T) {
+	policy := testPolicy
+	policy.Algorithm = Bcrypt
+	hash, err := policy.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	matches, rehash, err := policy.VerifyPassword("secret", hash)
+	if err != nil || !matches || rehash {
+		t.Errorf("Expected password to match without rehash, got %v, %v, %v", matches, rehash, err)
+	}
+	if _, rehash, _ = testPolicy.VerifyPassword("secret", hash); !rehash {
+		t.Errorf("Expected a bcrypt hash to be rehashed when the policy is argon2id")
+	}
+}
+
+func TestLegacyPassword(t *testing.T) {
+	legacy := HashString("secret")
+	matches, rehash, err := testPolicy.VerifyPassword("secret", legacy)
+	if err != nil || !matches || !rehash {
+		t.Errorf("Expected legacy hash to match and be rehashed, got %v, %v, %v", matches, rehash, err)
+	}
+	matches, rehash, _ = testPolicy.VerifyPassword("wrong", legacy)
+	if matches || rehash {
+		t.Errorf("Expected wrong password not to match a legacy hash")
+	}
+
+	if _, _, err = testPolicy.VerifyPassword("secret", "plaintext"); err == nil {
+		t.Errorf("Expected error for an unknown hash format")
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+)
+
+const (
+	minUsernameLength        = 3
+	maxUsernameLength        = 32
+	defaultMinPasswordLength = 8
+	maxPasswordLength        = 128 // bounds the work of hashing a password
+)
+
+// ValidateUsername checks that a username is 3 to 32 letters, digits, dots, dashes or underscores.
+func ValidateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return errors.New("username must be between 3 and 32 characters")
+	}
+	for _, c := range username {
+		if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '.' || c == '-' || c == '_') {
+			return errors.New("username may only contain letters, digits, dots, dashes and underscores")
+		}
+	}
+	return nil
+}
+
+// minPasswordLength reads the minimum password length from PASSWORD_MIN_LENGTH
+func minPasswordLength() int {
+	return int(env.Number("PASSWORD_MIN_LENGTH", defaultMinPasswordLength, func(length float64) bool {
+		return env.Count(length) && length <= maxPasswordLength
+	}))
+}
+
+// ValidatePassword checks that a password is long enough and mixes letters with digits or symbols. The username may
+// not be part of it.
+func ValidatePassword(password string, username string) error {
+	if len(password) < minPasswordLength() {
+		return errors.New("password must be at least " + strconv.Itoa(minPasswordLength()) + " characters")
+	}
+	if len(password) > maxPasswordLength {
+		return errors.New("password must be at most 128 characters")
+	}
+
+	var letters, others bool
+	for _, c := range password {
+		if unicode.IsLetter(c) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+	if !letters || !others {
+		return errors.New("password must contain letters and digits or symbols")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+	return nil
+}
+
+// ValidateEmail checks that an email is a bare address, e.g. user@example.com.
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
+
+// GenerateVerificationToken returns a new random email verification token and the hash it is stored under
+func GenerateVerificationToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(secret)
+	return token, HashString(token), nil
+}
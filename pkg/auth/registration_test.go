@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	for _, username := range []string{"alice", "bob_42", "gpu.farm-eu"} {
+		if err := ValidateUsername(username); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", username, err)
+		}
+	}
+	for _, username := range []string{"al", strings.Repeat("a", 33), "alice smith", "ålice", "alice@home"} {
+		if err := ValidateUsername(username); err == nil {
+			t.Errorf("Expected %q to be invalid", username)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "")
+	if err := ValidatePassword("correct horse 42", "alice"); err != nil {
+		t.Errorf("Expected password to be valid, got %v", err)
+	}
+
+	invalid := []string{"short1", "onlyletters", "1234567890", "alice12345", strings.Repeat("a1", 65)}
+	for _, password := range invalid {
+		if err := ValidatePassword(password, "alice"); err == nil {
+			t.Errorf("Expected %q to be invalid", password)
+		}
+	}
+
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+	if err := ValidatePassword("secret123", "alice"); err == nil {
+		t.Errorf("Expected the configured minimum length to apply")
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	if err := ValidateEmail("alice@example.com"); err != nil {
+		t.Errorf("Expected email to be valid, got %v", err)
+	}
+	for _, email := range []string{"alice", "Alice <alice@example.com>", "alice@"} {
+		if err := ValidateEmail(email); err == nil {
+			t.Errorf("Expected %q to be invalid", email)
+		}
+	}
+}
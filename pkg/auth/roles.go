@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// Roles a user can hold, a user may hold several of them
+const (
+	RoleRenter   = "renter"   // leases resources of suppliers
+	RoleSupplier = "supplier" // offers resources for lease
+	RoleAdmin    = "admin"    // operates the marketplace
+)
+
+// Permission names an action a role allows.
+type Permission string
+
+const (
+	PermissionManageAccount     Permission = "account:manage"        // wallet, ledger and sessions of the user
+	PermissionBrowseResources   Permission = "resources:browse"      // search resources, pools, prices and ratings
+	PermissionRentResources     Permission = "resources:rent"        // place loan requests and connect to leased resources
+	PermissionSupplyResources   Permission = "resources:supply"      // manage resources, pools, policies and agents
+	PermissionRunAgents         Permission = "agents:run"            // register agents and report their heartbeats and hardware
+	PermissionReviewLeases      Permission = "leases:review"         // rate and dispute the leases the user took part in
+	PermissionPurgeResources    Permission = "admin:purge-resources" // permanently delete archived resources
+	PermissionResolveDisputes   Permission = "admin:disputes"        // review and resolve the disputes of any lease
+	PermissionManageRoles       Permission = "admin:roles"           // grant and revoke the roles of users
+	PermissionManageUsers       Permission = "admin:users"           // list, suspend and adjust the wallets of users
+	PermissionManageLeases      Permission = "admin:leases"          // force-end leases and cancel auctions
+	PermissionModerateResources Permission = "admin:resources"       // remove fraudulent resources
+	PermissionViewStats         Permission = "admin:stats"           // view system stats and the audit log
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleRenter:   {PermissionManageAccount, PermissionBrowseResources, PermissionRentResources, PermissionReviewLeases},
+	RoleSupplier: {PermissionManageAccount, PermissionBrowseResources, PermissionSupplyResources, PermissionRunAgents, PermissionReviewLeases},
+	RoleAdmin: {PermissionManageAccount, PermissionBrowseResources, PermissionRentResources, PermissionSupplyResources,
+		PermissionRunAgents, PermissionReviewLeases, PermissionPurgeResources, PermissionResolveDisputes, PermissionManageRoles,
+		PermissionManageUsers, PermissionManageLeases, PermissionModerateResources, PermissionViewStats},
+}
+
+// ValidateRole checks that a role exists
+func ValidateRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return errors.New("unknown role " + role)
+	}
+	return nil
+}
+
+// HasPermission reports whether any of the roles allows the permission
+func HasPermission(roles []string, permission Permission) bool {
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DefaultRoles reads the roles new users get from the comma separated DEFAULT_ROLES, renter and supplier when unset.
+// Unknown roles and the admin role are ignored, admins are always granted explicitly.
+func DefaultRoles() []string {
+	value := os.Getenv("DEFAULT_ROLES")
+	if value == "" {
+		return []string{RoleRenter, RoleSupplier}
+	}
+
+	roles := []string{}
+	for _, role := range strings.Split(value, ",") {
+		role = strings.TrimSpace(role)
+		if ValidateRole(role) == nil && role != RoleAdmin {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// knownPermission reports whether any role grants the permission
+func knownPermission(permission Permission) bool {
+	for role := range rolePermissions {
+		if HasPermission([]string{role}, permission) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		roles      []string
+		permission Permission
+		want       bool
+	}{
+		{nil, PermissionManageAccount, false},
+		{[]string{RoleRenter}, PermissionRentResources, true},
+		{[]string{RoleRenter}, PermissionSupplyResources, false},
+		{[]string{RoleSupplier}, PermissionSupplyResources, true},
+		{[]string{RoleSupplier}, PermissionRentResources, false},
+		{[]string{RoleRenter, RoleSupplier}, PermissionSupplyResources, true},
+		{[]string{RoleRenter, RoleSupplier}, PermissionResolveDisputes, false},
+		{[]string{RoleAdmin}, PermissionResolveDisputes, true},
+		{[]string{"root"}, PermissionManageAccount, false},
+	}
+	for _, test := range tests {
+		if got := HasPermission(test.roles, test.permission); got != test.want {
+			t.Errorf("HasPermission(%v, %s) = %v, want %v", test.roles, test.permission, got, test.want)
+		}
+	}
+}
+
+func TestDefaultRoles(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{"", []string{RoleRenter, RoleSupplier}},
+		{"renter", []string{RoleRenter}},
+		{" supplier , unknown", []string{RoleSupplier}},
+		{"renter,admin", []string{RoleRenter}},
+	}
+	for _, test := range tests {
+		t.Setenv("DEFAULT_ROLES", test.env)
+		if got := DefaultRoles(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("DefaultRoles() with %q = %v, want %v", test.env, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+)
+
+// RefreshTokenPrefix marks a string as a Cycloud refresh token
+const RefreshTokenPrefix = "cyr_"
+
+const (
+	defaultAccessTokenLifetime  = 15 * time.Minute
+	defaultRefreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+// AccessTokenLifetime reads how long an access token is valid from ACCESS_TOKEN_MINUTES. It never exceeds the
+// token lifetime signing keys are kept for after they are retired.
+func AccessTokenLifetime() time.Duration {
+	if lifetime := env.Duration("ACCESS_TOKEN_MINUTES", defaultAccessTokenLifetime, time.Minute, env.Count); lifetime < tokenLifetime {
+		return lifetime
+	}
+	return tokenLifetime
+}
+
+// RefreshTokenLifetime reads how long a session stays alive without being refreshed from REFRESH_TOKEN_DAYS
+func RefreshTokenLifetime() time.Duration {
+	return env.Duration("REFRESH_TOKEN_DAYS", defaultRefreshTokenLifetime, 24*time.Hour, env.Count)
+}
+
+// GenerateSessionID returns a new random session ID, IDs are never reused so a token can only match its own session
+func GenerateSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// GenerateRefreshToken returns a new random refresh token and the hash it is stored under
+func GenerateRefreshToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := RefreshTokenPrefix + hex.EncodeToString(secret)
+	return token, HashString(token), nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenLifetime(t *testing.T) {
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", defaultAccessTokenLifetime},
+		{"invalid", defaultAccessTokenLifetime},
+		{"-5", defaultAccessTokenLifetime},
+		{"5", 5 * time.Minute},
+		{"600", tokenLifetime},
+	}
+	for _, test := range tests {
+		t.Setenv("ACCESS_TOKEN_MINUTES", test.env)
+		if got := AccessTokenLifetime(); got != test.want {
+			t.Errorf("AccessTokenLifetime() with %q = %v, want %v", test.env, got, test.want)
+		}
+	}
+}
+
+func TestGenerateRefreshToken(t *testing.T) {
+	token, hash, err := GenerateRefreshToken()
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	if !strings.HasPrefix(token, RefreshTokenPrefix) {
+		t.Errorf("Expected refresh token to start with %s, got %s", RefreshTokenPrefix, token)
+	}
+	if hash != HashString(token) {
+		t.Errorf("Expected refresh token to be stored under its hash")
+	}
+
+	other, _, _ := GenerateRefreshToken()
+	if other == token {
+		t.Errorf("Expected refresh tokens to be random")
+	}
+}
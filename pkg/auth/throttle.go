@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+)
+
+// LoginAttempts is the record of the recent failed logins of a username or an address.
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// AttemptStore keeps the failed logins counted by a LoginThrottle.
+type AttemptStore interface {
+	GetAttempts(key string) (LoginAttempts, error) // the zero record when the key has none
+	// RecordFailure counts a failure of the key at now, restarting the count when the last one is older than
+	// the window, and returns the new record. It must be atomic so concurrent failures are all counted.
+	RecordFailure(key string, now time.Time, window time.Duration) (LoginAttempts, error)
+	ForgetFailure(key string) error // uncounts one failure of the key, for an attempt counted before it succeeded
+	DeleteAttempts(key string) error
+	PruneAttempts(before time.Time) error // drops the records whose last failure is older
+}
+
+// MemoryAttemptStore keeps the failed logins of a single process, each server of a deployment counts its own.
+type MemoryAttemptStore struct {
+	mutex    sync.Mutex
+	attempts map[string]LoginAttempts
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: map[string]LoginAttempts{}}
+}
+
+func (s *MemoryAttemptStore) GetAttempts(key string) (LoginAttempts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (LoginAttempts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	attempts := s.attempts[key]
+	if now.Sub(attempts.LastFailure) > window {
+		attempts.Failures = 0
+	}
+	attempts.Failures++
+	attempts.LastFailure = now
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryAttemptStore) ForgetFailure(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if attempts, ok := s.attempts[key]; ok && attempts.Failures > 0 {
+		attempts.Failures--
+		s.attempts[key] = attempts
+	}
+	return nil
+}
+
+func (s *MemoryAttemptStore) DeleteAttempts(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryAttemptStore) PruneAttempts(before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, attempts := range s.attempts {
+		if attempts.LastFailure.Before(before) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
+
+// ThrottlePolicy sets how failed logins of a username or an address slow down and then lock out further attempts.
+type ThrottlePolicy struct {
+	BackoffAfter int           // failures allowed before attempts are delayed
+	BackoffBase  time.Duration // delay after the first failure past BackoffAfter, doubling with each further failure
+	MaxBackoff   time.Duration
+	LockoutAfter int           // failures that lock the key out
+	Lockout      time.Duration // how long a lockout lasts
+	Window       time.Duration // failures older than this are forgotten, never shorter than the lockout
+}
+
+// Default throttle policies, addresses get more failures as many users may share one
+var (
+	DefaultUserThrottle = ThrottlePolicy{BackoffAfter: 3, BackoffBase: time.Second, MaxBackoff: time.Minute, LockoutAfter: 10, Lockout: 15 * time.Minute, Window: 15 * time.Minute}
+	DefaultIPThrottle   = ThrottlePolicy{BackoffAfter: 20, BackoffBase: time.Second, MaxBackoff: time.Minute, LockoutAfter: 100, Lockout: 15 * time.Minute, Window: 15 * time.Minute}
+)
+
+// delay returns how long after the last of the failures the next attempt is refused
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.Lockout
+	}
+	if failures < p.BackoffAfter {
+		return 0
+	}
+	delay := p.BackoffBase
+	for i := p.BackoffAfter; i < failures && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
+
+// retryAfter returns how long the holder of a record has to wait before its next attempt
+func (p ThrottlePolicy) retryAfter(attempts LoginAttempts, now time.Time) time.Duration {
+	if attempts.Failures == 0 || now.Sub(attempts.LastFailure) > p.Window {
+		return 0
+	}
+	if wait := attempts.LastFailure.Add(p.delay(attempts.Failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// raced returns how long an attempt counted into the record has to wait behind the attempts counted by others since
+// the record before it was read, zero when none got in the way
+func (p ThrottlePolicy) raced(counted LoginAttempts, before LoginAttempts, now time.Time) time.Duration {
+	if now.Sub(before.LastFailure) > p.Window {
+		before.Failures = 0
+	}
+	if counted.Failures-1 <= before.Failures {
+		return 0
+	}
+	return p.delay(counted.Failures - 1)
+}
+
+// LoginThrottle counts the failed logins of each username and each address and refuses attempts while they back
+// off or are locked out. Usernames are throttled whether or not they exist so the throttle tells nothing about them.
+type LoginThrottle struct {
+	store AttemptStore
+	user  ThrottlePolicy
+	ip    ThrottlePolicy
+}
+
+func NewLoginThrottle(store AttemptStore, user ThrottlePolicy, ip ThrottlePolicy) *LoginThrottle {
+	for _, policy := range []*ThrottlePolicy{&user, &ip} {
+		if policy.Window < policy.Lockout {
+			policy.Window = policy.Lockout
+		}
+	}
+	return &LoginThrottle{store: store, user: user, ip: ip}
+}
+
+// LoginThrottleFromEnv returns a login throttle over the store using LOGIN_BACKOFF_AFTER, LOGIN_LOCKOUT_AFTER,
+// LOGIN_IP_BACKOFF_AFTER, LOGIN_IP_LOCKOUT_AFTER, LOGIN_LOCKOUT_MINUTES and LOGIN_ATTEMPT_WINDOW_MINUTES.
+func LoginThrottleFromEnv(store AttemptStore) *LoginThrottle {
+	user, ip := DefaultUserThrottle, DefaultIPThrottle
+	user.BackoffAfter = int(env.Number("LOGIN_BACKOFF_AFTER", float64(user.BackoffAfter), env.Count))
+	user.LockoutAfter = int(env.Number("LOGIN_LOCKOUT_AFTER", float64(user.LockoutAfter), env.Count))
+	ip.BackoffAfter = int(env.Number("LOGIN_IP_BACKOFF_AFTER", float64(ip.BackoffAfter), env.Count))
+	ip.LockoutAfter = int(env.Number("LOGIN_IP_LOCKOUT_AFTER", float64(ip.LockoutAfter), env.Count))
+	user.Lockout = env.Duration("LOGIN_LOCKOUT_MINUTES", user.Lockout, time.Minute, env.Positive)
+	ip.Lockout = user.Lockout
+	user.Window = env.Duration("LOGIN_ATTEMPT_WINDOW_MINUTES", user.Window, time.Minute, env.Positive)
+	ip.Window = user.Window
+	return NewLoginThrottle(store, user, ip)
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + username
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginAttempt is a login counted as failed by a LoginThrottle before its credentials are checked, so concurrent
+// guesses cannot all get past the throttle before any of them failed. It is settled with Failed or Succeeded, or
+// with Forget when it ends without its credentials being rejected.
+type LoginAttempt struct {
+	username string
+	ip       string
+	now      time.Time
+	user     LoginAttempts // the records once the attempt was counted
+	address  LoginAttempts
+	settled  bool
+}
+
+// Attempt counts a login of the username from the address. It returns how long the login has to wait when the
+// username or the address backs off or is locked out, the login is then refused and the attempt needs no settling.
+func (t *LoginThrottle) Attempt(username string, ip string, now time.Time) (*LoginAttempt, time.Duration, error) {
+	attempt := &LoginAttempt{username: username, ip: ip, now: now, settled: true}
+	userAttempts, err := t.store.GetAttempts(userAttemptKey(username))
+	if err != nil {
+		return attempt, 0, err
+	}
+	ipAttempts, err := t.store.GetAttempts(ipAttemptKey(ip))
+	if err != nil {
+		return attempt, 0, err
+	}
+	wait := t.user.retryAfter(userAttempts, now)
+	if ipWait := t.ip.retryAfter(ipAttempts, now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return attempt, wait, nil
+	}
+
+	attempt.user, err = t.store.RecordFailure(userAttemptKey(username), now, t.user.Window)
+	if err != nil {
+		return attempt, 0, err
+	}
+	attempt.address, err = t.store.RecordFailure(ipAttemptKey(ip), now, t.ip.Window)
+	if err != nil {
+		return attempt, 0, err
+	}
+
+	// Attempts counted by others since the records were read failed as far as this one knows, it waits behind them
+	// and stays counted
+	wait = t.user.raced(attempt.user, userAttempts, now)
+	if ipWait := t.ip.raced(attempt.address, ipAttempts, now); ipWait > wait {
+		wait = ipWait
+	}
+	attempt.settled = wait > 0
+	return attempt, wait, nil
+}
+
+// Failed settles an attempt whose credentials were rejected, it stays counted. It returns how long the next attempt
+// has to wait and whether this failure locked the username or the address out.
+func (t *LoginThrottle) Failed(attempt *LoginAttempt) (time.Duration, bool) {
+	attempt.settled = true
+	wait := t.user.retryAfter(attempt.user, attempt.now)
+	if ipWait := t.ip.retryAfter(attempt.address, attempt.now); ipWait > wait {
+		wait = ipWait
+	}
+	lockedOut := attempt.user.Failures == t.user.LockoutAfter || attempt.address.Failures == t.ip.LockoutAfter
+	return wait, lockedOut
+}
+
+// Succeeded settles an attempt that signed in, forgetting the failed logins of its username. Only the attempt itself
+// is uncounted from the address, signing in to one account must not clear the way to guess others.
+func (t *LoginThrottle) Succeeded(attempt *LoginAttempt) error {
+	if attempt.settled {
+		return nil
+	}
+	attempt.settled = true
+	if err := t.store.DeleteAttempts(userAttemptKey(attempt.username)); err != nil {
+		return err
+	}
+	return t.store.ForgetFailure(ipAttemptKey(attempt.ip))
+}
+
+// Forget uncounts an attempt that was not settled, it is deferred for the logins ending without their credentials
+// being rejected
+func (t *LoginThrottle) Forget(attempt *LoginAttempt) error {
+	if attempt.settled {
+		return nil
+	}
+	attempt.settled = true
+	if err := t.store.ForgetFailure(userAttemptKey(attempt.username)); err != nil {
+		return err
+	}
+	return t.store.ForgetFailure(ipAttemptKey(attempt.ip))
+}
+
+// RunPruning periodically drops the records of failures too old to matter. It never returns.
+func (t *LoginThrottle) RunPruning(interval time.Duration) {
+	window := t.user.Window
+	if t.ip.Window > window {
+		window = t.ip.Window
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := t.store.PruneAttempts(time.Now().Add(-window)); err != nil {
+			log.Printf("Failed to prune login attempts: %v", err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+var testThrottle = ThrottlePolicy{BackoffAfter: 2, BackoffBase: time.Second, MaxBackoff: 4 * time.Second, LockoutAfter: 5, Lockout: time.Minute, Window: time.Minute}
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{5, time.Minute},
+		{9, time.Minute},
+	}
+	for _, test := range tests {
+		if got := testThrottle.delay(test.failures); got != test.want {
+			t.Errorf("delay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+
+	capped := ThrottlePolicy{BackoffAfter: 1, BackoffBase: time.Second, MaxBackoff: 3 * time.Second, LockoutAfter: 100, Lockout: time.Hour}
+	if got := capped.delay(50); got != 3*time.Second {
+		t.Errorf("Expected the backoff to stop at its maximum, got %v", got)
+	}
+}
+
+// fail counts a login attempt that is rejected, failing the test when the attempt is refused
+func fail(t *testing.T, throttle *LoginThrottle, username string, ip string, now time.Time) (time.Duration, bool) {
+	t.Helper()
+	attempt, wait, err := throttle.Attempt(username, ip, now)
+	if err != nil || wait != 0 {
+		t.Fatalf("Expected the attempt of %s to be allowed, got a wait of %v and %v", username, wait, err)
+	}
+	return throttle.Failed(attempt)
+}
+
+// waitFor returns how long a login attempt is refused for, zero when it is allowed and then forgotten
+func waitFor(throttle *LoginThrottle, username string, ip string, now time.Time) time.Duration {
+	attempt, wait, _ := throttle.Attempt(username, ip, now)
+	throttle.Forget(attempt)
+	return wait
+}
+
+func TestLoginThrottleBacksOffAndLocksOut(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryAttemptStore(), testThrottle, DefaultIPThrottle)
+	now := time.Unix(1000, 0)
+
+	for i := 1; i <= 4; i++ {
+		if _, lockedOut := fail(t, throttle, "alice", "10.0.0.1", now); lockedOut {
+			t.Fatalf("Expected failure %d not to lock out", i)
+		}
+		now = now.Add(testThrottle.delay(i))
+	}
+
+	wait, lockedOut := fail(t, throttle, "alice", "10.0.0.1", now)
+	if !lockedOut || wait != time.Minute {
+		t.Fatalf("Expected the fifth failure to lock out for a minute, got %v locked out %v", wait, lockedOut)
+	}
+	if wait := waitFor(throttle, "alice", "10.0.0.2", now.Add(30*time.Second)); wait != 30*time.Second {
+		t.Errorf("Expected the username to stay locked from another address, got a wait of %v", wait)
+	}
+	if wait := waitFor(throttle, "bob", "10.0.0.1", now); wait != 0 {
+		t.Errorf("Expected other usernames from the address to be allowed, got a wait of %v", wait)
+	}
+	if wait := waitFor(throttle, "alice", "10.0.0.1", now.Add(time.Minute+time.Second)); wait != 0 {
+		t.Errorf("Expected the lockout to end, got a wait of %v", wait)
+	}
+
+	// The count restarts once the window passed
+	if _, lockedOut = fail(t, throttle, "alice", "10.0.0.1", now.Add(2*time.Minute)); lockedOut {
+		t.Errorf("Expected failures past the window to be forgotten")
+	}
+}
+
+// staleAttemptStore reads the records as they were before any attempt was counted, as concurrent logins all do
+type staleAttemptStore struct {
+	*MemoryAttemptStore
+}
+
+func (s staleAttemptStore) GetAttempts(key string) (LoginAttempts, error) {
+	return LoginAttempts{}, nil
+}
+
+func TestLoginThrottleCountsAttemptsBeforeTheyFail(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	concurrent := NewLoginThrottle(staleAttemptStore{store}, testThrottle, DefaultIPThrottle)
+	throttle := NewLoginThrottle(store, testThrottle, DefaultIPThrottle)
+	now := time.Unix(1000, 0)
+
+	// Concurrent guesses all pass the check before any of them failed, only those within the free failures get through
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if _, wait, _ := concurrent.Attempt("alice", "10.0.0.1", now); wait == 0 {
+			allowed++
+		}
+	}
+	if allowed != testThrottle.BackoffAfter {
+		t.Errorf("Expected %d concurrent attempts to be allowed, got %d", testThrottle.BackoffAfter, allowed)
+	}
+	if wait := waitFor(throttle, "alice", "10.0.0.1", now.Add(30*time.Second)); wait != 30*time.Second {
+		t.Errorf("Expected the refused attempts to count towards the lockout, got a wait of %v", wait)
+	}
+}
+
+func TestLoginThrottleForgetsAttemptsThatDidNotFail(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryAttemptStore(), testThrottle, DefaultIPThrottle)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 10; i++ {
+		attempt, wait, _ := throttle.Attempt("alice", "10.0.0.1", now)
+		if wait != 0 {
+			t.Fatalf("Expected attempt %d to be allowed, got a wait of %v", i, wait)
+		}
+		throttle.Forget(attempt)
+	}
+	fail(t, throttle, "alice", "10.0.0.1", now)
+	attempt, _, _ := throttle.Attempt("alice", "10.0.0.1", now)
+	throttle.Succeeded(attempt)
+	throttle.Forget(attempt)
+
+	if attempts, _ := throttle.store.GetAttempts(userAttemptKey("alice")); attempts.Failures != 0 {
+		t.Errorf("Expected signing in to clear the username, got %d failures", attempts.Failures)
+	}
+	if attempts, _ := throttle.store.GetAttempts(ipAttemptKey("10.0.0.1")); attempts.Failures != 1 {
+		t.Errorf("Expected the address to keep only its failure, got %d failures", attempts.Failures)
+	}
+}
+
+func TestLoginThrottleLimitsAddresses(t *testing.T) {
+	ipPolicy := testThrottle
+	ipPolicy.BackoffAfter, ipPolicy.LockoutAfter = 100, 3
+	throttle := NewLoginThrottle(NewMemoryAttemptStore(), DefaultUserThrottle, ipPolicy)
+	now := time.Unix(1000, 0)
+
+	var lockedOut bool
+	for _, username := range []string{"alice", "bob", "carol"} {
+		_, lockedOut = fail(t, throttle, username, "10.0.0.1", now)
+	}
+	if !lockedOut {
+		t.Fatalf("Expected failures over many usernames to lock the address out")
+	}
+	if wait := waitFor(throttle, "dave", "10.0.0.1", now); wait != time.Minute {
+		t.Errorf("Expected the address to be locked out for every username, got a wait of %v", wait)
+	}
+
+	// Signing in clears the username but not the address
+	attempt, _, _ := throttle.Attempt("carol", "10.0.0.2", now)
+	throttle.Succeeded(attempt)
+	if wait := waitFor(throttle, "carol", "10.0.0.1", now); wait == 0 {
+		t.Errorf("Expected a successful login not to unlock the address")
+	}
+	if wait := waitFor(throttle, "carol", "10.0.0.2", now); wait != 0 {
+		t.Errorf("Expected a successful login to clear the failures of the username, got a wait of %v", wait)
+	}
+}
+
+func TestLoginThrottleFromEnv(t *testing.T) {
+	t.Setenv("LOGIN_LOCKOUT_AFTER", "4")
+	t.Setenv("LOGIN_IP_LOCKOUT_AFTER", "40")
+	t.Setenv("LOGIN_LOCKOUT_MINUTES", "30")
+	t.Setenv("LOGIN_ATTEMPT_WINDOW_MINUTES", "10")
+	t.Setenv("LOGIN_BACKOFF_AFTER", "invalid")
+
+	throttle := LoginThrottleFromEnv(NewMemoryAttemptStore())
+	if throttle.user.LockoutAfter != 4 || throttle.ip.LockoutAfter != 40 {
+		t.Errorf("Expected lockout thresholds 4 and 40, got %d and %d", throttle.user.LockoutAfter, throttle.ip.LockoutAfter)
+	}
+	if throttle.user.BackoffAfter != DefaultUserThrottle.BackoffAfter {
+		t.Errorf("Expected an invalid backoff threshold to fall back to %d, got %d", DefaultUserThrottle.BackoffAfter, throttle.user.BackoffAfter)
+	}
+	if throttle.user.Lockout != 30*time.Minute || throttle.user.Window != 30*time.Minute {
+		t.Errorf("Expected a 30 minute lockout with a window at least as long, got %v and %v", throttle.user.Lockout, throttle.user.Window)
+	}
+}
+
+func TestMemoryAttemptStorePrunes(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	now := time.Unix(1000, 0)
+	store.RecordFailure("old", now, time.Minute)
+	store.RecordFailure("new", now.Add(time.Hour), time.Minute)
+
+	store.PruneAttempts(now.Add(time.Minute))
+	if attempts, _ := store.GetAttempts("old"); attempts.Failures != 0 {
+		t.Errorf("Expected old failures to be pruned")
+	}
+	if attempts, _ := store.GetAttempts("new"); attempts.Failures != 1 {
+		t.Errorf("Expected recent failures to be kept")
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the ones authenticator apps assume when an otpauth URI leaves them out
+const (
+	totpDigits       = 6
+	totpPeriod       = 30 * time.Second
+	totpSkew         = 1  // steps accepted before and after the current one, for clocks that drift
+	totpSecretLength = 20 // bytes, the size of an HMAC-SHA1 key
+)
+
+const (
+	// TOTPIssuer names Cycloud in authenticator apps
+	TOTPIssuer = "Cycloud"
+
+	// RecoveryCodeCount is how many recovery codes a user gets when enabling two-factor authentication
+	RecoveryCodeCount = 10
+
+	// LoginChallengePrefix marks a string as the challenge of a login waiting for its second factor
+	LoginChallengePrefix = "cym_"
+
+	// LoginChallengeLifetime is how long the second factor of a login can be given after its password
+	LoginChallengeLifetime = 5 * time.Minute
+
+	// LoginChallengeAttempts is how many codes can be tried against a login challenge
+	LoginChallengeAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random TOTP secret, base32 encoded as authenticator apps expect it
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth URI authenticator apps enroll a secret from, usually shown as a QR code
+func TOTPURI(account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	label := url.PathEscape(TOTPIssuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpStep returns the time step a moment falls in
+func totpStep(now time.Time) int64 {
+	return now.Unix() / int64(totpPeriod.Seconds())
+}
+
+// totpCode computes the code of a time step as RFC 6238 defines it
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// IsTOTPCode reports whether a code has the shape of a TOTP code rather than of a recovery code
+func IsTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyTOTP checks a code against a secret at the given time. Only steps after lastStep are accepted so a code
+// cannot be replayed, the step the code matched is returned to be stored as the new lastStep.
+func VerifyTOTP(secret string, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || !IsTOTPCode(code) {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// NormalizeRecoveryCode removes the separators and case a user may type a recovery code with
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// GenerateRecoveryCodes returns a new set of single use recovery codes and the hashes they are stored under
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		secret := make([]byte, 5)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(secret)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = HashString(NormalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// GenerateLoginChallenge returns a new random login challenge and the hash it is stored under
+func GenerateLoginChallenge() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	challenge := LoginChallengePrefix + hex.EncodeToString(secret)
+	return challenge, HashString(challenge), nil
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestVerifyTOTPMatchesRFCVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+	}
+	for _, test := range tests {
+		now := time.Unix(test.unix, 0)
+		step, ok := VerifyTOTP(rfcSecret, test.code, now, 0)
+		if !ok {
+			t.Errorf("Expected code %s to be valid at %d", test.code, test.unix)
+			continue
+		}
+		if step != totpStep(now) {
+			t.Errorf("Expected code %s to match step %d, got %d", test.code, totpStep(now), step)
+		}
+	}
+}
+
+func TestVerifyTOTPToleratesDriftButNotReplay(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	if _, ok := VerifyTOTP(rfcSecret, "081804", now.Add(totpPeriod), 0); !ok {
+		t.Errorf("Expected a code of the previous step to be accepted")
+	}
+	if _, ok := VerifyTOTP(rfcSecret, "081804", now.Add(3*totpPeriod), 0); ok {
+		t.Errorf("Expected an old code to be rejected")
+	}
+
+	step, _ := VerifyTOTP(rfcSecret, "081804", now, 0)
+	if _, ok := VerifyTOTP(rfcSecret, "081804", now, step); ok {
+		t.Errorf("Expected a used code to be rejected")
+	}
+	if _, ok := VerifyTOTP(rfcSecret, "000000", now, 0); ok {
+		t.Errorf("Expected a wrong code to be rejected")
+	}
+	if _, ok := VerifyTOTP(rfcSecret, "08180", now, 0); ok {
+		t.Errorf("Expected a short code to be rejected")
+	}
+}
+
+func TestGenerateTOTPSecretEnrolls(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate TOTP secret: %v", err)
+	}
+	now := time.Now()
+	key, _ := totpEncoding.DecodeString(secret)
+	if _, ok := VerifyTOTP(secret, totpCode(key, totpStep(now)), now, 0); !ok {
+		t.Errorf("Expected the current code of a new secret to be valid")
+	}
+
+	uri, err := url.Parse(TOTPURI("alice", secret))
+	if err != nil {
+		t.Fatalf("Failed to parse otpauth URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("Expected an otpauth totp URI, got %s", uri)
+	}
+	if uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != TOTPIssuer {
+		t.Errorf("Expected the URI to carry the secret and issuer, got %s", uri.RawQuery)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("Failed to generate recovery codes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("Expected recovery codes to be unique, got %s twice", code)
+		}
+		seen[code] = true
+		if IsTOTPCode(NormalizeRecoveryCode(code)) {
+			t.Errorf("Expected recovery code %s not to look like a TOTP code", code)
+		}
+		if hashes[i] != HashString(NormalizeRecoveryCode(" "+strings.ToUpper(code)+" ")) {
+			t.Errorf("Expected recovery code %s to be stored under the hash of its normalized form", code)
+		}
+	}
+}
@@ -11,6 +11,9 @@ import (
 var resourceMaxBidMap = make(map[string]*models.BidWithLock)
 var mapMutex sync.Mutex
 
+// leaseEnds holds a channel per watched lease, closed when the lease is ended before it runs out
+var leaseEnds = make(map[string]chan struct{})
+
 func RegisterP2PConnection(isRenter bool, resourceID string, ws *websocket.Conn) error {
 	mapMutex.Lock()
 	defer mapMutex.Unlock()
@@ -87,3 +90,79 @@ func CheckBidsForResource(resourceID string) (models.BidWithID, error) {
 
 	return bid.MaxBid, nil
 }
+
+// RegisterLease registers a bid accepted outside of an auction so the signaling of its resource can start
+func RegisterLease(bid models.BidWithID) {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	// Held like the lock of an auction's winning bid, so unlisting the resource later releases it
+	lease := &models.BidWithLock{MaxBid: bid}
+	lease.Lock.Lock()
+	resourceMaxBidMap[bid.RID] = lease
+}
+
+// auctionOpen reports whether the max bid of a resource is still waiting for its auction to close
+func auctionOpen(bid *models.BidWithLock) bool {
+	return bid.MaxBid.Status != "accepted" && bid.MaxBid.Status != "rejected"
+}
+
+// CancelAuction rejects the max bid of a resource's open auction and forgets the auction, later bids start a new one
+func CancelAuction(resourceID string) error {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	bid, exists := resourceMaxBidMap[resourceID]
+	if !exists || !auctionOpen(bid) {
+		return errors.New("no open auction for resource")
+	}
+	bid.MaxBid.Status = "rejected"
+	bid.Lock.Unlock()
+	delete(resourceMaxBidMap, resourceID)
+	return nil
+}
+
+// EndLease forgets the accepted bid of a resource so no more signaling goes through for its lease, and tells whoever
+// watches the lease that it ended
+func EndLease(resourceID string, bidID string) {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	bid, exists := resourceMaxBidMap[resourceID]
+	if exists && bid.MaxBid.Status == "accepted" && bid.MaxBid.BID == bidID {
+		delete(resourceMaxBidMap, resourceID)
+	}
+	if ended, watched := leaseEnds[bidID]; watched {
+		close(ended)
+		delete(leaseEnds, bidID)
+	}
+}
+
+// WatchLease returns a channel closed when the lease of an accepted bid is ended with EndLease
+func WatchLease(bidID string) <-chan struct{} {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	ended, watched := leaseEnds[bidID]
+	if !watched {
+		ended = make(chan struct{})
+		leaseEnds[bidID] = ended
+	}
+	return ended
+}
+
+// ForgetLease stops watching a lease that ran out
+func ForgetLease(bidID string) {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	delete(leaseEnds, bidID)
+}
+
+// ActiveAuctions returns the number of auctions waiting to close
+func ActiveAuctions() int {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	active := 0
+	for _, bid := range resourceMaxBidMap {
+		if auctionOpen(bid) {
+			active++
+		}
+	}
+	return active
+}
@@ -0,0 +1,65 @@
+package bidding
+
+import (
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestCancelAuction(t *testing.T) {
+	bid := &models.BidWithLock{MaxBid: models.BidWithID{BID: "1", Bid: models.Bid{RID: "cancel"}, Status: "pending"}}
+	BidForResource(bid)
+	if ActiveAuctions() != 1 {
+		t.Fatalf("Expected 1 active auction, got %d", ActiveAuctions())
+	}
+
+	if err := CancelAuction("cancel"); err != nil {
+		t.Fatalf("Failed to cancel auction: %v", err)
+	}
+	// The bidder waiting on the lock is released with its bid rejected
+	bid.Lock.Lock()
+	if bid.MaxBid.Status != "rejected" {
+		t.Errorf("Expected max bid to be rejected, got %s", bid.MaxBid.Status)
+	}
+	if ActiveAuctions() != 0 {
+		t.Errorf("Expected no active auction, got %d", ActiveAuctions())
+	}
+	if err := CancelAuction("cancel"); err == nil {
+		t.Errorf("Expected cancelling a cancelled auction to fail")
+	}
+}
+
+func TestCancelAuctionLeavesLeasesAlone(t *testing.T) {
+	RegisterLease(models.BidWithID{BID: "2", Bid: models.Bid{RID: "lease"}, Status: "accepted"})
+	if err := CancelAuction("lease"); err == nil {
+		t.Errorf("Expected cancelling the auction of a leased resource to fail")
+	}
+
+	EndLease("lease", "3")
+	if _, err := GetMaxBidForResource("lease"); err != nil {
+		t.Errorf("Expected the lease of another bid to be kept, got %v", err)
+	}
+	EndLease("lease", "2")
+	if _, err := GetMaxBidForResource("lease"); err == nil {
+		t.Errorf("Expected the ended lease to be forgotten")
+	}
+}
+
+func TestEndLeaseStopsItsWatchers(t *testing.T) {
+	RegisterLease(models.BidWithID{BID: "4", Bid: models.Bid{RID: "watched"}, Status: "accepted"})
+	ended := WatchLease("4")
+	other := WatchLease("5")
+
+	EndLease("watched", "4")
+	select {
+	case <-ended:
+	default:
+		t.Errorf("Expected the watcher of the ended lease to be told")
+	}
+	select {
+	case <-other:
+		t.Errorf("Expected the watcher of another lease not to be told")
+	default:
+	}
+	ForgetLease("5")
+}
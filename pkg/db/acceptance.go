@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func SetAcceptancePolicy(db *sql.DB, rid string, policy models.AcceptancePolicy) error {
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return errors.New("failed to encode acceptance policy")
+	}
+	table := getDBSchemaTable("acceptance_policies")
+	_, err = db.Exec(fmt.Sprintf(`
+		INSERT INTO %s (rid, policy) VALUES ($1, $2)
+		ON CONFLICT (rid) DO UPDATE SET policy = EXCLUDED.policy, updatedAt = CURRENT_TIMESTAMP`, table), rid, encoded)
+	if err != nil {
+		return errors.New("failed to store acceptance policy")
+	}
+	return nil
+}
+
+// GetAcceptancePolicy returns the acceptance policy of a resource, resources without a policy accept anyone
+func GetAcceptancePolicy(db *sql.DB, rid string) (models.AcceptancePolicy, error) {
+	var encoded []byte
+	table := getDBSchemaTable("acceptance_policies")
+	err := db.QueryRow(fmt.Sprintf("SELECT policy FROM %s WHERE rid = $1", table), rid).Scan(&encoded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.AcceptancePolicy{}, nil
+		}
+		return models.AcceptancePolicy{}, errors.New("failed to fetch acceptance policy")
+	}
+	return decodeAcceptancePolicy(encoded)
+}
+
+func decodeAcceptancePolicy(encoded []byte) (models.AcceptancePolicy, error) {
+	var policy models.AcceptancePolicy
+	if encoded == nil {
+		return policy, nil
+	}
+	if err := json.Unmarshal(encoded, &policy); err != nil {
+		return policy, errors.New("failed to decode acceptance policy")
+	}
+	return policy, nil
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func InsertEmailVerification(db *sql.DB, uid string, tokenHash string, expiresAt time.Time) error {
+	table := getDBSchemaTable("email_verifications")
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (tokenHash, uid, expiresAt) VALUES ($1, $2, $3)", table), tokenHash, uid, expiresAt)
+	if err != nil {
+		return errors.New("failed to store email verification")
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of the user a verification token was sent to as verified, the token can only be used once
+func VerifyEmail(db *sql.DB, tokenHash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to verify email")
+	}
+	defer tx.Rollback()
+
+	var uid string
+	var expiresAt time.Time
+	table := getDBSchemaTable("email_verifications")
+	err = tx.QueryRow(fmt.Sprintf("DELETE FROM %s WHERE tokenHash = $1 RETURNING uid, expiresAt", table), tokenHash).Scan(&uid, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("invalid or expired verification token")
+		}
+		return errors.New("failed to verify email")
+	}
+	if expiresAt.Before(time.Now()) {
+		// Keep the expired token deleted
+		tx.Commit()
+		return errors.New("invalid or expired verification token")
+	}
+
+	userTable := getDBSchemaTable("users")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET emailVerified = true WHERE uid = $1", userTable), uid)
+	if err != nil {
+		return errors.New("failed to verify email")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to verify email")
+	}
+	return nil
+}
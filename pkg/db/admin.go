@@ -0,0 +1,416 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 500
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+// ListUsers returns the users matching the filter, oldest first
+func ListUsers(db *sql.DB, filter models.UserFilter) ([]models.UserSummary, error) {
+	table := getDBSchemaTable("users")
+	walletTable := getDBSchemaTable("wallets")
+	roleTable := getDBSchemaTable("user_roles")
+
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.Username != "" {
+		args = append(args, filter.Username)
+		conditions = append(conditions, fmt.Sprintf("u.username = $%d", len(args)))
+	}
+	if filter.Email != "" {
+		args = append(args, "%"+filter.Email+"%")
+		conditions = append(conditions, fmt.Sprintf("u.email ILIKE $%d", len(args)))
+	}
+	if filter.Role != "" {
+		args = append(args, filter.Role)
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM %s ur WHERE ur.uid = u.uid AND ur.role = $%d)", roleTable, len(args)))
+	}
+	if filter.Suspended != nil {
+		if *filter.Suspended {
+			conditions = append(conditions, "u.suspendedAt IS NOT NULL")
+		} else {
+			conditions = append(conditions, "u.suspendedAt IS NULL")
+		}
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxUserListLimit {
+		limit = defaultUserListLimit
+	}
+	offset := filter.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT u.uid, COALESCE(u.email, ''), u.emailVerified, COALESCE(w.credits, 0), u.createdAt, u.suspendedAt, u.suspensionReason,
+			ARRAY(SELECT ur.role FROM %s ur WHERE ur.uid = u.uid ORDER BY ur.role)
+		FROM %s u LEFT JOIN %s w ON w.uid = u.uid
+		%s ORDER BY u.uid LIMIT $%d OFFSET $%d`, roleTable, table, walletTable, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, errors.New("failed to list users")
+	}
+	defer rows.Close()
+
+	users := []models.UserSummary{}
+	for rows.Next() {
+		var user models.UserSummary
+		err = rows.Scan(&user.UID, &user.Email, &user.EmailVerified, &user.Credits, &user.CreatedAt, &user.SuspendedAt, &user.SuspensionReason, pq.Array(&user.Roles))
+		if err != nil {
+			return nil, errors.New("failed to list users")
+		}
+		users = append(users, user)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to list users")
+	}
+	return users, nil
+}
+
+// SuspendUser blocks a user from signing in and revokes its sessions, its API keys stop working as well
+func SuspendUser(db *sql.DB, uid string, reason string, audit models.AuditEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to suspend user")
+	}
+	defer tx.Rollback()
+
+	var suspended bool
+	table := getDBSchemaTable("users")
+	err = tx.QueryRow(fmt.Sprintf("SELECT suspendedAt IS NOT NULL FROM %s WHERE uid = $1 FOR UPDATE", table), uid).Scan(&suspended)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		return errors.New("failed to suspend user")
+	}
+	if suspended {
+		return errors.New("user already suspended")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET suspendedAt = CURRENT_TIMESTAMP, suspensionReason = $2 WHERE uid = $1", table), uid, reason)
+	if err != nil {
+		return errors.New("failed to suspend user")
+	}
+
+	sessionTable := getDBSchemaTable("sessions")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET revokedAt = CURRENT_TIMESTAMP WHERE uid = $1 AND revokedAt IS NULL", sessionTable), uid)
+	if err != nil {
+		return errors.New("failed to revoke sessions of user")
+	}
+
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to suspend user")
+	}
+	return nil
+}
+
+// UnsuspendUser lets a suspended user sign in again
+func UnsuspendUser(db *sql.DB, uid string, audit models.AuditEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to unsuspend user")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("users")
+	result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET suspendedAt = NULL, suspensionReason = '' WHERE uid = $1 AND suspendedAt IS NOT NULL", table), uid)
+	if err != nil {
+		return errors.New("failed to unsuspend user")
+	}
+	if unsuspended, _ := result.RowsAffected(); unsuspended > 0 {
+		if err = insertAuditEntry(tx, audit); err != nil {
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return errors.New("failed to unsuspend user")
+		}
+		return nil
+	}
+
+	var exists bool
+	err = tx.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE uid = $1)", table), uid).Scan(&exists)
+	if err != nil {
+		return errors.New("failed to unsuspend user")
+	}
+	if !exists {
+		return errors.New("user not found")
+	}
+	return errors.New("user is not suspended")
+}
+
+// AdjustCredits credits (or debits, for a negative amount) the wallet of a user on behalf of an admin and ledgers the
+// movement with the admin's note. It returns the new balance.
+func AdjustCredits(db *sql.DB, uid string, amount float64, note string, audit models.AuditEntry) (float64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, errors.New("failed to adjust credits")
+	}
+	defer tx.Rollback()
+
+	var credits float64
+	walletTable := getDBSchemaTable("wallets")
+	err = tx.QueryRow(fmt.Sprintf("SELECT credits FROM %s WHERE uid = $1 FOR UPDATE", walletTable), uid).Scan(&credits)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("user not found")
+		}
+		return 0, errors.New("failed to adjust credits")
+	}
+	if credits+amount < 0 {
+		return 0, errors.New("insufficient credits")
+	}
+
+	err = tx.QueryRow(fmt.Sprintf("UPDATE %s SET credits = credits + $2 WHERE uid = $1 RETURNING credits", walletTable), uid, amount).Scan(&credits)
+	if err != nil {
+		return 0, errors.New("failed to adjust credits")
+	}
+
+	ledgerTable := getDBSchemaTable("ledger")
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (uid, amount, reason, note) VALUES ($1, $2, $3, $4)", ledgerTable), uid, amount, ledgerAdjustment, note)
+	if err != nil {
+		return 0, errors.New("failed to post ledger entry")
+	}
+
+	auditDetail(&audit, "credits", credits)
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.New("failed to adjust credits")
+	}
+	return credits, nil
+}
+
+// endLease terminates the running lease of an accepted bid without charging its renter and frees its resource. It
+// returns the resource of the lease.
+func endLease(tx execer, bid string) (string, error) {
+	var rid string
+	bidTable := getDBSchemaTable("bids")
+	err := tx.QueryRow(fmt.Sprintf("UPDATE %s SET status = 'terminated', computing = false WHERE bid = $1 AND status = 'accepted' RETURNING rid", bidTable), bid).Scan(&rid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("lease is not running")
+		}
+		return "", errors.New("failed to end lease")
+	}
+
+	resourceTable := getDBSchemaTable("resources")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET computing = false, available = false, %s WHERE rid = $1", resourceTable, drainedStatus), rid)
+	if err != nil {
+		return "", errors.New("failed to update resource computing flag")
+	}
+	return rid, nil
+}
+
+// ForceEndLease terminates a running lease on behalf of an admin, the renter is not charged for it. It returns the
+// resource of the lease.
+func ForceEndLease(db *sql.DB, bid string, audit models.AuditEntry) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to end lease")
+	}
+	defer tx.Rollback()
+
+	rid, err := endLease(tx, bid)
+	if err != nil {
+		return "", err
+	}
+
+	auditDetail(&audit, "rid", rid)
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to end lease")
+	}
+	return rid, nil
+}
+
+// RejectPendingBids rejects the bids of a resource still waiting for its auction to close
+func RejectPendingBids(db *sql.DB, rid string, audit models.AuditEntry) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, errors.New("failed to reject bids")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("bids")
+	result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'rejected' WHERE rid = $1 AND status = 'pending'", table), rid)
+	if err != nil {
+		return 0, errors.New("failed to reject bids")
+	}
+	rejected, _ := result.RowsAffected()
+	if rejected == 0 {
+		return 0, errors.New("no pending bids")
+	}
+
+	auditDetail(&audit, "rejectedBids", int(rejected))
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.New("failed to reject bids")
+	}
+	return int(rejected), nil
+}
+
+// RemoveResource takes a resource off the exchange on behalf of an admin: the lease running on it is terminated
+// without charge, its pending bids are rejected and it is archived. It returns the bid of the terminated lease, empty
+// when none was running.
+func RemoveResource(db *sql.DB, rid string, audit models.AuditEntry) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to remove resource")
+	}
+	defer tx.Rollback()
+
+	var status string
+	table := getDBSchemaTable("resources")
+	err = tx.QueryRow(fmt.Sprintf("SELECT status FROM %s WHERE rid = $1 FOR UPDATE", table), rid).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("resource not found")
+		}
+		return "", errors.New("failed to remove resource")
+	}
+	if status == models.ResourceArchived {
+		return "", errors.New("resource is archived")
+	}
+
+	var bid string
+	bidTable := getDBSchemaTable("bids")
+	err = tx.QueryRow(fmt.Sprintf("SELECT bid FROM %s WHERE rid = $1 AND status = 'accepted' AND computing = true", bidTable), rid).Scan(&bid)
+	if err != nil && err != sql.ErrNoRows {
+		return "", errors.New("failed to remove resource")
+	}
+	if bid != "" {
+		if _, err = endLease(tx, bid); err != nil {
+			return "", err
+		}
+	}
+
+	if err = archiveResource(tx, rid, ""); err != nil {
+		return "", err
+	}
+
+	auditDetail(&audit, "endedLease", bid)
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to remove resource")
+	}
+	return bid, nil
+}
+
+// auditDetail adds an outcome of an admin action to its audit entry
+func auditDetail(entry *models.AuditEntry, key string, value interface{}) {
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details[key] = value
+}
+
+// insertAuditEntry records an action taken by an admin in the transaction taking it, an action that cannot be
+// audited is rolled back
+func insertAuditEntry(tx execer, entry models.AuditEntry) error {
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	encoded, err := json.Marshal(entry.Details)
+	if err != nil {
+		return errors.New("failed to record audit entry")
+	}
+
+	table := getDBSchemaTable("admin_audit")
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (adminUid, action, target, details) VALUES ($1, $2, $3, $4)", table), entry.Admin, entry.Action, entry.Target, encoded)
+	if err != nil {
+		return errors.New("failed to record audit entry")
+	}
+	return nil
+}
+
+// GetAuditLog returns the latest actions taken by admins, newest first
+func GetAuditLog(db *sql.DB, limit int) ([]models.AuditEntry, error) {
+	if limit <= 0 || limit > maxAuditLogLimit {
+		limit = defaultAuditLogLimit
+	}
+
+	table := getDBSchemaTable("admin_audit")
+	rows, err := db.Query(fmt.Sprintf("SELECT aid, adminUid, action, target, details, createdAt FROM %s ORDER BY aid DESC LIMIT $1", table), limit)
+	if err != nil {
+		return nil, errors.New("failed to get audit log")
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details []byte
+		if err = rows.Scan(&entry.AID, &entry.Admin, &entry.Action, &entry.Target, &details, &entry.CreatedAt); err != nil {
+			return nil, errors.New("failed to get audit log")
+		}
+		if err = json.Unmarshal(details, &entry.Details); err != nil {
+			return nil, errors.New("failed to get audit log")
+		}
+		entries = append(entries, entry)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to get audit log")
+	}
+	return entries, nil
+}
+
+// GetSystemStats counts the users, resources, bids, disputes and credits of the exchange. Active auctions are kept
+// in memory and left for the caller to fill in.
+func GetSystemStats(db *sql.DB) (models.SystemStats, error) {
+	var stats models.SystemStats
+	err := db.QueryRow(fmt.Sprintf(`
+		SELECT
+			(SELECT COUNT(*) FROM %[1]s),
+			(SELECT COUNT(*) FROM %[1]s WHERE suspendedAt IS NOT NULL),
+			(SELECT COUNT(*) FROM %[2]s WHERE status != 'archived'),
+			(SELECT COUNT(*) FROM %[2]s WHERE available AND status != 'archived'),
+			(SELECT COUNT(*) FROM %[2]s WHERE computing),
+			(SELECT COUNT(*) FROM %[3]s WHERE status = 'pending'),
+			(SELECT COUNT(*) FROM %[3]s WHERE status = 'accepted' AND computing),
+			(SELECT COUNT(*) FROM %[4]s WHERE status = 'open'),
+			(SELECT COALESCE(SUM(amount), 0) FROM %[5]s WHERE status IN ('held', 'disputed')),
+			(SELECT COALESCE(SUM(credits), 0) FROM %[6]s),
+			(SELECT COUNT(*) FROM %[7]s WHERE revokedAt IS NULL AND expiresAt > CURRENT_TIMESTAMP)`,
+		getDBSchemaTable("users"), getDBSchemaTable("resources"), getDBSchemaTable("bids"), getDBSchemaTable("disputes"),
+		getDBSchemaTable("escrows"), getDBSchemaTable("wallets"), getDBSchemaTable("sessions"))).Scan(
+		&stats.Users, &stats.SuspendedUsers, &stats.Resources, &stats.ListedResources, &stats.ComputingResources,
+		&stats.PendingBids, &stats.RunningLeases, &stats.OpenDisputes, &stats.EscrowHeld, &stats.WalletCredits, &stats.LiveSessions)
+	if err != nil {
+		return models.SystemStats{}, errors.New("failed to get system stats")
+	}
+	return stats, nil
+}
@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/lib/pq"
+)
+
+// InsertAPIKey stores a new API key of a user under its hash
+func InsertAPIKey(db *sql.DB, uid string, name string, keyHash string, scopes []string) (string, error) {
+	var kid string
+	table := getDBSchemaTable("api_keys")
+	err := db.QueryRow(fmt.Sprintf("INSERT INTO %s (uid, name, keyHash, scopes) VALUES ($1, $2, $3, $4) RETURNING kid", table), uid, name, keyHash, pq.Array(scopes)).Scan(&kid)
+	if err != nil {
+		return "", errors.New("failed to create api key")
+	}
+	return kid, nil
+}
+
+// UseAPIKey records the use of an API key from ip and returns its owner, its scopes and the current roles of the
+// owner. Revoked keys and keys of suspended users are invalid.
+func UseAPIKey(db *sql.DB, keyHash string, ip string) (string, []string, []string, error) {
+	var uid string
+	var scopes, roles []string
+	table := getDBSchemaTable("api_keys")
+	userTable := getDBSchemaTable("users")
+	roleTable := getDBSchemaTable("user_roles")
+	err := db.QueryRow(fmt.Sprintf(`
+		UPDATE %s k SET lastUsedAt = CURRENT_TIMESTAMP, lastUsedIP = $2
+		FROM %s u
+		WHERE k.keyHash = $1 AND k.revokedAt IS NULL AND u.uid = k.uid AND u.suspendedAt IS NULL
+		RETURNING k.uid, k.scopes, ARRAY(SELECT ur.role FROM %s ur WHERE ur.uid = k.uid)`, table, userTable, roleTable), keyHash, ip).Scan(&uid, pq.Array(&scopes), pq.Array(&roles))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, nil, errors.New("invalid api key")
+		}
+		return "", nil, nil, errors.New("failed to check api key")
+	}
+	return uid, scopes, roles, nil
+}
+
+// GetUserAPIKeys lists the API keys of a user that were not revoked, newest first
+func GetUserAPIKeys(db *sql.DB, uid string) ([]models.APIKey, error) {
+	table := getDBSchemaTable("api_keys")
+	rows, err := db.Query(fmt.Sprintf(`SELECT kid, name, scopes, createdAt, lastUsedAt, lastUsedIP FROM %s
+		WHERE uid = $1 AND revokedAt IS NULL ORDER BY kid DESC`, table), uid)
+	if err != nil {
+		return nil, errors.New("failed to get api keys")
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		err = rows.Scan(&key.KID, &key.Name, pq.Array(&key.Scopes), &key.CreatedAt, &key.LastUsedAt, &key.LastUsedIP)
+		if err != nil {
+			return nil, errors.New("failed to get api keys")
+		}
+		keys = append(keys, key)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to get api keys")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey stops an API key of a user from working, it is kept for the record
+func RevokeAPIKey(db *sql.DB, uid string, kid string) error {
+	table := getDBSchemaTable("api_keys")
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET revokedAt = CURRENT_TIMESTAMP WHERE kid = $1 AND uid = $2 AND revokedAt IS NULL", table), kid, uid)
+	if err != nil {
+		return errors.New("failed to revoke api key")
+	}
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		return errors.New("api key not found")
+	}
+	return nil
+}
+
+// InsertAgentResource creates a resource registered by a supplier agent, its first heartbeat is the registration and
+// agentKey is the public key its hardware reports are signed with
+func InsertAgentResource(db *sql.DB, resource models.Resource, uid string, agentKey string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to insert new resource")
+	}
+	defer tx.Rollback()
+
+	rid, err := insertResource(tx, resource, uid)
+	if err != nil {
+		return "", err
+	}
+
+	table := getDBSchemaTable("resources")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET lastHeartbeat = CURRENT_TIMESTAMP, agentKey = $2 WHERE rid = $1", table), rid, agentKey)
+	if err != nil {
+		return "", errors.New("failed to insert new resource")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to insert new resource")
+	}
+	return rid, nil
+}
+
+// RecordHeartbeat marks a resource of uid that is not archived as online and returns whether it is available and computing
+func RecordHeartbeat(db *sql.DB, rid string, uid string) (bool, bool, error) {
+	var available, computing bool
+	table := getDBSchemaTable("resources")
+	err := db.QueryRow(fmt.Sprintf(`
+		UPDATE %s SET lastHeartbeat = CURRENT_TIMESTAMP
+		WHERE rid = $1 AND uid = $2 AND status != 'archived'
+		RETURNING available, computing`, table), rid, uid).Scan(&available, &computing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, false, errors.New("resource not found")
+		}
+		return false, false, errors.New("failed to record heartbeat")
+	}
+
+	// Heartbeats received during a lease are kept as evidence for disputes
+	if computing {
+		bidTable := getDBSchemaTable("bids")
+		heartbeatTable := getDBSchemaTable("lease_heartbeats")
+		_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (bid) SELECT bid FROM %s WHERE rid = $1 AND status = 'accepted' AND computing = true", heartbeatTable, bidTable), rid)
+		if err != nil {
+			return false, false, errors.New("failed to record heartbeat")
+		}
+	}
+	return available, computing, nil
+}
+
+// ExpireAgentResources takes agent managed resources whose last heartbeat is older than before off the market. It
+// returns the resources that were listed and the accepted bids of the leases running on them, which must be failed.
+func ExpireAgentResources(db *sql.DB, before time.Time) ([]string, []models.BidWithID, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, errors.New("failed to expire agent resources")
+	}
+	defer tx.Rollback()
+
+	resourceTable := getDBSchemaTable("resources")
+	rows, err := tx.Query(fmt.Sprintf(`
+		WITH stale AS (
+			SELECT rid, available FROM %s
+			WHERE lastHeartbeat < $1 AND status IN ('active', 'draining') AND (available = true OR computing = true)
+			FOR UPDATE
+		)
+		UPDATE %s r SET available = false FROM stale WHERE r.rid = stale.rid
+		RETURNING r.rid, stale.available, r.computing`, resourceTable, resourceTable), before)
+	if err != nil {
+		return nil, nil, errors.New("failed to expire agent resources")
+	}
+
+	unlisted := []string{}
+	computingRIDs := []string{}
+	for rows.Next() {
+		var rid string
+		var wasAvailable, computing bool
+		if err := rows.Scan(&rid, &wasAvailable, &computing); err != nil {
+			rows.Close()
+			return nil, nil, errors.New("failed to expire agent resources")
+		}
+		if computing {
+			computingRIDs = append(computingRIDs, rid)
+		} else if wasAvailable {
+			unlisted = append(unlisted, rid)
+		}
+	}
+	rows.Close()
+
+	bidTable := getDBSchemaTable("bids")
+	for _, rid := range unlisted {
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'rejected' WHERE rid = $1 AND status = 'pending'", bidTable), rid)
+		if err != nil {
+			return nil, nil, errors.New("failed to reject bids of expired resource")
+		}
+	}
+
+	leases := []models.BidWithID{}
+	for _, rid := range computingRIDs {
+		var bid models.BidWithID
+		err = tx.QueryRow(fmt.Sprintf(`
+			SELECT bid, rid, amount, duration, status, computing, createdAt FROM %s
+			WHERE rid = $1 AND status = 'accepted' AND computing = true`, bidTable), rid).Scan(&bid.BID, &bid.Bid.RID, &bid.Bid.Amount, &bid.Bid.Duration, &bid.Status, &bid.Computing, &bid.CreatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return nil, nil, errors.New("failed to fetch lease of expired resource")
+		}
+		leases = append(leases, bid)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, errors.New("failed to expire agent resources")
+	}
+	return unlisted, leases, nil
+}
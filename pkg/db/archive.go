@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// archiveResource archives a resource owned by uid, any uid when empty
+func archiveResource(tx execer, rid string, uid string) error {
+	table := getDBSchemaTable("resources")
+	result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'archived', archivedAt = CURRENT_TIMESTAMP, available = false WHERE rid = $1 AND ($2 = '' OR uid::text = $2) AND computing = false AND status != 'archived'", table), rid, uid)
+	if err != nil {
+		return errors.New("failed to archive resource")
+	}
+	if archived, err := result.RowsAffected(); err != nil || archived == 0 {
+		return errors.New("resource is currently computing or already archived")
+	}
+
+	// Bids still waiting for the resource will never be served
+	bidTable := getDBSchemaTable("bids")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'rejected' WHERE rid = $1 AND status = 'pending'", bidTable), rid)
+	if err != nil {
+		return errors.New("failed to archive resource")
+	}
+	return nil
+}
+
+// ArchiveResource retires a resource: it is hidden from listings and bidding but kept, along with its bids,
+// revisions and clearing prices, for historical leases until purged
+func ArchiveResource(db *sql.DB, rid string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to archive resource")
+	}
+	defer tx.Rollback()
+
+	err = archiveResource(tx, rid, "")
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to archive resource")
+	}
+	return nil
+}
+
+// PurgeArchivedResources permanently deletes the resources archived before the given time together with their
+// revisions. Their bids are kept, detached from the resource, so the escrows, disputes, ratings and ledger entries of
+// past leases survive, and clearing prices are kept for the price history. Resources with an escrow still held or
+// disputed, or with an open dispute, are skipped until settled. It returns the number of purged resources.
+func PurgeArchivedResources(db *sql.DB, archivedBefore time.Time, audit models.AuditEntry) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, errors.New("failed to purge archived resources")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("resources")
+	bidTable := getDBSchemaTable("bids")
+	escrowTable := getDBSchemaTable("escrows")
+	disputeTable := getDBSchemaTable("disputes")
+	result, err := tx.Exec(fmt.Sprintf(`
+		DELETE FROM %s r WHERE r.status = 'archived' AND r.archivedAt < $1
+		AND NOT EXISTS (
+			SELECT 1 FROM %s b
+			LEFT JOIN %s e ON e.bid = b.bid
+			LEFT JOIN %s d ON d.bid = b.bid
+			WHERE b.rid = r.rid AND (e.status IN ('held', 'disputed') OR d.status = $2)
+		)`, table, bidTable, escrowTable, disputeTable), archivedBefore, models.DisputeOpen)
+	if err != nil {
+		return 0, errors.New("failed to purge archived resources")
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.New("failed to purge archived resources")
+	}
+
+	auditDetail(&audit, "purged", int(purged))
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.New("failed to purge archived resources")
+	}
+	return int(purged), nil
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// setResourceAvailability sets the availability of an idle resource owned by uid
+func setResourceAvailability(tx execer, rid string, uid string, available bool) error {
+	table := getDBSchemaTable("resources")
+	result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET available = $3 WHERE rid = $1 AND uid = $2 AND computing = false AND status = 'active'", table), rid, uid, available)
+	if err != nil {
+		return errors.New("failed to update resource availability")
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return errors.New("resource not found, currently computing or archived")
+	}
+
+	if !available {
+		bidTable := getDBSchemaTable("bids")
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'rejected' WHERE rid = $1 AND status = 'pending'", bidTable), rid)
+		if err != nil {
+			return errors.New("failed to reject bids for resource")
+		}
+	}
+	return nil
+}
+
+// ApplyBulkResourceItems applies the resolved items of a bulk request for uid in a single transaction. Processing
+// stops at the first failing item and every change is rolled back; the returned results describe each item.
+func ApplyBulkResourceItems(db *sql.DB, uid string, items []models.BulkResourceItem) ([]models.BulkResourceResult, error) {
+	results := make([]models.BulkResourceResult, len(items))
+	for i, item := range items {
+		results[i] = models.BulkResourceResult{Index: i, Action: item.Action, Status: "skipped"}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errors.New("failed to apply bulk request")
+	}
+	defer tx.Rollback()
+
+	var failure error
+	for i, item := range items {
+		switch item.Action {
+		case models.BulkCreate:
+			for n := 0; n < item.Count && failure == nil; n++ {
+				var rid string
+				rid, failure = insertResource(tx, item.Spec, uid)
+				results[i].RIDs = append(results[i].RIDs, rid)
+			}
+		case models.BulkSetAvailability:
+			failure = setResourceAvailability(tx, item.RID, uid, *item.Available)
+			results[i].RIDs = []string{item.RID}
+		case models.BulkRetire:
+			failure = archiveResource(tx, item.RID, uid)
+			results[i].RIDs = []string{item.RID}
+		default:
+			failure = errors.New("unknown action " + item.Action)
+		}
+
+		if failure != nil {
+			results[i].Status = "error"
+			results[i].Error = failure.Error()
+			results[i].RIDs = nil
+			for j := 0; j < i; j++ {
+				results[j].Status = "rolled back"
+				results[j].RIDs = nil
+			}
+			return results, errors.New("bulk request rolled back")
+		}
+		results[i].Status = "ok"
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.New("failed to apply bulk request")
+	}
+	return results, nil
+}
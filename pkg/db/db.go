@@ -2,13 +2,22 @@ package pkg
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/gunrgnhsr/Cycloud/pkg/acceptance"
+	"github.com/gunrgnhsr/Cycloud/pkg/hardware"
 	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/pricing"
+	"github.com/gunrgnhsr/Cycloud/pkg/reputation"
+	"github.com/gunrgnhsr/Cycloud/pkg/settlement"
+	"github.com/gunrgnhsr/Cycloud/pkg/workload"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // DBConfig holds the database configuration parameters.
@@ -39,9 +48,11 @@ func setTables(db *sql.DB, dbSchema string) error {
 		return err
 	}
 
+	// Persistent tables are only created when missing, they keep their rows across restarts
 	tables := []struct {
-		name   string
-		schema string
+		name       string
+		schema     string
+		persistent bool
 	}{
 		{
 			name: "users",
@@ -49,9 +60,73 @@ func setTables(db *sql.DB, dbSchema string) error {
 								uid SERIAL PRIMARY KEY,
 								username TEXT NOT NULL UNIQUE,
 								password TEXT NOT NULL,
+								email TEXT,
+								emailVerified BOOLEAN NOT NULL DEFAULT false,
+								suspendedAt TIMESTAMP WITH TIME ZONE,
+								suspensionReason TEXT NOT NULL DEFAULT '',
+								totpSecret TEXT,
+								totpEnabledAt TIMESTAMP WITH TIME ZONE,
+								totpLastStep BIGINT NOT NULL DEFAULT 0,
 								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 						)`,
 		},
+		{
+			name: "email_verifications",
+			schema: `CREATE TABLE ` + dbSchema + `.email_verifications (
+								tokenHash TEXT PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								expiresAt TIMESTAMP WITH TIME ZONE NOT NULL,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "recovery_codes",
+			schema: `CREATE TABLE ` + dbSchema + `.recovery_codes (
+								codeHash TEXT PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								usedAt TIMESTAMP WITH TIME ZONE,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "login_challenges",
+			schema: `CREATE TABLE ` + dbSchema + `.login_challenges (
+								challengeHash TEXT PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								attempts INTEGER NOT NULL DEFAULT 0,
+								expiresAt TIMESTAMP WITH TIME ZONE NOT NULL,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "settings",
+			schema: `CREATE TABLE ` + dbSchema + `.settings (
+								name TEXT PRIMARY KEY,
+								value TEXT NOT NULL,
+								updatedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+						)`,
+		},
+		{
+			name: "login_attempts",
+			schema: `CREATE TABLE ` + dbSchema + `.login_attempts (
+								attemptKey TEXT PRIMARY KEY,
+								failures INTEGER NOT NULL,
+								lastFailure TIMESTAMP WITH TIME ZONE NOT NULL
+						)`,
+			persistent: true,
+		},
+		{
+			name: "login_events",
+			schema: `CREATE TABLE ` + dbSchema + `.login_events (
+								eid SERIAL PRIMARY KEY,
+								uid INTEGER,
+								username TEXT NOT NULL,
+								ip TEXT NOT NULL,
+								event TEXT NOT NULL,
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid) ON DELETE SET NULL
+						)`,
+		},
 		{
 			name: "wallets",
 			schema: `CREATE TABLE ` + dbSchema + `.wallets (
@@ -60,14 +135,64 @@ func setTables(db *sql.DB, dbSchema string) error {
 						)`,
 		},
 		{
-			name: "tokens",
-			schema: `CREATE TABLE ` + dbSchema + `.tokens (
+			name: "user_roles",
+			schema: `CREATE TABLE ` + dbSchema + `.user_roles (
 								uid INTEGER NOT NULL,
-								token TEXT NOT NULL,
-								PRIMARY KEY (uid, token),
+								role TEXT NOT NULL,
+								grantedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								PRIMARY KEY (uid, role),
 								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
 						)`,
 		},
+		{
+			name: "sessions",
+			schema: `CREATE TABLE ` + dbSchema + `.sessions (
+								sid TEXT PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								device TEXT NOT NULL DEFAULT '',
+								ip TEXT NOT NULL DEFAULT '',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								lastUsedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								expiresAt TIMESTAMP WITH TIME ZONE NOT NULL,
+								revokedAt TIMESTAMP WITH TIME ZONE,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "refresh_tokens",
+			schema: `CREATE TABLE ` + dbSchema + `.refresh_tokens (
+								tokenHash TEXT PRIMARY KEY,
+								sid TEXT NOT NULL,
+								usedAt TIMESTAMP WITH TIME ZONE,
+								FOREIGN KEY (sid) REFERENCES ` + dbSchema + `.sessions(sid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "api_keys",
+			schema: `CREATE TABLE ` + dbSchema + `.api_keys (
+								kid SERIAL PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								name TEXT NOT NULL,
+								keyHash TEXT NOT NULL UNIQUE,
+								scopes TEXT[] NOT NULL DEFAULT '{}',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								lastUsedAt TIMESTAMP WITH TIME ZONE,
+								lastUsedIP TEXT NOT NULL DEFAULT '',
+								revokedAt TIMESTAMP WITH TIME ZONE,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "jwt_keys",
+			schema: `CREATE TABLE ` + dbSchema + `.jwt_keys (
+								kid TEXT PRIMARY KEY,
+								algorithm TEXT NOT NULL,
+								material BYTEA NOT NULL,
+								createdAt TIMESTAMP WITH TIME ZONE NOT NULL,
+								retiredAt TIMESTAMP WITH TIME ZONE
+						)`,
+			persistent: true,
+		},
 		{
 			name: "resources",
 			schema: `CREATE TABLE ` + dbSchema + `.resources (
@@ -81,23 +206,287 @@ func setTables(db *sql.DB, dbSchema string) error {
 								cost_per_hour NUMERIC NOT NULL CHECK (cost_per_hour >= 0),
 								available BOOLEAN DEFAULT false,
 								computing BOOLEAN DEFAULT false,
+								region TEXT NOT NULL DEFAULT '',
+								tags TEXT[] NOT NULL DEFAULT '{}',
+								gpus JSONB NOT NULL DEFAULT '[]',
+								gpu_count INTEGER NOT NULL DEFAULT 0,
+								gpu_vram INTEGER NOT NULL DEFAULT 0,
+								cpu_arch TEXT NOT NULL DEFAULT '',
+								cpu_model TEXT NOT NULL DEFAULT '',
+								os TEXT NOT NULL DEFAULT '',
+								cuda_version TEXT NOT NULL DEFAULT '',
+								zone TEXT NOT NULL DEFAULT '',
+								egress_limit INTEGER NOT NULL DEFAULT 0 CHECK (egress_limit >= 0),
+								labels JSONB NOT NULL DEFAULT '{}',
+								status TEXT NOT NULL DEFAULT 'active',
+								archivedAt TIMESTAMP WITH TIME ZONE,
+								lastHeartbeat TIMESTAMP WITH TIME ZONE,
+								agentKey TEXT,
+								verification TEXT NOT NULL DEFAULT 'unverified',
+								maintenanceStart TIMESTAMP WITH TIME ZONE,
+								maintenanceEnd TIMESTAMP WITH TIME ZONE,
+								maintenanceReason TEXT NOT NULL DEFAULT '',
 								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
 						)`,
 		},
+		{
+			name: "workload_policies",
+			schema: `CREATE TABLE ` + dbSchema + `.workload_policies (
+								rid INTEGER PRIMARY KEY,
+								policy JSONB NOT NULL,
+								updatedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "acceptance_policies",
+			schema: `CREATE TABLE ` + dbSchema + `.acceptance_policies (
+								rid INTEGER PRIMARY KEY,
+								policy JSONB NOT NULL,
+								updatedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "pools",
+			schema: `CREATE TABLE ` + dbSchema + `.pools (
+								pid SERIAL PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								name TEXT NOT NULL,
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								UNIQUE (uid, name),
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "pool_members",
+			schema: `CREATE TABLE ` + dbSchema + `.pool_members (
+								rid INTEGER PRIMARY KEY,
+								pid INTEGER NOT NULL,
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE CASCADE,
+								FOREIGN KEY (pid) REFERENCES ` + dbSchema + `.pools(pid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "pool_leases",
+			schema: `CREATE TABLE ` + dbSchema + `.pool_leases (
+								plid SERIAL PRIMARY KEY,
+								pid INTEGER NOT NULL,
+								uid INTEGER NOT NULL,
+								nodes INTEGER NOT NULL CHECK (nodes > 0),
+								amount NUMERIC NOT NULL CHECK (amount >= 0),
+								duration INTEGER NOT NULL CHECK (duration >= 0),
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (pid) REFERENCES ` + dbSchema + `.pools(pid),
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
 		{
 			name: "bids",
 			schema: `CREATE TABLE ` + dbSchema + `.bids (
 								bid SERIAL PRIMARY KEY,
 								uid INTEGER NOT NULL,
-								rid INTEGER NOT NULL,
+								rid INTEGER,
 								amount NUMERIC NOT NULL CHECK (amount >= 0),
 								duration INTEGER NOT NULL CHECK (duration >= 0),
 								status TEXT DEFAULT 'pending',
 								computing BOOLEAN DEFAULT false,
+								revision INTEGER,
+								plid INTEGER,
+								workload JSONB,
 								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid),
-								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid)
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE SET NULL,
+								FOREIGN KEY (plid) REFERENCES ` + dbSchema + `.pool_leases(plid)
+						)`,
+		},
+		{
+			name: "resource_revisions",
+			schema: `CREATE TABLE ` + dbSchema + `.resource_revisions (
+								rid INTEGER NOT NULL,
+								revision INTEGER NOT NULL,
+								uid INTEGER NOT NULL,
+								spec JSONB NOT NULL,
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								PRIMARY KEY (rid, revision),
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE CASCADE,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "hardware_reports",
+			schema: `CREATE TABLE ` + dbSchema + `.hardware_reports (
+								hid SERIAL PRIMARY KEY,
+								rid INTEGER NOT NULL,
+								report TEXT NOT NULL,
+								signature TEXT NOT NULL,
+								divergences JSONB NOT NULL DEFAULT '[]',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "resource_templates",
+			schema: `CREATE TABLE ` + dbSchema + `.resource_templates (
+								tid SERIAL PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								name TEXT NOT NULL,
+								spec JSONB NOT NULL,
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								UNIQUE (uid, name),
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "pricing_rules",
+			schema: `CREATE TABLE ` + dbSchema + `.pricing_rules (
+								rid INTEGER PRIMARY KEY,
+								rules JSONB NOT NULL,
+								updatedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (rid) REFERENCES ` + dbSchema + `.resources(rid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "clearing_prices",
+			schema: `CREATE TABLE ` + dbSchema + `.clearing_prices (
+								cpid SERIAL PRIMARY KEY,
+								bid INTEGER UNIQUE,
+								rid INTEGER NOT NULL,
+								cpu_cores INTEGER NOT NULL,
+								memory INTEGER NOT NULL,
+								storage INTEGER NOT NULL,
+								gpu TEXT NOT NULL,
+								bandwidth INTEGER NOT NULL,
+								amount NUMERIC NOT NULL,
+								duration INTEGER NOT NULL,
+								clearedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE SET NULL
+						)`,
+		},
+		{
+			name: "reputations",
+			schema: `CREATE TABLE ` + dbSchema + `.reputations (
+								uid INTEGER PRIMARY KEY,
+								score NUMERIC NOT NULL DEFAULT 100 CHECK (score >= 0),
+								penalty NUMERIC NOT NULL DEFAULT 0,
+								rating NUMERIC NOT NULL DEFAULT 0,
+								ratings INTEGER NOT NULL DEFAULT 0,
+								connections INTEGER NOT NULL DEFAULT 0,
+								connectionSuccessRate NUMERIC NOT NULL DEFAULT 1,
+								earlyTerminations INTEGER NOT NULL DEFAULT 0,
+								noShows INTEGER NOT NULL DEFAULT 0,
+								disputes INTEGER NOT NULL DEFAULT 0,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "lease_events",
+			schema: `CREATE TABLE ` + dbSchema + `.lease_events (
+								eid SERIAL PRIMARY KEY,
+								bid INTEGER NOT NULL,
+								uid INTEGER NOT NULL,
+								event TEXT NOT NULL,
+								amount NUMERIC NOT NULL DEFAULT 0,
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								UNIQUE (bid, uid, event),
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE CASCADE,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "ratings",
+			schema: `CREATE TABLE ` + dbSchema + `.ratings (
+								bid INTEGER NOT NULL,
+								rater INTEGER NOT NULL,
+								ratee INTEGER NOT NULL,
+								score INTEGER NOT NULL CHECK (score BETWEEN 1 AND 5),
+								comment TEXT NOT NULL DEFAULT '',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								PRIMARY KEY (bid, rater),
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE CASCADE,
+								FOREIGN KEY (rater) REFERENCES ` + dbSchema + `.users(uid),
+								FOREIGN KEY (ratee) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "signaling_logs",
+			schema: `CREATE TABLE ` + dbSchema + `.signaling_logs (
+								slid SERIAL PRIMARY KEY,
+								bid INTEGER NOT NULL,
+								uid INTEGER NOT NULL,
+								event TEXT NOT NULL,
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE CASCADE,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "lease_heartbeats",
+			schema: `CREATE TABLE ` + dbSchema + `.lease_heartbeats (
+								bid INTEGER NOT NULL,
+								receivedAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE CASCADE
+						)`,
+		},
+		{
+			name: "escrows",
+			schema: `CREATE TABLE ` + dbSchema + `.escrows (
+								bid INTEGER PRIMARY KEY,
+								renter INTEGER NOT NULL,
+								supplier INTEGER NOT NULL,
+								amount NUMERIC NOT NULL CHECK (amount >= 0),
+								status TEXT NOT NULL DEFAULT 'held',
+								releaseAt TIMESTAMP WITH TIME ZONE NOT NULL,
+								settledAt TIMESTAMP WITH TIME ZONE,
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE CASCADE,
+								FOREIGN KEY (renter) REFERENCES ` + dbSchema + `.users(uid),
+								FOREIGN KEY (supplier) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "disputes",
+			schema: `CREATE TABLE ` + dbSchema + `.disputes (
+								did SERIAL PRIMARY KEY,
+								bid INTEGER NOT NULL UNIQUE,
+								opener INTEGER NOT NULL,
+								against INTEGER NOT NULL,
+								reason TEXT NOT NULL,
+								status TEXT NOT NULL DEFAULT 'open',
+								refund NUMERIC NOT NULL DEFAULT 0,
+								upheld BOOLEAN NOT NULL DEFAULT false,
+								resolution TEXT NOT NULL DEFAULT '',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								resolvedAt TIMESTAMP WITH TIME ZONE,
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE CASCADE,
+								FOREIGN KEY (opener) REFERENCES ` + dbSchema + `.users(uid),
+								FOREIGN KEY (against) REFERENCES ` + dbSchema + `.users(uid)
+						)`,
+		},
+		{
+			name: "ledger",
+			schema: `CREATE TABLE ` + dbSchema + `.ledger (
+								lid SERIAL PRIMARY KEY,
+								uid INTEGER NOT NULL,
+								bid INTEGER,
+								amount NUMERIC NOT NULL,
+								reason TEXT NOT NULL,
+								note TEXT NOT NULL DEFAULT '',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (uid) REFERENCES ` + dbSchema + `.users(uid),
+								FOREIGN KEY (bid) REFERENCES ` + dbSchema + `.bids(bid) ON DELETE SET NULL
+						)`,
+		},
+		{
+			name: "admin_audit",
+			schema: `CREATE TABLE ` + dbSchema + `.admin_audit (
+								aid SERIAL PRIMARY KEY,
+								adminUid INTEGER NOT NULL,
+								action TEXT NOT NULL,
+								target TEXT NOT NULL DEFAULT '',
+								details JSONB NOT NULL DEFAULT '{}',
+								createdAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+								FOREIGN KEY (adminUid) REFERENCES ` + dbSchema + `.users(uid)
 						)`,
 		},
 	}
@@ -109,6 +498,10 @@ func setTables(db *sql.DB, dbSchema string) error {
 			return err
 		}
 
+		if exists && table.persistent {
+			continue
+		}
+
 		if exists {
 			// Check if the table has the correct schema
 			var columnCount int
@@ -188,79 +581,192 @@ func CloseDB(db *sql.DB) {
 	db.Close()
 }
 
-func GetUserOrRegisterIfNotExist(db *sql.DB, username, password string) (string, error) {
-	var storedPassword string
-	var uid string
+// GetUserCredentials returns the uid and password hash of a user, whether the user has yet to verify its email and
+// whether it is suspended
+func GetUserCredentials(db *sql.DB, username string) (string, string, bool, bool, error) {
+	var uid, passwordHash string
+	var emailPending, suspended bool
 	table := getDBSchemaTable("users")
-	err := db.QueryRow(fmt.Sprintf("SELECT uid, password FROM %s WHERE username = $1", table), username).Scan(&uid, &storedPassword)
+	err := db.QueryRow(fmt.Sprintf("SELECT uid, password, email IS NOT NULL AND NOT emailVerified, suspendedAt IS NOT NULL FROM %s WHERE username = $1", table), username).Scan(&uid, &passwordHash, &emailPending, &suspended)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			// Register the new user
-			err = db.QueryRow(fmt.Sprintf("INSERT INTO %s (username, password) VALUES ($1, $2) RETURNING uid", table), username, password).Scan(&uid)
-			if err != nil {
-				return "", errors.New("failed to register user")
-			} else {
-				// Create a wallet for the new user
-				walletTable := getDBSchemaTable("wallets")
-				_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (uid) VALUES ($1)", walletTable), uid)
-				if err != nil {
-					return "", errors.New("failed to create wallet for user")
-				}
-				return uid, errors.New("user registered")
-			}
-		} else {
-			return "", errors.New("failed to authenticate user")
-		}
-	} else {
-		// Compare the stored password with the provided password
-		if storedPassword != password {
-			return "", errors.New("invalid username or password")
+			return "", "", false, false, errors.New("user not found")
 		}
+		return "", "", false, false, errors.New("failed to authenticate user")
 	}
-	return uid, nil
+	return uid, passwordHash, emailPending, suspended, nil
 }
 
-func InsertToken(db *sql.DB, uid, token string) error {
-	table := getDBSchemaTable("tokens")
-	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (uid, token) VALUES ($1, $2)", table), uid, token)
+// RegisterUser creates a user with its wallet and roles and returns its uid, email is empty for users without one. A
+// grant given for roles beyond the default ones is audited as made by the new user on itself.
+func RegisterUser(db *sql.DB, username string, passwordHash string, email string, roles []string, grant *models.AuditEntry) (string, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return errors.New("failed to store token")
+		return "", errors.New("failed to register user")
+	}
+	defer tx.Rollback()
+
+	var emailValue interface{}
+	if email != "" {
+		emailValue = email
 	}
-	return nil
-}
 
-func GetUserIDFromToken(db *sql.DB, token string) (string, error) {
 	var uid string
-	table := getDBSchemaTable("tokens")
-	err := db.QueryRow(fmt.Sprintf("SELECT uid FROM %s WHERE token = $1", table), token).Scan(&uid)
+	table := getDBSchemaTable("users")
+	err = tx.QueryRow(fmt.Sprintf(`
+		INSERT INTO %s (username, password, email) VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO NOTHING
+		RETURNING uid`, table), username, passwordHash, emailValue).Scan(&uid)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", errors.New("token not found")
+			return "", errors.New("username already taken")
+		}
+		return "", errors.New("failed to register user")
+	}
+
+	// Create a wallet for the new user
+	walletTable := getDBSchemaTable("wallets")
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (uid) VALUES ($1)", walletTable), uid)
+	if err != nil {
+		return "", errors.New("failed to create wallet for user")
+	}
+
+	for _, role := range roles {
+		if err = grantRole(tx, uid, role); err != nil {
+			return "", errors.New("failed to register user")
 		}
-		return "", errors.New("failed to check token")
 	}
 
-	if uid == "" {
-		return "", errors.New("token not found")
+	if grant != nil {
+		entry := *grant
+		entry.Admin, entry.Target = uid, uid
+		if err = insertAuditEntry(tx, entry); err != nil {
+			return "", err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to register user")
 	}
 	return uid, nil
 }
 
-func RemoveExpiredToken(db *sql.DB, uid string) error {
-	table := getDBSchemaTable("tokens")
-	_, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = $1", table), uid)
+// UpdatePasswordHash replaces the password hash of a user, e.g. when a legacy hash is upgraded on login
+func UpdatePasswordHash(db *sql.DB, uid string, passwordHash string) error {
+	table := getDBSchemaTable("users")
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET password = $2 WHERE uid = $1", table), uid, passwordHash)
 	if err != nil {
-		return errors.New("failed to remove expired token")
+		return errors.New("failed to update password hash")
 	}
 	return nil
 }
 
-func InsertNewResourse(db *sql.DB, resource models.Resource, uid string) error {
+// resourceColumns lists the resource columns read by scanResource, in order, followed by the supplier reputation
+func resourceColumns() string {
+	return "rid, cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour, available, computing, region, tags, gpus, cpu_arch, cpu_model, os, cuda_version, zone, egress_limit, labels, status, verification, maintenanceStart, maintenanceEnd, maintenanceReason, archivedAt, createdAt, " + supplierReputation()
+}
+
+// supplierReputation is the score of the owner of a resource row
+func supplierReputation() string {
+	return fmt.Sprintf("COALESCE((SELECT rep.score FROM %s rep WHERE rep.uid = resources.uid), %d)", getDBSchemaTable("reputations"), reputation.DefaultScore)
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanResource(row rowScanner) (models.ResourceWithID, error) {
+	var resource models.ResourceWithID
+	var gpus, labels []byte
+	var maintenance models.MaintenanceWindow
+	var maintenanceStart, maintenanceEnd *time.Time
+	err := row.Scan(&resource.RID, &resource.Resource.CPUCores, &resource.Resource.Memory, &resource.Resource.Storage, &resource.Resource.GPU, &resource.Resource.Bandwidth, &resource.Resource.CostPerMinute, &resource.Resource.Available, &resource.Resource.Computing, &resource.Resource.Region, pq.Array(&resource.Resource.Tags),
+		&gpus, &resource.Resource.CPUArch, &resource.Resource.CPUModel, &resource.Resource.OS, &resource.Resource.CUDAVersion, &resource.Resource.Zone, &resource.Resource.EgressLimit, &labels, &resource.Status, &resource.Verification, &maintenanceStart, &maintenanceEnd, &maintenance.Reason, &resource.ArchivedAt, &resource.CreatedAt, &resource.Reputation)
+	if err != nil {
+		return resource, err
+	}
+	if maintenanceStart != nil && maintenanceEnd != nil {
+		maintenance.Start, maintenance.End = *maintenanceStart, *maintenanceEnd
+		resource.Maintenance = &maintenance
+	}
+	if err = json.Unmarshal(gpus, &resource.Resource.GPUs); err != nil {
+		return resource, err
+	}
+	err = json.Unmarshal(labels, &resource.Resource.Labels)
+	return resource, err
+}
+
+// resourceSpecValues returns the values of the resource spec columns in the order of resourceSpecColumns
+func resourceSpecValues(resource models.Resource) ([]interface{}, error) {
+	if resource.GPUs == nil {
+		resource.GPUs = []models.GPU{}
+	}
+	gpus, err := json.Marshal(resource.GPUs)
+	if err != nil {
+		return nil, err
+	}
+	if resource.Labels == nil {
+		resource.Labels = map[string]string{}
+	}
+	if resource.Tags == nil {
+		resource.Tags = []string{}
+	}
+	labels, err := json.Marshal(resource.Labels)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{resource.CPUCores, resource.Memory, resource.Storage, resource.GPU, resource.Bandwidth, resource.CostPerMinute, resource.Region, pq.Array(resource.Tags),
+		gpus, hardware.GPUCount(resource), hardware.MaxVRAM(resource), resource.CPUArch, resource.CPUModel, resource.OS, resource.CUDAVersion, resource.Zone, resource.EgressLimit, labels}, nil
+}
+
+// resourceSpecColumns lists the resource columns written from a models.Resource
+const resourceSpecColumns = "cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour, region, tags, gpus, gpu_count, gpu_vram, cpu_arch, cpu_model, os, cuda_version, zone, egress_limit, labels"
+
+// placeholders returns n comma separated query placeholders starting at $from
+func placeholders(from int, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(list, ", ")
+}
+
+// insertResource inserts a resource and records its initial spec as the first revision
+func insertResource(tx execer, resource models.Resource, uid string) (string, error) {
 	var rid string
+	values, err := resourceSpecValues(resource)
+	if err != nil {
+		return "", errors.New("failed to insert new resource")
+	}
+
 	table := getDBSchemaTable("resources")
-	err := db.QueryRow(fmt.Sprintf("INSERT INTO %s (uid, cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING rid", table),
-		uid, resource.CPUCores, resource.Memory, resource.Storage, resource.GPU, resource.Bandwidth, resource.CostPerMinute).Scan(&rid)
+	err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (uid, %s) VALUES ($1, %s) RETURNING rid", table, resourceSpecColumns, placeholders(2, len(values))),
+		append([]interface{}{uid}, values...)...).Scan(&rid)
+	if err != nil {
+		return "", errors.New("failed to insert new resource")
+	}
+
+	err = recordResourceRevision(tx, rid, uid, resource)
+	if err != nil {
+		return "", err
+	}
+	return rid, nil
+}
+
+func InsertNewResourse(db *sql.DB, resource models.Resource, uid string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to insert new resource")
+	}
+	defer tx.Rollback()
+
+	_, err = insertResource(tx, resource, uid)
 	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return errors.New("failed to insert new resource")
 	}
 	return nil
@@ -269,40 +775,57 @@ func InsertNewResourse(db *sql.DB, resource models.Resource, uid string) error {
 func UpdateResourceAvailability(db *sql.DB, rid string) (bool, error) {
 	var available bool
 	table := getDBSchemaTable("resources")
-	err := db.QueryRow(fmt.Sprintf("UPDATE %s SET available = NOT available WHERE rid = $1 AND computing = false RETURNING available", table), rid).Scan(&available)
+	err := db.QueryRow(fmt.Sprintf("UPDATE %s SET available = NOT available WHERE rid = $1 AND computing = false AND status = 'active' RETURNING available", table), rid).Scan(&available)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, errors.New("resource is currently computing")
+			return false, availabilityError(db, table, rid)
 		}
 		return false, errors.New("failed to update resource availability")
 	}
 	return available, nil
 }
 
-func CheckResourceAvailability(db *sql.DB, rid string) (bool, error) {
-	var available bool
+// SetResourceAvailability makes an idle active resource available or unavailable, unlike UpdateResourceAvailability it
+// does not depend on the current availability so a late or repeated call cannot flip it the wrong way
+func SetResourceAvailability(db *sql.DB, rid string, available bool) error {
 	table := getDBSchemaTable("resources")
-	err := db.QueryRow(fmt.Sprintf("SELECT available FROM %s WHERE rid = $1", table), rid).Scan(&available)
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET available = $2 WHERE rid = $1 AND computing = false AND status = 'active'", table), rid, available)
 	if err != nil {
-		return false, errors.New("failed to check resource availability")
+		return errors.New("failed to update resource availability")
 	}
-	return available, nil
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return availabilityError(db, table, rid)
+	}
+	return nil
+}
+
+// availabilityError explains why the availability of a resource could not be changed
+func availabilityError(db *sql.DB, table string, rid string) error {
+	var status string
+	if db.QueryRow(fmt.Sprintf("SELECT status FROM %s WHERE rid = $1", table), rid).Scan(&status) == nil {
+		switch status {
+		case models.ResourceArchived:
+			return errors.New("resource is archived")
+		case models.ResourceDraining, models.ResourceMaintenance:
+			return errors.New("resource is under maintenance")
+		}
+	}
+	return errors.New("resource is currently computing")
 }
 
-func DeleteResource(db *sql.DB, rid string) error {
+func CheckResourceAvailability(db *sql.DB, rid string) (bool, error) {
+	var available bool
 	table := getDBSchemaTable("resources")
-	_, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE rid = $1", table), rid)
+	err := db.QueryRow(fmt.Sprintf("SELECT available FROM %s WHERE rid = $1", table), rid).Scan(&available)
 	if err != nil {
-		return errors.New("failed to delete resource")
+		return false, errors.New("failed to check resource availability")
 	}
-	return nil
+	return available, nil
 }
 
 func GetResourceByID(db *sql.DB, rid string) (models.ResourceWithID, error) {
-	var resource models.ResourceWithID
 	table := getDBSchemaTable("resources")
-	err := db.QueryRow(fmt.Sprintf("SELECT rid, cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour, available, createdAt FROM %s WHERE rid = $1", table), rid).Scan(
-		&resource.RID, &resource.Resource.CPUCores, &resource.Resource.Memory, &resource.Resource.Storage, &resource.Resource.GPU, &resource.Resource.Bandwidth, &resource.Resource.CostPerMinute, &resource.Resource.Available, &resource.CreatedAt)
+	resource, err := scanResource(db.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE rid = $1", resourceColumns(), table), rid))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return models.ResourceWithID{}, errors.New("resource not found")
@@ -315,7 +838,7 @@ func GetResourceByID(db *sql.DB, rid string) (models.ResourceWithID, error) {
 
 func GetUserResources(db *sql.DB, uid string) ([]models.ResourceWithID, error) {
 	table := getDBSchemaTable("resources")
-	rows, err := db.Query(fmt.Sprintf("SELECT rid, cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour, available, computing, createdAt FROM %s WHERE uid = $1 ORDER BY rid", table), uid)
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE uid = $1 AND status != 'archived' ORDER BY rid", resourceColumns(), table), uid)
 	if err != nil {
 		return nil, errors.New("failed to fetch resources")
 	}
@@ -323,8 +846,7 @@ func GetUserResources(db *sql.DB, uid string) ([]models.ResourceWithID, error) {
 
 	resources := []models.ResourceWithID{}
 	for rows.Next() {
-		var resource models.ResourceWithID
-		err := rows.Scan(&resource.RID, &resource.Resource.CPUCores, &resource.Resource.Memory, &resource.Resource.Storage, &resource.Resource.GPU, &resource.Resource.Bandwidth, &resource.Resource.CostPerMinute, &resource.Resource.Available, &resource.Resource.Computing, &resource.CreatedAt)
+		resource, err := scanResource(rows)
 		if err != nil {
 			return nil, errors.New("failed to fetch resources")
 		}
@@ -356,7 +878,7 @@ func GetNextOrPrevTwentyAvailableResourcesFromGivenRID(db *sql.DB, uid string, r
 		operator = ">"
 	}
 	table := getDBSchemaTable("resources")
-	rows, err := db.Query(fmt.Sprintf("SELECT rid, cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour, available, createdAt FROM %s WHERE rid %s $1 AND available = true AND uid != $2 LIMIT 20", table, operator), rid, uid)
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE rid %s $1 AND available = true AND status = 'active' AND uid != $2 LIMIT 20", resourceColumns(), table, operator), rid, uid)
 	if err != nil {
 		return nil, errors.New("failed to fetch resources")
 	}
@@ -364,8 +886,7 @@ func GetNextOrPrevTwentyAvailableResourcesFromGivenRID(db *sql.DB, uid string, r
 
 	resources := []models.ResourceWithID{}
 	for rows.Next() {
-		var resource models.ResourceWithID
-		err := rows.Scan(&resource.RID, &resource.Resource.CPUCores, &resource.Resource.Memory, &resource.Resource.Storage, &resource.Resource.GPU, &resource.Resource.Bandwidth, &resource.Resource.CostPerMinute, &resource.Resource.Available, &resource.CreatedAt)
+		resource, err := scanResource(rows)
 		if err != nil {
 			return nil, errors.New("failed to fetch resources")
 		}
@@ -386,17 +907,63 @@ func InsertNewBid(db *sql.DB, uid string, bid models.Bid) (models.BidWithID, str
 	if err != nil {
 		return models.BidWithID{}, "", err
 	}
-	if !resource.Resource.Available {
+	if !resource.Resource.Available || resource.Status != models.ResourceActive {
 		return models.BidWithID{}, "resource not available for bidding", errors.New("resource not available for bidding")
 	}
 
-	if resource.Resource.CostPerMinute > bid.Amount {
-		return models.BidWithID{}, "bid amount is less than the resource cost per minute", errors.New("bid amount is less than the resource cost per minute which is " + fmt.Sprintf("%.2f", resource.Resource.CostPerMinute))
+	// A lease running into the maintenance window would hold the drain up until it ends
+	if overlapsMaintenance(resource.Maintenance, time.Now(), bid.Duration) {
+		return models.BidWithID{}, "lease overlaps maintenance", fmt.Errorf("the resource is under maintenance from %s to %s, a lease of %d hours would run into it",
+			resource.Maintenance.Start.Format(time.RFC3339), resource.Maintenance.End.Format(time.RFC3339), bid.Duration)
+	}
+
+	// Pool nodes are only leased through their pool
+	pid, err := GetResourcePool(db, bid.RID)
+	if err != nil {
+		return models.BidWithID{}, "", err
+	}
+	if pid != "" {
+		return models.BidWithID{}, "resource not available for bidding", errors.New("resource is part of pool " + pid + ", place a pool loan request instead")
+	}
+
+	rules, err := GetPricingRules(db, bid.RID)
+	if err != nil {
+		return models.BidWithID{}, "", err
+	}
+	minimumAmount := pricing.MinimumAmount(resource.Resource.CostPerMinute, rules, time.Now(), bid.Duration)
+	if minimumAmount > bid.Amount {
+		return models.BidWithID{}, "bid amount is less than the resource cost per minute", errors.New("bid amount is less than the resource cost per minute which is " + fmt.Sprintf("%.2f", minimumAmount))
 	}
 	if resource.Resource.Computing {
 		return models.BidWithID{}, "resource is currently computing", errors.New("resource is currently computing")
 	}
 
+	acceptancePolicy, err := GetAcceptancePolicy(db, bid.RID)
+	if err != nil {
+		return models.BidWithID{}, "", err
+	}
+	reputation, err := GetReputation(db, uid)
+	if err != nil {
+		return models.BidWithID{}, "", err
+	}
+	err = acceptance.Check(acceptancePolicy, uid, reputation, bid)
+	if err != nil {
+		return models.BidWithID{}, "bid rejected by policy", err
+	}
+
+	policy, err := GetWorkloadPolicy(db, bid.RID)
+	if err != nil {
+		return models.BidWithID{}, "", err
+	}
+	err = workload.Check(policy, bid.Workload, resource.Resource)
+	if err != nil {
+		return models.BidWithID{}, "workload rejected by policy", err
+	}
+	encodedWorkload, err := encodeWorkload(bid.Workload)
+	if err != nil {
+		return models.BidWithID{}, "", err
+	}
+
 	table := getDBSchemaTable("bids")
 	// Check if the user has already placed a bid for the resource
 	var existingBid models.BidWithUID
@@ -437,11 +1004,12 @@ func InsertNewBid(db *sql.DB, uid string, bid models.Bid) (models.BidWithID, str
 		return models.BidWithID{}, "insufficient credits to place bid", errors.New("insufficient credits to place bid, only " + fmt.Sprintf("%.2f", userCredits) + " credits available and your total bid amount is " + fmt.Sprintf("%.2f", totalAmount))
 	}
 	var newBid models.BidWithID
-	err = db.QueryRow(fmt.Sprintf("INSERT INTO %s (uid, rid, amount, duration) VALUES ($1, $2, $3, $4) RETURNING bid, rid, amount, duration, status, createdAt", table),
-		uid, bid.RID, bid.Amount, bid.Duration).Scan(&newBid.BID, &newBid.Bid.RID, &newBid.Bid.Amount, &newBid.Bid.Duration, &newBid.Status, &newBid.CreatedAt)
+	err = db.QueryRow(fmt.Sprintf("INSERT INTO %s (uid, rid, amount, duration, workload) VALUES ($1, $2, $3, $4, $5) RETURNING bid, rid, amount, duration, status, createdAt", table),
+		uid, bid.RID, bid.Amount, bid.Duration, encodedWorkload).Scan(&newBid.BID, &newBid.Bid.RID, &newBid.Bid.Amount, &newBid.Bid.Duration, &newBid.Status, &newBid.CreatedAt)
 	if err != nil {
 		return models.BidWithID{}, "", err
 	}
+	newBid.Workload = bid.Workload
 	return newBid, "", nil
 }
 
@@ -466,7 +1034,7 @@ func RemoveBid(db *sql.DB, id string) error {
 
 func GetUserBids(db *sql.DB, uid string) ([]models.BidWithID, error) {
 	table := getDBSchemaTable("bids")
-	rows, err := db.Query(fmt.Sprintf("SELECT bid, rid, amount, duration, status, computing, createdAt FROM %s WHERE uid = $1 ORDER BY rid", table), uid)
+	rows, err := db.Query(fmt.Sprintf("SELECT bid, COALESCE(rid::text, ''), amount, duration, status, computing, createdAt, workload FROM %s WHERE uid = $1 ORDER BY rid", table), uid)
 	if err != nil {
 		return nil, err
 	}
@@ -475,10 +1043,17 @@ func GetUserBids(db *sql.DB, uid string) ([]models.BidWithID, error) {
 	bids := []models.BidWithID{}
 	for rows.Next() {
 		var bid models.BidWithID
-		err := rows.Scan(&bid.BID, &bid.Bid.RID, &bid.Bid.Amount, &bid.Bid.Duration, &bid.Status, &bid.Computing, &bid.CreatedAt)
+		var encodedWorkload []byte
+		err := rows.Scan(&bid.BID, &bid.Bid.RID, &bid.Bid.Amount, &bid.Bid.Duration, &bid.Status, &bid.Computing, &bid.CreatedAt, &encodedWorkload)
 		if err != nil {
 			return nil, err
 		}
+		if encodedWorkload != nil {
+			bid.Workload = new(models.Workload)
+			if err = json.Unmarshal(encodedWorkload, bid.Workload); err != nil {
+				return nil, err
+			}
+		}
 		bids = append(bids, bid)
 	}
 
@@ -552,7 +1127,7 @@ func UpdateUserCredits(db *sql.DB, uid string, amount float64) (float64, error)
 func GetNumberOfResources(db *sql.DB, uid string) (int, error) {
 	var count int
 	table := getDBSchemaTable("resources")
-	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE uid = $1", table), uid).Scan(&count)
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE uid = $1 AND status != 'archived'", table), uid).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
@@ -592,7 +1167,7 @@ func GetNumberOfAcceptedBidsCurrentlyRunning(db *sql.DB, uid string) (int, error
 // bidding package logic
 func GetAllAvailableResourcesForBidding(db *sql.DB) ([]models.ResourceWithID, error) {
 	table := getDBSchemaTable("resources")
-	rows, err := db.Query(fmt.Sprintf("SELECT rid, cpu_cores, memory, storage, gpu, bandwidth, cost_per_hour, available, createdAt FROM %s WHERE available = true AND computing = false ORDER BY rid", table))
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE available = true AND computing = false AND status = 'active' ORDER BY rid", resourceColumns(), table))
 	if err != nil {
 		return nil, errors.New("failed to fetch resources")
 	}
@@ -600,8 +1175,7 @@ func GetAllAvailableResourcesForBidding(db *sql.DB) ([]models.ResourceWithID, er
 
 	resources := []models.ResourceWithID{}
 	for rows.Next() {
-		var resource models.ResourceWithID
-		err := rows.Scan(&resource.RID, &resource.Resource.CPUCores, &resource.Resource.Memory, &resource.Resource.Storage, &resource.Resource.GPU, &resource.Resource.Bandwidth, &resource.Resource.CostPerMinute, &resource.Resource.Available, &resource.CreatedAt)
+		resource, err := scanResource(rows)
 		if err != nil {
 			return nil, errors.New("failed to fetch resources")
 		}
@@ -612,15 +1186,22 @@ func GetAllAvailableResourcesForBidding(db *sql.DB) ([]models.ResourceWithID, er
 }
 
 func GetMaxBidForResource(db *sql.DB, resourceID string) (models.BidWithUID, error) {
+	// The bids are settled, the resource marked computing and the clearing price recorded together
+	tx, err := db.Begin()
+	if err != nil {
+		return models.BidWithUID{}, errors.New("failed to update bids status")
+	}
+	defer tx.Rollback()
+
 	table := getDBSchemaTable("bids")
 	var maxBid models.BidWithUID
 	// Set all bids for the resource to 'processing' status
-	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET status = 'processing' WHERE rid = $1", table), resourceID)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'processing' WHERE rid = $1", table), resourceID)
 	if err != nil {
 		return models.BidWithUID{}, errors.New("failed to update bids to processing status")
 	}
 
-	err = db.QueryRow(fmt.Sprintf("SELECT bid, uid, rid, amount, duration, status, createdAt FROM %s WHERE rid = $1 ORDER BY amount DESC, duration DESC LIMIT 1", table), resourceID).Scan(
+	err = tx.QueryRow(fmt.Sprintf("SELECT bid, uid, rid, amount, duration, status, createdAt FROM %s WHERE rid = $1 ORDER BY amount DESC, duration DESC LIMIT 1", table), resourceID).Scan(
 		&maxBid.BidWithID.BID, &maxBid.UID, &maxBid.BidWithID.Bid.RID, &maxBid.BidWithID.Bid.Amount, &maxBid.BidWithID.Bid.Duration, &maxBid.BidWithID.Status, &maxBid.BidWithID.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -630,33 +1211,55 @@ func GetMaxBidForResource(db *sql.DB, resourceID string) (models.BidWithUID, err
 	}
 
 	// Set the selected bid status to accepted and all other bids for the resource to rejected in one query
-	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET status = CASE WHEN bid = $1 THEN 'accepted' ELSE 'rejected' END, computing = CASE WHEN bid = $1 THEN true END WHERE rid = $2", table), maxBid.BidWithID.BID, resourceID)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = CASE WHEN bid = $1 THEN 'accepted' ELSE 'rejected' END, computing = CASE WHEN bid = $1 THEN true END, revision = CASE WHEN bid = $1 THEN %s END WHERE rid = $2", table, latestRevisionQuery("$2")), maxBid.BidWithID.BID, resourceID)
 	if err != nil {
 		return models.BidWithUID{}, errors.New("failed to update bids status")
 	}
 
 	// Update the resource's computing flag to true
 	resourceTable := getDBSchemaTable("resources")
-	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET computing = true WHERE rid = $1", resourceTable), resourceID)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET computing = true WHERE rid = $1", resourceTable), resourceID)
 	if err != nil {
 		return models.BidWithUID{}, errors.New("failed to update resource computing flag")
 	}
 
+	err = recordClearingPrice(tx, maxBid.BidWithID)
+	if err != nil {
+		return models.BidWithUID{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.BidWithUID{}, errors.New("failed to update bids status")
+	}
 	return maxBid, nil
 }
 
 func UpdateWinningBid(db *sql.DB, bid models.BidWithID) error {
+	// The lease only starts with its clearing price recorded
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to update bid status and computing flag")
+	}
+	defer tx.Rollback()
+
 	table := getDBSchemaTable("bids")
-	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET status = 'accepted', computing = true WHERE bid = $1", table), bid.BID)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'accepted', computing = true, revision = %s WHERE bid = $1", table, latestRevisionQuery("$2")), bid.BID, bid.Bid.RID)
 	if err != nil {
 		return errors.New("failed to update bid status and computing flag")
 	}
 	// Update the resource's computing flag to true
 	resourceTable := getDBSchemaTable("resources")
-	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET computing = true WHERE rid = $1", resourceTable), bid.Bid.RID)
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET computing = true WHERE rid = $1", resourceTable), bid.Bid.RID)
 	if err != nil {
 		return errors.New("failed to update resource computing flag")
 	}
+	if err = recordClearingPrice(tx, bid); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to update bid status and computing flag")
+	}
 	return nil
 }
 
@@ -669,33 +1272,145 @@ func UpdateRejectedBid(db *sql.DB, bid models.BidWithID) error {
 	return nil
 }
 
+// FinishCompute ends the lease of an accepted bid. The renter pays the lease into escrow, which is released to the
+// resource owner once the dispute window is over.
 func FinishCompute(db *sql.DB, resourceID string, bidUID string, bid models.BidWithID) error {
-	// Update the resource's computing flag to false
-	resourceTable := getDBSchemaTable("resources")
-	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET computing = false, available = false WHERE rid = $1", resourceTable), resourceID)
+	tx, err := db.Begin()
 	if err != nil {
-		return errors.New("failed to update resource computing flag")
+		return errors.New("failed to finish compute")
 	}
+	defer tx.Rollback()
 
-	// Update the bid's computing flag to false
+	// Update the bid's computing flag to false, bids settled as a no-show are not charged again
 	bidTable := getDBSchemaTable("bids")
-	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET computing = false WHERE bid = $1", bidTable), bid.BID)
+	result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET computing = false WHERE bid = $1 AND status = 'accepted'", bidTable), bid.BID)
 	if err != nil {
 		return errors.New("failed to update bid computing flag")
 	}
+	if settled, _ := result.RowsAffected(); settled > 0 {
+		// Update the resource's computing flag to false, a lease already settled freed its resource which may be
+		// running a newer lease by now
+		resourceTable := getDBSchemaTable("resources")
+		var ownerUID string
+		err = tx.QueryRow(fmt.Sprintf("UPDATE %s SET computing = false, %s, %s WHERE rid = $1 RETURNING uid", resourceTable, releasedAvailability(), drainedStatus), resourceID).Scan(&ownerUID)
+		if err != nil {
+			return errors.New("failed to update resource computing flag")
+		}
 
-	// Update the resource owner's credits and decrease the credits from the bidding user in one query
-	walletTable := getDBSchemaTable("wallets")
-	_, err = db.Exec(fmt.Sprintf(`
-		UPDATE %s
-		SET credits = CASE 
-			WHEN uid = $1 THEN credits - $2
-			WHEN uid = $3 THEN credits + $2
-		END
-		WHERE uid IN ($1, $3)`, walletTable), bidUID, bid.Bid.Amount*float64(bid.Duration), bid.Bid.RID)
+		amount := bid.Bid.Amount * float64(bid.Duration)
+		walletTable := getDBSchemaTable("wallets")
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET credits = credits - $2 WHERE uid = $1", walletTable), bidUID, amount)
+		if err != nil {
+			return errors.New("failed to charge bidding user")
+		}
+
+		// A lease disputed while it ran stays in escrow until the dispute is resolved
+		escrowTable := getDBSchemaTable("escrows")
+		disputeTable := getDBSchemaTable("disputes")
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s (bid, renter, supplier, amount, status, releaseAt)
+			VALUES ($1, $2, $3, $4, CASE WHEN EXISTS (SELECT 1 FROM %s WHERE bid = $1 AND status = 'open') THEN 'disputed' ELSE 'held' END, $5)`,
+			escrowTable, disputeTable), bid.BID, bidUID, ownerUID, amount, time.Now().Add(settlement.DisputeWindow()))
+		if err != nil {
+			return errors.New("failed to hold lease payment in escrow")
+		}
+		if err = postLedgerEntry(tx, bidUID, bid.BID, -amount, ledgerLeaseEscrow); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to finish compute")
+	}
+	return nil
+}
+
+// SettleNoShow closes an accepted bid whose peer never connected. The renter is charged
+// the settlement's fee (capped by the renter's credits) in favour of the resource owner and
+// the owner loses the settlement's reputation penalty. A bid can only be settled once.
+func SettleNoShow(db *sql.DB, bid string, s settlement.Settlement) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return errors.New("failed to update credits for resource owner and bidding user")
+		return errors.New("failed to settle no-show")
 	}
+	defer tx.Rollback()
 
+	var renterUID, supplierUID, rid string
+	bidTable := getDBSchemaTable("bids")
+	resourceTable := getDBSchemaTable("resources")
+	err = tx.QueryRow(fmt.Sprintf(`
+		UPDATE %s b SET status = $2, computing = false
+		FROM %s r
+		WHERE b.bid = $1 AND b.status = 'accepted' AND r.rid = b.rid
+		RETURNING b.uid, r.uid, r.rid`, bidTable, resourceTable), bid, string(s.Outcome)).Scan(&renterUID, &supplierUID, &rid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("bid already settled")
+		}
+		return errors.New("failed to settle no-show")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET computing = false, %s WHERE rid = $1", resourceTable, drainedStatus), rid)
+	if err != nil {
+		return errors.New("failed to update resource computing flag")
+	}
+
+	charged := 0.0
+	if s.RenterCharge > 0 {
+		walletTable := getDBSchemaTable("wallets")
+		var renterCredits float64
+		err = tx.QueryRow(fmt.Sprintf("SELECT credits FROM %s WHERE uid = $1 FOR UPDATE", walletTable), renterUID).Scan(&renterCredits)
+		if err != nil {
+			return errors.New("failed to charge no-show fee")
+		}
+		charged = s.RenterCharge
+		if renterCredits < charged {
+			charged = renterCredits
+		}
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE %s
+			SET credits = CASE
+				WHEN uid = $1 THEN credits - $2
+				WHEN uid = $3 THEN credits + $2
+			END
+			WHERE uid IN ($1, $3)`, walletTable), renterUID, charged, supplierUID)
+		if err != nil {
+			return errors.New("failed to pay no-show fee")
+		}
+		if err = postLedgerEntry(tx, renterUID, bid, -charged, ledgerNoShowFee); err != nil {
+			return err
+		}
+		if err = postLedgerEntry(tx, supplierUID, bid, charged, ledgerNoShowFee); err != nil {
+			return err
+		}
+	}
+
+	if s.SupplierPenalty > 0 {
+		reputationTable := getDBSchemaTable("reputations")
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s AS rep (uid, penalty) VALUES ($1, $2)
+			ON CONFLICT (uid) DO UPDATE SET penalty = rep.penalty + $2`, reputationTable), supplierUID, s.SupplierPenalty)
+		if err != nil {
+			return errors.New("failed to penalise supplier reputation")
+		}
+	}
+
+	// Record the no-show against the party that failed to connect
+	noShowUID := renterUID
+	if s.Outcome == settlement.SupplierNoShow || s.Outcome == settlement.SupplierOffline {
+		noShowUID = supplierUID
+	}
+	eventTable := getDBSchemaTable("lease_events")
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (bid, uid, event, amount) VALUES ($1, $2, $3, $4)", eventTable), bid, noShowUID, string(s.Outcome), charged)
+	if err != nil {
+		return errors.New("failed to record lease event")
+	}
+	if err = refreshReputation(tx, noShowUID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to settle no-show")
+	}
 	return nil
 }
@@ -0,0 +1,332 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/reputation"
+	"github.com/gunrgnhsr/Cycloud/pkg/settlement"
+)
+
+// InsertSignalingLog records an event of the signaling session of a lease
+func InsertSignalingLog(db *sql.DB, bid string, uid string, event string) error {
+	table := getDBSchemaTable("signaling_logs")
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (bid, uid, event) VALUES ($1, $2, $3)", table), bid, uid, event)
+	if err != nil {
+		return errors.New("failed to record signaling log")
+	}
+	return nil
+}
+
+// OpenDispute opens a dispute of a party against the other party of a lease, either while the lease runs or while
+// its payment is in escrow. It returns the id of the dispute.
+func OpenDispute(db *sql.DB, bid string, opener string, reason string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to open dispute")
+	}
+	defer tx.Rollback()
+
+	var renterUID, supplierUID, status string
+	var computing bool
+	bidTable := getDBSchemaTable("bids")
+	resourceTable := getDBSchemaTable("resources")
+	err = tx.QueryRow(fmt.Sprintf(`
+		SELECT b.uid, r.uid, b.status, b.computing
+		FROM %s b JOIN %s r ON r.rid = b.rid
+		WHERE b.bid = $1
+		FOR UPDATE OF b`, bidTable, resourceTable), bid).Scan(&renterUID, &supplierUID, &status, &computing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("bid not found")
+		}
+		return "", errors.New("failed to open dispute")
+	}
+
+	// Lock the escrow so it cannot be released to the supplier while the dispute is opened
+	var escrowStatus sql.NullString
+	var releaseAt *time.Time
+	escrowTable := getDBSchemaTable("escrows")
+	err = tx.QueryRow(fmt.Sprintf("SELECT status, releaseAt FROM %s WHERE bid = $1 FOR UPDATE", escrowTable), bid).Scan(&escrowStatus, &releaseAt)
+	if err != nil && err != sql.ErrNoRows {
+		return "", errors.New("failed to open dispute")
+	}
+
+	against := supplierUID
+	if opener == supplierUID {
+		against = renterUID
+	} else if opener != renterUID {
+		return "", errors.New("user is not a party of the lease")
+	}
+
+	// No-shows are settled by the no-show policy, only leases that ran can be disputed
+	if status != "accepted" || (!computing && !escrowStatus.Valid) {
+		return "", errors.New("lease cannot be disputed")
+	}
+	if escrowStatus.Valid && (escrowStatus.String != "held" || !releaseAt.After(time.Now())) {
+		return "", errors.New("dispute window is over")
+	}
+
+	var did string
+	table := getDBSchemaTable("disputes")
+	err = tx.QueryRow(fmt.Sprintf(`
+		INSERT INTO %s (bid, opener, against, reason) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (bid) DO NOTHING
+		RETURNING did`, table), bid, opener, against, reason).Scan(&did)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("lease already disputed")
+		}
+		return "", errors.New("failed to open dispute")
+	}
+
+	// A running lease has no escrow yet, it is created disputed when the lease ends
+	if escrowStatus.Valid {
+		result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'disputed' WHERE bid = $1 AND status = 'held'", escrowTable), bid)
+		if err != nil {
+			return "", errors.New("failed to hold escrow")
+		}
+		if held, _ := result.RowsAffected(); held == 0 {
+			return "", errors.New("dispute window is over")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to open dispute")
+	}
+	return did, nil
+}
+
+// ResolveDispute refunds part of the escrow of a disputed lease to its renter and releases the rest to its supplier.
+// An upheld dispute counts against the reputation of the party it was opened against.
+func ResolveDispute(db *sql.DB, did string, refund float64, upheld bool, resolution string, audit models.AuditEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to resolve dispute")
+	}
+	defer tx.Rollback()
+
+	var bid, against, status string
+	table := getDBSchemaTable("disputes")
+	err = tx.QueryRow(fmt.Sprintf("SELECT bid, against, status FROM %s WHERE did = $1 FOR UPDATE", table), did).Scan(&bid, &against, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("dispute not found")
+		}
+		return errors.New("failed to resolve dispute")
+	}
+	if status != models.DisputeOpen {
+		return errors.New("dispute already resolved")
+	}
+
+	// Only an escrow held for the dispute is settled, one already paid out must not be paid again
+	var escrow float64
+	var renterUID, supplierUID, escrowStatus string
+	escrowTable := getDBSchemaTable("escrows")
+	err = tx.QueryRow(fmt.Sprintf("SELECT amount, renter, supplier, status FROM %s WHERE bid = $1 FOR UPDATE", escrowTable), bid).Scan(&escrow, &renterUID, &supplierUID, &escrowStatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("lease is still running")
+		}
+		return errors.New("failed to resolve dispute")
+	}
+	if escrowStatus != "disputed" {
+		return errors.New("escrow is not held for the dispute")
+	}
+
+	refund, payout, err := settlement.SplitEscrow(escrow, refund)
+	if err != nil {
+		return err
+	}
+
+	walletTable := getDBSchemaTable("wallets")
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s
+		SET credits = CASE
+			WHEN uid = $1 THEN credits + $2
+			WHEN uid = $3 THEN credits + $4
+		END
+		WHERE uid IN ($1, $3)`, walletTable), renterUID, refund, supplierUID, payout)
+	if err != nil {
+		return errors.New("failed to settle escrow")
+	}
+	if refund > 0 {
+		if err = postLedgerEntry(tx, renterUID, bid, refund, ledgerDisputeRefund); err != nil {
+			return err
+		}
+	}
+	if payout > 0 {
+		if err = postLedgerEntry(tx, supplierUID, bid, payout, ledgerLeasePayout); err != nil {
+			return err
+		}
+	}
+
+	settledStatus := "released"
+	if refund > 0 {
+		settledStatus = "refunded"
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = $2, settledAt = CURRENT_TIMESTAMP WHERE bid = $1 AND status = 'disputed'", escrowTable), bid, settledStatus)
+	if err != nil {
+		return errors.New("failed to settle escrow")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s SET status = $2, refund = $3, upheld = $4, resolution = $5, resolvedAt = CURRENT_TIMESTAMP
+		WHERE did = $1`, table), did, models.DisputeResolved, refund, upheld, resolution)
+	if err != nil {
+		return errors.New("failed to resolve dispute")
+	}
+
+	if upheld {
+		if err = recordLeaseEvent(tx, bid, against, reputation.Dispute); err != nil {
+			return err
+		}
+	}
+
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to resolve dispute")
+	}
+	return nil
+}
+
+// ReleaseDueEscrows pays the suppliers of undisputed leases whose dispute window is over and returns the number of
+// released escrows
+func ReleaseDueEscrows(db *sql.DB, now time.Time) (int, error) {
+	escrowTable := getDBSchemaTable("escrows")
+	ledgerTable := getDBSchemaTable("ledger")
+	walletTable := getDBSchemaTable("wallets")
+	var released int
+	err := db.QueryRow(fmt.Sprintf(`
+		WITH released AS (
+			UPDATE %s SET status = 'released', settledAt = CURRENT_TIMESTAMP
+			WHERE status = 'held' AND releaseAt <= $1
+			RETURNING bid, supplier, amount
+		), posted AS (
+			INSERT INTO %s (uid, bid, amount, reason) SELECT supplier, bid, amount, $2 FROM released
+		), paid AS (
+			UPDATE %s w SET credits = w.credits + p.total
+			FROM (SELECT supplier, SUM(amount) AS total FROM released GROUP BY supplier) p
+			WHERE w.uid = p.supplier
+		)
+		SELECT COUNT(*) FROM released`, escrowTable, ledgerTable, walletTable), now, ledgerLeasePayout).Scan(&released)
+	if err != nil {
+		return 0, errors.New("failed to release escrows")
+	}
+	return released, nil
+}
+
+// disputeQuery selects the disputes read by scanDispute
+func disputeQuery(condition string) string {
+	return fmt.Sprintf(`
+		SELECT d.did, d.bid, d.opener, d.against, d.reason, d.status, COALESCE(e.amount, 0), d.refund, d.upheld, d.resolution, d.createdAt, d.resolvedAt
+		FROM %s d LEFT JOIN %s e ON e.bid = d.bid
+		WHERE %s
+		ORDER BY d.did DESC`, getDBSchemaTable("disputes"), getDBSchemaTable("escrows"), condition)
+}
+
+func scanDispute(row rowScanner) (models.Dispute, error) {
+	var dispute models.Dispute
+	err := row.Scan(&dispute.DID, &dispute.BID, &dispute.Opener, &dispute.Against, &dispute.Reason, &dispute.Status, &dispute.Escrow, &dispute.Refund, &dispute.Upheld, &dispute.Resolution, &dispute.CreatedAt, &dispute.ResolvedAt)
+	return dispute, err
+}
+
+func queryDisputes(db *sql.DB, condition string, args ...interface{}) ([]models.Dispute, error) {
+	rows, err := db.Query(disputeQuery(condition), args...)
+	if err != nil {
+		return nil, errors.New("failed to fetch disputes")
+	}
+	defer rows.Close()
+
+	disputes := []models.Dispute{}
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, errors.New("failed to fetch disputes")
+		}
+		disputes = append(disputes, dispute)
+	}
+	return disputes, nil
+}
+
+// GetUserDisputes returns the disputes opened by or against a user, newest first
+func GetUserDisputes(db *sql.DB, uid string) ([]models.Dispute, error) {
+	return queryDisputes(db, "d.opener = $1 OR d.against = $1", uid)
+}
+
+// GetOpenDisputes returns the disputes waiting for an admin, newest first
+func GetOpenDisputes(db *sql.DB) ([]models.Dispute, error) {
+	return queryDisputes(db, "d.status = $1", models.DisputeOpen)
+}
+
+// GetDispute returns a dispute along with the evidence recorded of its lease
+func GetDispute(db *sql.DB, did string) (models.Dispute, error) {
+	dispute, err := scanDispute(db.QueryRow(disputeQuery("d.did = $1"), did))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dispute, errors.New("dispute not found")
+		}
+		return dispute, errors.New("failed to fetch dispute")
+	}
+
+	evidence, err := getLeaseEvidence(db, dispute.BID)
+	if err != nil {
+		return dispute, err
+	}
+	dispute.Evidence = &evidence
+	return dispute, nil
+}
+
+// getLeaseEvidence collects the lease events, signaling logs and agent heartbeats recorded for a lease
+func getLeaseEvidence(db *sql.DB, bid string) (models.DisputeEvidence, error) {
+	evidence := models.DisputeEvidence{Heartbeats: []time.Time{}}
+	var err error
+	evidence.Events, err = queryLeaseLog(db, getDBSchemaTable("lease_events"), bid)
+	if err != nil {
+		return evidence, err
+	}
+	evidence.Signaling, err = queryLeaseLog(db, getDBSchemaTable("signaling_logs"), bid)
+	if err != nil {
+		return evidence, err
+	}
+
+	table := getDBSchemaTable("lease_heartbeats")
+	rows, err := db.Query(fmt.Sprintf("SELECT receivedAt FROM %s WHERE bid = $1 ORDER BY receivedAt", table), bid)
+	if err != nil {
+		return evidence, errors.New("failed to fetch lease heartbeats")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var receivedAt time.Time
+		if err := rows.Scan(&receivedAt); err != nil {
+			return evidence, errors.New("failed to fetch lease heartbeats")
+		}
+		evidence.Heartbeats = append(evidence.Heartbeats, receivedAt)
+	}
+	return evidence, nil
+}
+
+// queryLeaseLog returns the entries of a lease log table for a lease, oldest first
+func queryLeaseLog(db *sql.DB, table string, bid string) ([]models.LeaseLogEntry, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT uid, event, createdAt FROM %s WHERE bid = $1 ORDER BY createdAt", table), bid)
+	if err != nil {
+		return nil, errors.New("failed to fetch lease log")
+	}
+	defer rows.Close()
+
+	entries := []models.LeaseLogEntry{}
+	for rows.Next() {
+		var entry models.LeaseLogEntry
+		if err := rows.Scan(&entry.UID, &entry.Event, &entry.CreatedAt); err != nil {
+			return nil, errors.New("failed to fetch lease log")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// SetAgentKey replaces the public key the hardware reports of a resource are signed with
+func SetAgentKey(db *sql.DB, rid string, agentKey string) error {
+	table := getDBSchemaTable("resources")
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET agentKey = $2 WHERE rid = $1", table), rid, agentKey)
+	if err != nil {
+		return errors.New("failed to set agent key")
+	}
+	return nil
+}
+
+func GetAgentKey(db *sql.DB, rid string) (string, error) {
+	var agentKey sql.NullString
+	table := getDBSchemaTable("resources")
+	err := db.QueryRow(fmt.Sprintf("SELECT agentKey FROM %s WHERE rid = $1", table), rid).Scan(&agentKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("resource not found")
+		}
+		return "", errors.New("failed to fetch agent key")
+	}
+	if !agentKey.Valid {
+		return "", errors.New("resource has no registered agent")
+	}
+	return agentKey.String, nil
+}
+
+// InsertHardwareReport stores a verified hardware report and sets the verification status of its resource
+func InsertHardwareReport(db *sql.DB, rid string, report []byte, signature string, divergences []models.HardwareDivergence) (string, error) {
+	encodedDivergences, err := json.Marshal(divergences)
+	if err != nil {
+		return "", errors.New("failed to store hardware report")
+	}
+	verification := models.VerificationVerified
+	if len(divergences) > 0 {
+		verification = models.VerificationDiverged
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to store hardware report")
+	}
+	defer tx.Rollback()
+
+	var hid string
+	table := getDBSchemaTable("hardware_reports")
+	err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (rid, report, signature, divergences) VALUES ($1, $2, $3, $4) RETURNING hid", table),
+		rid, string(report), signature, string(encodedDivergences)).Scan(&hid)
+	if err != nil {
+		return "", errors.New("failed to store hardware report")
+	}
+
+	resourceTable := getDBSchemaTable("resources")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET verification = $2 WHERE rid = $1", resourceTable), rid, verification)
+	if err != nil {
+		return "", errors.New("failed to update resource verification")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to store hardware report")
+	}
+	return hid, nil
+}
+
+func GetLatestHardwareReport(db *sql.DB, rid string) (models.HardwareVerification, error) {
+	var verification models.HardwareVerification
+	var report string
+	var divergences []byte
+	table := getDBSchemaTable("hardware_reports")
+	err := db.QueryRow(fmt.Sprintf("SELECT hid, report, divergences, createdAt FROM %s WHERE rid = $1 ORDER BY hid DESC LIMIT 1", table), rid).Scan(&verification.HID, &report, &divergences, &verification.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return verification, errors.New("no hardware report for resource")
+		}
+		return verification, errors.New("failed to fetch hardware report")
+	}
+	if err = json.Unmarshal([]byte(report), &verification.Report); err != nil {
+		return verification, errors.New("failed to fetch hardware report")
+	}
+	if err = json.Unmarshal(divergences, &verification.Divergences); err != nil {
+		return verification, errors.New("failed to fetch hardware report")
+	}
+	return verification, nil
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+)
+
+// jwtKeysLockID names the advisory lock serializing the rotations of the servers
+const jwtKeysLockID = 0x6a77746b
+
+// JWTKeyStore keeps the JWT signing keys in the database so every server signs and verifies with the same keys
+type JWTKeyStore struct {
+	db *sql.DB
+}
+
+func NewJWTKeyStore(db *sql.DB) *JWTKeyStore {
+	return &JWTKeyStore{db: db}
+}
+
+func (s *JWTKeyStore) LoadKeys() ([]auth.StoredKey, error) {
+	table := getDBSchemaTable("jwt_keys")
+	rows, err := s.db.Query(fmt.Sprintf("SELECT kid, algorithm, material, createdAt, retiredAt FROM %s ORDER BY createdAt", table))
+	if err != nil {
+		return nil, errors.New("failed to get jwt keys")
+	}
+	defer rows.Close()
+
+	keys := []auth.StoredKey{}
+	for rows.Next() {
+		var key auth.StoredKey
+		var retiredAt sql.NullTime
+		err = rows.Scan(&key.KID, &key.Algorithm, &key.Material, &key.CreatedAt, &retiredAt)
+		if err != nil {
+			return nil, errors.New("failed to get jwt keys")
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		keys = append(keys, key)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to get jwt keys")
+	}
+	return keys, nil
+}
+
+func (s *JWTKeyStore) SaveKey(key auth.StoredKey) error {
+	table := getDBSchemaTable("jwt_keys")
+	_, err := s.db.Exec(fmt.Sprintf(`INSERT INTO %s (kid, algorithm, material, createdAt, retiredAt) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (kid) DO UPDATE SET retiredAt = EXCLUDED.retiredAt`, table), key.KID, key.Algorithm, key.Material, key.CreatedAt, key.RetiredAt)
+	if err != nil {
+		return errors.New("failed to save jwt key")
+	}
+	return nil
+}
+
+func (s *JWTKeyStore) DeleteKey(kid string) error {
+	table := getDBSchemaTable("jwt_keys")
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE kid = $1", table), kid)
+	if err != nil {
+		return errors.New("failed to delete jwt key")
+	}
+	return nil
+}
+
+// LockKeys holds a transaction level advisory lock until released, so only one server rotates at a time
+func (s *JWTKeyStore) LockKeys() (func(), error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, errors.New("failed to lock jwt keys")
+	}
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", jwtKeysLockID); err != nil {
+		tx.Rollback()
+		return nil, errors.New("failed to lock jwt keys")
+	}
+	return func() {
+		tx.Rollback()
+	}, nil
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// Reasons of ledger entries
+const (
+	ledgerLeaseEscrow   = "lease_escrow"   // the renter paid a finished lease into escrow
+	ledgerLeasePayout   = "lease_payout"   // the escrow of a lease was released to its supplier
+	ledgerDisputeRefund = "dispute_refund" // the escrow of a disputed lease was refunded to its renter
+	ledgerNoShowFee     = "no_show_fee"
+	ledgerAdjustment    = "admin_adjustment" // an admin credited or debited the wallet, the note says why
+)
+
+// postLedgerEntry records a movement of the credits of uid, bid is empty for movements unrelated to a lease
+func postLedgerEntry(tx execer, uid string, bid string, amount float64, reason string) error {
+	var bidValue interface{}
+	if bid != "" {
+		bidValue = bid
+	}
+	table := getDBSchemaTable("ledger")
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (uid, bid, amount, reason) VALUES ($1, $2, $3, $4)", table), uid, bidValue, amount, reason)
+	if err != nil {
+		return errors.New("failed to post ledger entry")
+	}
+	return nil
+}
+
+// GetUserLedger returns the credit movements of a user, newest first
+func GetUserLedger(db *sql.DB, uid string) ([]models.LedgerEntry, error) {
+	table := getDBSchemaTable("ledger")
+	rows, err := db.Query(fmt.Sprintf("SELECT lid, bid, amount, reason, note, createdAt FROM %s WHERE uid = $1 ORDER BY lid DESC", table), uid)
+	if err != nil {
+		return nil, errors.New("failed to fetch ledger")
+	}
+	defer rows.Close()
+
+	entries := []models.LedgerEntry{}
+	for rows.Next() {
+		var entry models.LedgerEntry
+		if err := rows.Scan(&entry.LID, &entry.BID, &entry.Amount, &entry.Reason, &entry.Note, &entry.CreatedAt); err != nil {
+			return nil, errors.New("failed to fetch ledger")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// LoginAttemptStore keeps the failed logins in the database so every server throttles with the same counts
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptStore(db *sql.DB) *LoginAttemptStore {
+	return &LoginAttemptStore{db: db}
+}
+
+func (s *LoginAttemptStore) GetAttempts(key string) (auth.LoginAttempts, error) {
+	var attempts auth.LoginAttempts
+	table := getDBSchemaTable("login_attempts")
+	err := s.db.QueryRow(fmt.Sprintf("SELECT failures, lastFailure FROM %s WHERE attemptKey = $1", table), key).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return auth.LoginAttempts{}, nil
+		}
+		return auth.LoginAttempts{}, errors.New("failed to get login attempts")
+	}
+	return attempts, nil
+}
+
+func (s *LoginAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (auth.LoginAttempts, error) {
+	var attempts auth.LoginAttempts
+	table := getDBSchemaTable("login_attempts")
+	err := s.db.QueryRow(fmt.Sprintf(`INSERT INTO %s AS a (attemptKey, failures, lastFailure) VALUES ($1, 1, $2)
+		ON CONFLICT (attemptKey) DO UPDATE SET
+			failures = CASE WHEN a.lastFailure < $3 THEN 1 ELSE a.failures + 1 END,
+			lastFailure = EXCLUDED.lastFailure
+		RETURNING failures, lastFailure`, table), key, now, now.Add(-window)).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		return auth.LoginAttempts{}, errors.New("failed to record login attempt")
+	}
+	return attempts, nil
+}
+
+func (s *LoginAttemptStore) ForgetFailure(key string) error {
+	table := getDBSchemaTable("login_attempts")
+	_, err := s.db.Exec(fmt.Sprintf("UPDATE %s SET failures = failures - 1 WHERE attemptKey = $1 AND failures > 0", table), key)
+	if err != nil {
+		return errors.New("failed to forget login attempt")
+	}
+	return nil
+}
+
+func (s *LoginAttemptStore) DeleteAttempts(key string) error {
+	table := getDBSchemaTable("login_attempts")
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE attemptKey = $1", table), key)
+	if err != nil {
+		return errors.New("failed to delete login attempts")
+	}
+	return nil
+}
+
+func (s *LoginAttemptStore) PruneAttempts(before time.Time) error {
+	table := getDBSchemaTable("login_attempts")
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE lastFailure < $1", table), before)
+	if err != nil {
+		return errors.New("failed to prune login attempts")
+	}
+	return nil
+}
+
+// InsertLoginEvent records a failed or throttled login, uid is empty when the username does not exist
+func InsertLoginEvent(db *sql.DB, uid string, username string, ip string, event string) error {
+	var uidValue interface{}
+	if uid != "" {
+		uidValue = uid
+	}
+
+	table := getDBSchemaTable("login_events")
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (uid, username, ip, event) VALUES ($1, $2, $3, $4)", table), uidValue, username, ip, event)
+	if err != nil {
+		return errors.New("failed to record login event")
+	}
+	return nil
+}
+
+// GetLoginEvents returns the latest login events, optionally only the ones of an address
+func GetLoginEvents(db *sql.DB, ip string, limit int) ([]models.LoginEvent, error) {
+	if limit <= 0 || limit > maxAuditLogLimit {
+		limit = defaultAuditLogLimit
+	}
+
+	table := getDBSchemaTable("login_events")
+	rows, err := db.Query(fmt.Sprintf(`SELECT eid, uid, username, ip, event, createdAt FROM %s
+		WHERE $1 = '' OR ip = $1 ORDER BY eid DESC LIMIT $2`, table), ip, limit)
+	if err != nil {
+		return nil, errors.New("failed to get login events")
+	}
+	defer rows.Close()
+
+	events := []models.LoginEvent{}
+	for rows.Next() {
+		var event models.LoginEvent
+		var uid sql.NullString
+		if err = rows.Scan(&event.EID, &uid, &event.Username, &event.IP, &event.Event, &event.CreatedAt); err != nil {
+			return nil, errors.New("failed to get login events")
+		}
+		event.UID = uid.String
+		events = append(events, event)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to get login events")
+	}
+	return events, nil
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// drainedStatus is the assignment moving a draining resource to maintenance as its lease ends
+const drainedStatus = "status = CASE WHEN status = 'draining' THEN 'maintenance' ELSE status END"
+
+// overlapsMaintenance reports whether a lease of duration hours starting at start runs into a maintenance window
+func overlapsMaintenance(window *models.MaintenanceWindow, start time.Time, duration int) bool {
+	if window == nil {
+		return false
+	}
+	end := start.Add(time.Duration(duration) * time.Hour)
+	return start.Before(window.End) && end.After(window.Start)
+}
+
+// drainResource stops a resource from taking new leases. A computing resource drains until its lease ends, an idle
+// one goes to maintenance right away. It returns the new status and whether the resource was listed.
+func drainResource(tx execer, rid string) (string, bool, error) {
+	var status string
+	var available, computing bool
+	table := getDBSchemaTable("resources")
+	err := tx.QueryRow(fmt.Sprintf("SELECT status, available, computing FROM %s WHERE rid = $1 FOR UPDATE", table), rid).Scan(&status, &available, &computing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, errors.New("resource not found")
+		}
+		return "", false, errors.New("failed to drain resource")
+	}
+	if status == models.ResourceArchived {
+		return "", false, errors.New("resource is archived")
+	}
+	if status != models.ResourceActive {
+		return status, false, nil
+	}
+
+	status = models.ResourceMaintenance
+	if computing {
+		status = models.ResourceDraining
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = $2, available = false WHERE rid = $1", table), rid, status)
+	if err != nil {
+		return "", false, errors.New("failed to drain resource")
+	}
+
+	// Bids still waiting for the resource will never be served
+	bidTable := getDBSchemaTable("bids")
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET status = 'rejected' WHERE rid = $1 AND status = 'pending'", bidTable), rid)
+	if err != nil {
+		return "", false, errors.New("failed to drain resource")
+	}
+	return status, available && !computing, nil
+}
+
+func DrainResource(db *sql.DB, rid string) (string, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", false, errors.New("failed to drain resource")
+	}
+	defer tx.Rollback()
+
+	status, unlisted, err := drainResource(tx, rid)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", false, errors.New("failed to drain resource")
+	}
+	return status, unlisted, nil
+}
+
+// EndMaintenance returns a draining or maintained resource to the market, unlisted, and clears its maintenance window
+// once the window has started
+func EndMaintenance(db *sql.DB, rid string) error {
+	table := getDBSchemaTable("resources")
+	result, err := db.Exec(fmt.Sprintf(`
+		UPDATE %s SET status = 'active',
+			maintenanceStart = CASE WHEN maintenanceStart <= CURRENT_TIMESTAMP THEN NULL ELSE maintenanceStart END,
+			maintenanceEnd = CASE WHEN maintenanceStart <= CURRENT_TIMESTAMP THEN NULL ELSE maintenanceEnd END,
+			maintenanceReason = CASE WHEN maintenanceStart <= CURRENT_TIMESTAMP THEN '' ELSE maintenanceReason END
+		WHERE rid = $1 AND status IN ('draining', 'maintenance')`, table), rid)
+	if err != nil {
+		return errors.New("failed to end maintenance")
+	}
+	if ended, err := result.RowsAffected(); err != nil || ended == 0 {
+		return errors.New("resource is not under maintenance")
+	}
+	return nil
+}
+
+// ScheduleMaintenance sets the maintenance window of a resource, renters see it in listings until it ends
+func ScheduleMaintenance(db *sql.DB, rid string, window models.MaintenanceWindow) error {
+	table := getDBSchemaTable("resources")
+	result, err := db.Exec(fmt.Sprintf(`
+		UPDATE %s SET maintenanceStart = $2, maintenanceEnd = $3, maintenanceReason = $4
+		WHERE rid = $1 AND status != 'archived'`, table), rid, window.Start, window.End, window.Reason)
+	if err != nil {
+		return errors.New("failed to schedule maintenance")
+	}
+	if scheduled, err := result.RowsAffected(); err != nil || scheduled == 0 {
+		return errors.New("resource is archived")
+	}
+	return nil
+}
+
+func CancelScheduledMaintenance(db *sql.DB, rid string) error {
+	table := getDBSchemaTable("resources")
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET maintenanceStart = NULL, maintenanceEnd = NULL, maintenanceReason = '' WHERE rid = $1", table), rid)
+	if err != nil {
+		return errors.New("failed to cancel maintenance")
+	}
+	return nil
+}
+
+// StartDueMaintenance drains the active resources whose maintenance window has started and returns the ones that
+// were listed
+func StartDueMaintenance(db *sql.DB, now time.Time) ([]string, error) {
+	table := getDBSchemaTable("resources")
+	rows, err := db.Query(fmt.Sprintf("SELECT rid FROM %s WHERE status = 'active' AND maintenanceStart <= $1 AND maintenanceEnd > $1", table), now)
+	if err != nil {
+		return nil, errors.New("failed to fetch due maintenance")
+	}
+	rids := []string{}
+	for rows.Next() {
+		var rid string
+		if err := rows.Scan(&rid); err != nil {
+			rows.Close()
+			return nil, errors.New("failed to fetch due maintenance")
+		}
+		rids = append(rids, rid)
+	}
+	rows.Close()
+
+	unlisted := []string{}
+	for _, rid := range rids {
+		_, wasListed, err := DrainResource(db, rid)
+		if err != nil {
+			return unlisted, err
+		}
+		if wasListed {
+			unlisted = append(unlisted, rid)
+		}
+	}
+	return unlisted, nil
+}
+
+// EndDueMaintenance returns the maintained resources whose window is over to the market, unlisted, and returns how
+// many there were
+func EndDueMaintenance(db *sql.DB, now time.Time) (int, error) {
+	table := getDBSchemaTable("resources")
+	result, err := db.Exec(fmt.Sprintf(`
+		UPDATE %s SET status = 'active', maintenanceStart = NULL, maintenanceEnd = NULL, maintenanceReason = ''
+		WHERE status = 'maintenance' AND maintenanceEnd <= $1`, table), now)
+	if err != nil {
+		return 0, errors.New("failed to end due maintenance")
+	}
+	ended, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.New("failed to end due maintenance")
+	}
+	// Windows that passed while the resource never drained are dropped
+	_, err = db.Exec(fmt.Sprintf(`
+		UPDATE %s SET maintenanceStart = NULL, maintenanceEnd = NULL, maintenanceReason = ''
+		WHERE status = 'active' AND maintenanceEnd <= $1`, table), now)
+	if err != nil {
+		return 0, errors.New("failed to end due maintenance")
+	}
+	return int(ended), nil
+}
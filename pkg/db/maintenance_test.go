@@ -0,0 +1,32 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestOverlapsMaintenance(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	window := &models.MaintenanceWindow{Start: now.Add(time.Hour), End: now.Add(3 * time.Hour)}
+
+	cases := []struct {
+		name     string
+		window   *models.MaintenanceWindow
+		start    time.Time
+		duration int
+		want     bool
+	}{
+		{"no window", nil, now, 10, false},
+		{"ends before the window", window, now, 1, false},
+		{"runs into the window", window, now, 10, true},
+		{"starts in the window", window, now.Add(2 * time.Hour), 1, true},
+		{"starts after the window", window, now.Add(3 * time.Hour), 1, false},
+	}
+	for _, c := range cases {
+		if got := overlapsMaintenance(c.window, c.start, c.duration); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
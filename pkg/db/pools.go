@@ -0,0 +1,370 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/acceptance"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/pricing"
+	"github.com/gunrgnhsr/Cycloud/pkg/workload"
+	"github.com/lib/pq"
+)
+
+// GetResourcePool returns the pool a resource belongs to, or an empty string
+func GetResourcePool(db *sql.DB, rid string) (string, error) {
+	var pid string
+	table := getDBSchemaTable("pool_members")
+	err := db.QueryRow(fmt.Sprintf("SELECT pid FROM %s WHERE rid = $1", table), rid).Scan(&pid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", errors.New("failed to fetch resource pool")
+	}
+	return pid, nil
+}
+
+func GetPoolOwner(db *sql.DB, pid string) (string, error) {
+	var uid string
+	table := getDBSchemaTable("pools")
+	err := db.QueryRow(fmt.Sprintf("SELECT uid FROM %s WHERE pid = $1", table), pid).Scan(&uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("pool not found")
+		}
+		return "", errors.New("failed to fetch pool")
+	}
+	return uid, nil
+}
+
+// addPoolMembers adds resources owned by uid that are not archived nor in another pool to a pool
+func addPoolMembers(tx execer, pid string, uid string, rids []string) error {
+	resourceTable := getDBSchemaTable("resources")
+	memberTable := getDBSchemaTable("pool_members")
+	result, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s (rid, pid)
+		SELECT rid, $1::integer FROM %s WHERE rid::text = ANY($2) AND uid = $3 AND status != 'archived'
+		ON CONFLICT (rid) DO NOTHING`, memberTable, resourceTable), pid, pq.Array(rids), uid)
+	if err != nil {
+		return errors.New("failed to add pool members")
+	}
+	if added, err := result.RowsAffected(); err != nil || int(added) != len(rids) {
+		return errors.New("resources must belong to the user, not be archived and not be part of another pool")
+	}
+	return nil
+}
+
+func InsertNewPool(db *sql.DB, uid string, name string, rids []string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to create pool")
+	}
+	defer tx.Rollback()
+
+	var pid string
+	table := getDBSchemaTable("pools")
+	err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (uid, name) VALUES ($1, $2) RETURNING pid", table), uid, name).Scan(&pid)
+	if err != nil {
+		return "", errors.New("failed to create pool")
+	}
+
+	err = addPoolMembers(tx, pid, uid, rids)
+	if err != nil {
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to create pool")
+	}
+	return pid, nil
+}
+
+func AddPoolMembers(db *sql.DB, pid string, uid string, rids []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to add pool members")
+	}
+	defer tx.Rollback()
+
+	err = addPoolMembers(tx, pid, uid, rids)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to add pool members")
+	}
+	return nil
+}
+
+func RemovePoolMember(db *sql.DB, pid string, rid string) error {
+	table := getDBSchemaTable("pool_members")
+	resourceTable := getDBSchemaTable("resources")
+	result, err := db.Exec(fmt.Sprintf(`
+		DELETE FROM %s m USING %s r
+		WHERE m.pid = $1 AND m.rid = $2 AND r.rid = m.rid AND r.computing = false`, table, resourceTable), pid, rid)
+	if err != nil {
+		return errors.New("failed to remove pool member")
+	}
+	if removed, err := result.RowsAffected(); err != nil || removed == 0 {
+		return errors.New("resource is not an idle member of the pool")
+	}
+	return nil
+}
+
+// DeletePool removes a pool whose nodes are all idle, its resources become regular resources again
+func DeletePool(db *sql.DB, pid string) error {
+	var computing bool
+	table := getDBSchemaTable("pools")
+	memberTable := getDBSchemaTable("pool_members")
+	resourceTable := getDBSchemaTable("resources")
+	err := db.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s m JOIN %s r ON r.rid = m.rid WHERE m.pid = $1 AND r.computing = true)", memberTable, resourceTable), pid).Scan(&computing)
+	if err != nil {
+		return errors.New("failed to delete pool")
+	}
+	if computing {
+		return errors.New("pool has nodes currently computing")
+	}
+
+	_, err = db.Exec(fmt.Sprintf("DELETE FROM %s WHERE pid = $1", memberTable), pid)
+	if err != nil {
+		return errors.New("failed to delete pool")
+	}
+	// Pools with lease history are kept for accounting, only their members are released
+	_, err = db.Exec(fmt.Sprintf("DELETE FROM %s WHERE pid = $1 AND NOT EXISTS (SELECT 1 FROM %s WHERE pid = $1)", table, getDBSchemaTable("pool_leases")), pid)
+	if err != nil {
+		return errors.New("failed to delete pool")
+	}
+	return nil
+}
+
+// poolsQuery selects pools with their members, the number of nodes available for leasing and the cheapest of them
+func poolsQuery(where string) string {
+	return fmt.Sprintf(`
+		SELECT p.pid, p.name, p.createdAt,
+			COALESCE(array_agg(m.rid::text ORDER BY m.rid) FILTER (WHERE m.rid IS NOT NULL), '{}'),
+			COUNT(r.rid) FILTER (WHERE r.available AND NOT r.computing AND r.status = 'active'),
+			COALESCE(MIN(r.cost_per_hour) FILTER (WHERE r.available AND NOT r.computing AND r.status = 'active'), 0)
+		FROM %s p
+		LEFT JOIN %s m ON m.pid = p.pid
+		LEFT JOIN %s r ON r.rid = m.rid
+		WHERE %s
+		GROUP BY p.pid
+		ORDER BY p.pid`, getDBSchemaTable("pools"), getDBSchemaTable("pool_members"), getDBSchemaTable("resources"), where)
+}
+
+func queryPools(db *sql.DB, query string, args ...interface{}) ([]models.Pool, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.New("failed to fetch pools")
+	}
+	defer rows.Close()
+
+	pools := []models.Pool{}
+	for rows.Next() {
+		var pool models.Pool
+		err := rows.Scan(&pool.PID, &pool.Name, &pool.CreatedAt, pq.Array(&pool.RIDs), &pool.AvailableNodes, &pool.MinCostPerHour)
+		if err != nil {
+			return nil, errors.New("failed to fetch pools")
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func GetUserPools(db *sql.DB, uid string) ([]models.Pool, error) {
+	return queryPools(db, poolsQuery("p.uid = $1"), uid)
+}
+
+// GetAvailablePools returns the pools of other users with at least one node available
+func GetAvailablePools(db *sql.DB, uid string) ([]models.Pool, error) {
+	pools, err := queryPools(db, poolsQuery("p.uid != $1"), uid)
+	if err != nil {
+		return nil, err
+	}
+	available := []models.Pool{}
+	for _, pool := range pools {
+		if pool.AvailableNodes > 0 {
+			available = append(available, pool)
+		}
+	}
+	return available, nil
+}
+
+// releasedAvailability is the assignment freeing the resource $1 at the end of its lease. Pool nodes go back to their
+// pool, other resources stay unlisted until their supplier lists them for a new auction.
+func releasedAvailability() string {
+	return fmt.Sprintf("available = (status = 'active' AND EXISTS (SELECT 1 FROM %s WHERE rid = $1))", getDBSchemaTable("pool_members"))
+}
+
+// AllocatePoolLease leases bid.Nodes available nodes of a pool to uid all-or-nothing: either every node gets an
+// accepted bid and is marked computing, or nothing changes
+func AllocatePoolLease(db *sql.DB, uid string, bid models.PoolBid) (models.PoolLease, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+	defer tx.Rollback()
+
+	// Lock the candidate nodes, nodes locked by a concurrent allocation are skipped
+	resourceTable := getDBSchemaTable("resources")
+	memberTable := getDBSchemaTable("pool_members")
+	rulesTable := getDBSchemaTable("pricing_rules")
+	reputation, err := GetReputation(tx, uid)
+	if err != nil {
+		return models.PoolLease{}, "", err
+	}
+	request := models.Bid{Amount: bid.Amount, Duration: bid.Duration, Workload: bid.Workload}
+
+	// Nodes whose maintenance window the lease would run into are skipped
+	now := time.Now()
+	leaseEnd := now.Add(time.Duration(bid.Duration) * time.Hour)
+	policyTable := getDBSchemaTable("workload_policies")
+	acceptanceTable := getDBSchemaTable("acceptance_policies")
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT r.rid, r.cost_per_hour, r.storage, p.rules, w.policy, a.policy
+		FROM %s m
+		JOIN %s r ON r.rid = m.rid
+		LEFT JOIN %s p ON p.rid = r.rid
+		LEFT JOIN %s w ON w.rid = r.rid
+		LEFT JOIN %s a ON a.rid = r.rid
+		WHERE m.pid = $1 AND r.uid != $2 AND r.available = true AND r.computing = false AND r.status = 'active'
+			AND (r.maintenanceStart IS NULL OR r.maintenanceStart >= $3 OR r.maintenanceEnd <= $4)
+		ORDER BY r.cost_per_hour, r.rid
+		FOR UPDATE OF r SKIP LOCKED`, memberTable, resourceTable, rulesTable, policyTable, acceptanceTable), bid.PID, uid, leaseEnd, now)
+	if err != nil {
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+
+	// Nodes are skipped when their price, workload or acceptance policy rejects the bid
+	rids := []string{}
+	for rows.Next() && len(rids) < bid.Nodes {
+		var rid string
+		var node models.Resource
+		var encodedRules, encodedWorkloadPolicy, encodedAcceptancePolicy []byte
+		if err := rows.Scan(&rid, &node.CostPerMinute, &node.Storage, &encodedRules, &encodedWorkloadPolicy, &encodedAcceptancePolicy); err != nil {
+			rows.Close()
+			return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+		}
+		var rules models.PricingRules
+		if encodedRules != nil {
+			json.Unmarshal(encodedRules, &rules)
+		}
+		workloadPolicy, err := decodeWorkloadPolicy(encodedWorkloadPolicy)
+		if err != nil {
+			rows.Close()
+			return models.PoolLease{}, "", err
+		}
+		acceptancePolicy, err := decodeAcceptancePolicy(encodedAcceptancePolicy)
+		if err != nil {
+			rows.Close()
+			return models.PoolLease{}, "", err
+		}
+		if pricing.MinimumAmount(node.CostPerMinute, rules, now, bid.Duration) <= bid.Amount &&
+			workload.Check(workloadPolicy, bid.Workload, node) == nil &&
+			acceptance.Check(acceptancePolicy, uid, reputation, request) == nil {
+			rids = append(rids, rid)
+		}
+	}
+	if rows.Err() != nil {
+		rows.Close()
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+	rows.Close()
+	if len(rids) < bid.Nodes {
+		return models.PoolLease{}, "not enough nodes", fmt.Errorf("only %d nodes of the pool accept the loan request at an amount of %.2f", len(rids), bid.Amount)
+	}
+	encodedWorkload, err := encodeWorkload(bid.Workload)
+	if err != nil {
+		return models.PoolLease{}, "", err
+	}
+
+	// The whole lease must be covered by the renter's credits
+	var credits, committed float64
+	walletTable := getDBSchemaTable("wallets")
+	bidTable := getDBSchemaTable("bids")
+	err = tx.QueryRow(fmt.Sprintf("SELECT credits FROM %s WHERE uid = $1 FOR UPDATE", walletTable), uid).Scan(&credits)
+	if err != nil {
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+	err = tx.QueryRow(fmt.Sprintf(`
+		SELECT COALESCE(SUM(amount * duration), 0) FROM %s
+		WHERE uid = $1 AND (status = 'pending' OR (status = 'accepted' AND computing = true))`, bidTable), uid).Scan(&committed)
+	if err != nil {
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+	total := committed + bid.Amount*float64(bid.Duration*bid.Nodes)
+	if credits < total {
+		return models.PoolLease{}, "insufficient credits to place bid", fmt.Errorf("insufficient credits to place bid, only %.2f credits available and your total bid amount is %.2f", credits, total)
+	}
+
+	lease := models.PoolLease{PoolBid: bid}
+	leaseTable := getDBSchemaTable("pool_leases")
+	err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (pid, uid, nodes, amount, duration) VALUES ($1, $2, $3, $4, $5) RETURNING plid, createdAt", leaseTable),
+		bid.PID, uid, bid.Nodes, bid.Amount, bid.Duration).Scan(&lease.PLID, &lease.CreatedAt)
+	if err != nil {
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+
+	for _, rid := range rids {
+		var nodeBid models.BidWithID
+		err = tx.QueryRow(fmt.Sprintf(`
+			INSERT INTO %s (uid, rid, amount, duration, status, computing, revision, plid, workload)
+			VALUES ($1, $2, $3, $4, 'accepted', true, %s, $5, $6)
+			RETURNING bid, rid, amount, duration, status, computing, createdAt`, bidTable, latestRevisionQuery("$2")),
+			uid, rid, bid.Amount, bid.Duration, lease.PLID, encodedWorkload).Scan(&nodeBid.BID, &nodeBid.Bid.RID, &nodeBid.Bid.Amount, &nodeBid.Bid.Duration, &nodeBid.Status, &nodeBid.Computing, &nodeBid.CreatedAt)
+		if err != nil {
+			return models.PoolLease{}, "", errors.New("failed to allocate pool node")
+		}
+		nodeBid.Workload = bid.Workload
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET computing = true WHERE rid = $1", resourceTable), rid)
+		if err != nil {
+			return models.PoolLease{}, "", errors.New("failed to update resource computing flag")
+		}
+		err = recordClearingPrice(tx, nodeBid)
+		if err != nil {
+			return models.PoolLease{}, "", err
+		}
+		lease.NodeBids = append(lease.NodeBids, nodeBid)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.PoolLease{}, "", errors.New("failed to allocate pool lease")
+	}
+	return lease, "", nil
+}
+
+// GetActivePoolLeases returns the leases of a pool whose nodes are still computing
+func GetActivePoolLeases(db *sql.DB, pid string) ([]models.PoolLease, error) {
+	leaseTable := getDBSchemaTable("pool_leases")
+	bidTable := getDBSchemaTable("bids")
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT l.plid, l.pid, l.nodes, l.amount, l.duration, l.createdAt, b.bid, b.rid, b.amount, b.duration, b.status, b.computing, b.createdAt
+		FROM %s l JOIN %s b ON b.plid = l.plid
+		WHERE l.pid = $1 AND b.computing = true
+		ORDER BY l.plid, b.rid`, leaseTable, bidTable), pid)
+	if err != nil {
+		return nil, errors.New("failed to fetch pool leases")
+	}
+	defer rows.Close()
+
+	leases := []models.PoolLease{}
+	for rows.Next() {
+		var lease models.PoolLease
+		var nodeBid models.BidWithID
+		err := rows.Scan(&lease.PLID, &lease.PID, &lease.Nodes, &lease.Amount, &lease.Duration, &lease.CreatedAt,
+			&nodeBid.BID, &nodeBid.Bid.RID, &nodeBid.Bid.Amount, &nodeBid.Bid.Duration, &nodeBid.Status, &nodeBid.Computing, &nodeBid.CreatedAt)
+		if err != nil {
+			return nil, errors.New("failed to fetch pool leases")
+		}
+		if len(leases) == 0 || leases[len(leases)-1].PLID != lease.PLID {
+			leases = append(leases, lease)
+		}
+		leases[len(leases)-1].NodeBids = append(leases[len(leases)-1].NodeBids, nodeBid)
+	}
+	return leases, nil
+}
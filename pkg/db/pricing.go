@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func SetPricingRules(db *sql.DB, rid string, rules models.PricingRules) error {
+	encoded, err := json.Marshal(rules)
+	if err != nil {
+		return errors.New("failed to encode pricing rules")
+	}
+	table := getDBSchemaTable("pricing_rules")
+	_, err = db.Exec(fmt.Sprintf(`
+		INSERT INTO %s (rid, rules) VALUES ($1, $2)
+		ON CONFLICT (rid) DO UPDATE SET rules = EXCLUDED.rules, updatedAt = CURRENT_TIMESTAMP`, table), rid, encoded)
+	if err != nil {
+		return errors.New("failed to store pricing rules")
+	}
+	return nil
+}
+
+// GetPricingRules returns the pricing rules of a resource, resources without rules get empty rules
+func GetPricingRules(db *sql.DB, rid string) (models.PricingRules, error) {
+	var rules models.PricingRules
+	var encoded []byte
+	table := getDBSchemaTable("pricing_rules")
+	err := db.QueryRow(fmt.Sprintf("SELECT rules FROM %s WHERE rid = $1", table), rid).Scan(&encoded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return rules, nil
+		}
+		return rules, errors.New("failed to fetch pricing rules")
+	}
+	if err = json.Unmarshal(encoded, &rules); err != nil {
+		return rules, errors.New("failed to decode pricing rules")
+	}
+	return rules, nil
+}
+
+// recordClearingPrice stores the winning amount of a bid along with the spec of the resource it leased
+func recordClearingPrice(db execer, bid models.BidWithID) error {
+	table := getDBSchemaTable("clearing_prices")
+	resourceTable := getDBSchemaTable("resources")
+	_, err := db.Exec(fmt.Sprintf(`
+		INSERT INTO %s (bid, rid, cpu_cores, memory, storage, gpu, bandwidth, amount, duration)
+		SELECT $1, rid, cpu_cores, memory, storage, gpu, bandwidth, $2, $3 FROM %s WHERE rid = $4
+		ON CONFLICT (bid) DO NOTHING`, table, resourceTable), bid.BID, bid.Bid.Amount, bid.Bid.Duration, bid.Bid.RID)
+	if err != nil {
+		return errors.New("failed to record clearing price")
+	}
+	return nil
+}
+
+// GetClearingPrices returns the clearing prices recorded between from and to, oldest first
+func GetClearingPrices(db *sql.DB, from time.Time, to time.Time) ([]models.ClearingPrice, error) {
+	table := getDBSchemaTable("clearing_prices")
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT rid, cpu_cores, memory, storage, gpu, bandwidth, amount, duration, clearedAt
+		FROM %s
+		WHERE clearedAt >= $1 AND clearedAt < $2
+		ORDER BY clearedAt, cpid`, table), from, to)
+	if err != nil {
+		return nil, errors.New("failed to fetch clearing prices")
+	}
+	defer rows.Close()
+
+	prices := []models.ClearingPrice{}
+	for rows.Next() {
+		var price models.ClearingPrice
+		err := rows.Scan(&price.RID, &price.Resource.CPUCores, &price.Resource.Memory, &price.Resource.Storage, &price.Resource.GPU, &price.Resource.Bandwidth, &price.Amount, &price.Duration, &price.ClearedAt)
+		if err != nil {
+			return nil, errors.New("failed to fetch clearing prices")
+		}
+		prices = append(prices, price)
+	}
+
+	return prices, nil
+}
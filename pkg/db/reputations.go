@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/reputation"
+	"github.com/gunrgnhsr/Cycloud/pkg/settlement"
+)
+
+// leaseOverStatuses are the bid statuses of leases that can be rated once they stop computing
+const leaseOverStatuses = "'accepted', 'supplier_no_show', 'renter_no_show', 'supplier_offline'"
+
+func GetReputation(db execer, uid string) (float64, error) {
+	var score float64
+	table := getDBSchemaTable("reputations")
+	err := db.QueryRow(fmt.Sprintf("SELECT score FROM %s WHERE uid = $1", table), uid).Scan(&score)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return reputation.DefaultScore, nil
+		}
+		return 0, errors.New("failed to fetch reputation")
+	}
+	return score, nil
+}
+
+// GetReputationDetails returns the score of a user along with the signals it was derived from
+func GetReputationDetails(db *sql.DB, uid string) (models.Reputation, error) {
+	rep := models.Reputation{Score: reputation.DefaultScore, ConnectionSuccessRate: 1}
+	table := getDBSchemaTable("reputations")
+	err := db.QueryRow(fmt.Sprintf(`
+		SELECT score, rating, ratings, connections, connectionSuccessRate, earlyTerminations, noShows, disputes
+		FROM %s WHERE uid = $1`, table), uid).Scan(&rep.Score, &rep.Rating, &rep.Ratings, &rep.Connections, &rep.ConnectionSuccessRate, &rep.EarlyTerminations, &rep.NoShows, &rep.Disputes)
+	if err != nil && err != sql.ErrNoRows {
+		return rep, errors.New("failed to fetch reputation")
+	}
+	return rep, nil
+}
+
+// refreshReputation derives the reputation of a user again from its ratings and lease events
+func refreshReputation(tx execer, uid string) error {
+	var rep models.Reputation
+	var penalty float64
+	ratingTable := getDBSchemaTable("ratings")
+	eventTable := getDBSchemaTable("lease_events")
+	table := getDBSchemaTable("reputations")
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT
+			COALESCE((SELECT AVG(score) FROM %s WHERE ratee = $1), 0),
+			(SELECT COUNT(*) FROM %s WHERE ratee = $1),
+			COUNT(*) FILTER (WHERE event = $2),
+			COUNT(*) FILTER (WHERE event IN ($3, $4)),
+			COUNT(*) FILTER (WHERE event IN ($5, $6)),
+			COUNT(*) FILTER (WHERE event = $7),
+			COALESCE((SELECT penalty FROM %s WHERE uid = $1), 0)
+		FROM %s WHERE uid = $1`, ratingTable, ratingTable, table, eventTable), uid,
+		string(settlement.Connected), string(settlement.SupplierNoShow), string(settlement.RenterNoShow),
+		reputation.EarlyTermination, string(settlement.SupplierOffline), reputation.Dispute).Scan(
+		&rep.Rating, &rep.Ratings, &rep.Connections, &rep.NoShows, &rep.EarlyTerminations, &rep.Disputes, &penalty)
+	if err != nil {
+		return errors.New("failed to compute reputation")
+	}
+	rep.ConnectionSuccessRate = reputation.ConnectionSuccessRate(rep.Connections, rep.NoShows)
+	rep.Score = reputation.Score(rep, penalty)
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s (uid, score, rating, ratings, connections, connectionSuccessRate, earlyTerminations, noShows, disputes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (uid) DO UPDATE SET score = EXCLUDED.score, rating = EXCLUDED.rating, ratings = EXCLUDED.ratings,
+			connections = EXCLUDED.connections, connectionSuccessRate = EXCLUDED.connectionSuccessRate,
+			earlyTerminations = EXCLUDED.earlyTerminations, noShows = EXCLUDED.noShows, disputes = EXCLUDED.disputes`, table),
+		uid, rep.Score, rep.Rating, rep.Ratings, rep.Connections, rep.ConnectionSuccessRate, rep.EarlyTerminations, rep.NoShows, rep.Disputes)
+	if err != nil {
+		return errors.New("failed to update reputation")
+	}
+	return nil
+}
+
+// recordLeaseEvent records an event of a lease against uid and updates the reputation of uid. Each event happens
+// once per lease and party, recording it again, as each reconnection of a lease does, changes nothing.
+func recordLeaseEvent(tx execer, bid string, uid string, event string) error {
+	table := getDBSchemaTable("lease_events")
+	result, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (bid, uid, event) VALUES ($1, $2, $3) ON CONFLICT (bid, uid, event) DO NOTHING", table), bid, uid, event)
+	if err != nil {
+		return errors.New("failed to record lease event")
+	}
+	if recorded, _ := result.RowsAffected(); recorded == 0 {
+		return nil
+	}
+	return refreshReputation(tx, uid)
+}
+
+func RecordLeaseEvent(db *sql.DB, bid string, uid string, event string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to record lease event")
+	}
+	defer tx.Rollback()
+
+	if err = recordLeaseEvent(tx, bid, uid, event); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to record lease event")
+	}
+	return nil
+}
+
+// InsertRating stores the rating rater gives the other party of a lease that is over and updates the reputation of
+// the rated party
+func InsertRating(db *sql.DB, bid string, rater string, score int, comment string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to rate lease")
+	}
+	defer tx.Rollback()
+
+	var renterUID, supplierUID string
+	var over bool
+	bidTable := getDBSchemaTable("bids")
+	resourceTable := getDBSchemaTable("resources")
+	err = tx.QueryRow(fmt.Sprintf(`
+		SELECT b.uid, r.uid, b.status IN (%s) AND b.computing = false
+		FROM %s b JOIN %s r ON r.rid = b.rid
+		WHERE b.bid = $1`, leaseOverStatuses, bidTable, resourceTable), bid).Scan(&renterUID, &supplierUID, &over)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("bid not found")
+		}
+		return errors.New("failed to rate lease")
+	}
+
+	ratee := supplierUID
+	if rater == supplierUID {
+		ratee = renterUID
+	} else if rater != renterUID {
+		return errors.New("user is not a party of the lease")
+	}
+	if !over {
+		return errors.New("lease is not over")
+	}
+
+	table := getDBSchemaTable("ratings")
+	result, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s (bid, rater, ratee, score, comment) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (bid, rater) DO NOTHING`, table), bid, rater, ratee, score, comment)
+	if err != nil {
+		return errors.New("failed to rate lease")
+	}
+	if rated, err := result.RowsAffected(); err != nil || rated == 0 {
+		return errors.New("lease already rated")
+	}
+
+	if err = refreshReputation(tx, ratee); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to rate lease")
+	}
+	return nil
+}
+
+// GetResourceRatings returns the ratings renters gave the supplier of a resource for leases on it, newest first
+func GetResourceRatings(db *sql.DB, rid string) ([]models.Rating, error) {
+	table := getDBSchemaTable("ratings")
+	bidTable := getDBSchemaTable("bids")
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT ra.bid, ra.score, ra.comment, ra.createdAt
+		FROM %s ra JOIN %s b ON b.bid = ra.bid
+		WHERE b.rid = $1 AND ra.rater = b.uid
+		ORDER BY ra.createdAt DESC`, table, bidTable), rid)
+	if err != nil {
+		return nil, errors.New("failed to fetch ratings")
+	}
+	defer rows.Close()
+
+	ratings := []models.Rating{}
+	for rows.Next() {
+		var rating models.Rating
+		if err := rows.Scan(&rating.BID, &rating.Score, &rating.Comment, &rating.CreatedAt); err != nil {
+			return nil, errors.New("failed to fetch ratings")
+		}
+		ratings = append(ratings, rating)
+	}
+	return ratings, nil
+}
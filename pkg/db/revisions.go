@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// latestRevisionQuery returns a subquery selecting the latest revision of the resource whose ID is given by the
+// rid placeholder or expression
+func latestRevisionQuery(rid string) string {
+	return fmt.Sprintf("(SELECT MAX(rr.revision) FROM %s rr WHERE rr.rid = %s)", getDBSchemaTable("resource_revisions"), rid)
+}
+
+// recordResourceRevision stores the spec of a resource as its next revision
+func recordResourceRevision(tx execer, rid string, uid string, resource models.Resource) error {
+	resource.Available = false
+	resource.Computing = false
+	spec, err := json.Marshal(resource)
+	if err != nil {
+		return errors.New("failed to record resource revision")
+	}
+	table := getDBSchemaTable("resource_revisions")
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s (rid, revision, uid, spec)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3 FROM %s WHERE rid = $1`, table, table), rid, uid, spec)
+	if err != nil {
+		return errors.New("failed to record resource revision")
+	}
+	return nil
+}
+
+// UpdateResource replaces the spec and cost of a resource and records the change as a new revision. Spec changes are
+// refused while the resource is computing or has bids waiting for an auction to close.
+func UpdateResource(db *sql.DB, rid string, uid string, resource models.Resource, specChanged bool) (int, error) {
+	values, err := resourceSpecValues(resource)
+	if err != nil {
+		return 0, errors.New("failed to update resource")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, errors.New("failed to update resource")
+	}
+	defer tx.Rollback()
+
+	// Lock the resource so its state can't change between the checks and the update
+	var computing bool
+	table := getDBSchemaTable("resources")
+	err = tx.QueryRow(fmt.Sprintf("SELECT computing FROM %s WHERE rid = $1 FOR UPDATE", table), rid).Scan(&computing)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("resource not found")
+		}
+		return 0, errors.New("failed to update resource")
+	}
+
+	if specChanged {
+		if computing {
+			return 0, errors.New("resource is currently computing")
+		}
+		var underAuction bool
+		bidTable := getDBSchemaTable("bids")
+		err = tx.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE rid = $1 AND status IN ('pending', 'processing'))", bidTable), rid).Scan(&underAuction)
+		if err != nil {
+			return 0, errors.New("failed to update resource")
+		}
+		if underAuction {
+			return 0, errors.New("resource is under auction")
+		}
+	}
+
+	// A hardware report only verifies the facts it measured
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s SET verification = 'unverified'
+		WHERE rid = $1 AND (cpu_cores, memory, storage, cpu_arch, cpu_model, os) IS DISTINCT FROM ($2::integer, $3::integer, $4::integer, $5::text, $6::text, $7::text)`, table),
+		rid, resource.CPUCores, resource.Memory, resource.Storage, resource.CPUArch, resource.CPUModel, resource.OS)
+	if err != nil {
+		return 0, errors.New("failed to update resource")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET (%s) = (%s) WHERE rid = $%d", table, resourceSpecColumns, placeholders(1, len(values)), len(values)+1), append(values, rid)...)
+	if err != nil {
+		return 0, errors.New("failed to update resource")
+	}
+
+	err = recordResourceRevision(tx, rid, uid, resource)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int
+	err = tx.QueryRow(latestRevisionQuery("$1"), rid).Scan(&revision)
+	if err != nil {
+		return 0, errors.New("failed to update resource")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.New("failed to update resource")
+	}
+	return revision, nil
+}
+
+func scanResourceRevision(row rowScanner) (models.ResourceRevision, error) {
+	var revision models.ResourceRevision
+	var spec []byte
+	err := row.Scan(&revision.RID, &revision.Revision, &spec, &revision.CreatedAt)
+	if err != nil {
+		return revision, err
+	}
+	err = json.Unmarshal(spec, &revision.Resource)
+	return revision, err
+}
+
+// GetResourceRevisions returns every revision of a resource, oldest first
+func GetResourceRevisions(db *sql.DB, rid string) ([]models.ResourceRevision, error) {
+	table := getDBSchemaTable("resource_revisions")
+	rows, err := db.Query(fmt.Sprintf("SELECT rid, revision, spec, createdAt FROM %s WHERE rid = $1 ORDER BY revision", table), rid)
+	if err != nil {
+		return nil, errors.New("failed to fetch resource revisions")
+	}
+	defer rows.Close()
+
+	revisions := []models.ResourceRevision{}
+	for rows.Next() {
+		revision, err := scanResourceRevision(rows)
+		if err != nil {
+			return nil, errors.New("failed to fetch resource revisions")
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+// GetBidResourceRevision returns the resource spec that was in force when the bid was accepted
+func GetBidResourceRevision(db *sql.DB, bidId string) (models.ResourceRevision, error) {
+	bidTable := getDBSchemaTable("bids")
+	revisionTable := getDBSchemaTable("resource_revisions")
+	revision, err := scanResourceRevision(db.QueryRow(fmt.Sprintf(`
+		SELECT r.rid, r.revision, r.spec, r.createdAt
+		FROM %s b JOIN %s r ON r.rid = b.rid AND r.revision = b.revision
+		WHERE b.bid = $1`, bidTable, revisionTable), bidId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.ResourceRevision{}, errors.New("bid has not been accepted")
+		}
+		return models.ResourceRevision{}, errors.New("failed to fetch resource revision")
+	}
+	return revision, nil
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func grantRole(tx execer, uid string, role string) error {
+	table := getDBSchemaTable("user_roles")
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (uid, role) VALUES ($1, $2) ON CONFLICT (uid, role) DO NOTHING", table), uid, role)
+	return err
+}
+
+// GrantRole gives a role to a user, granting a role the user already holds does nothing
+func GrantRole(db *sql.DB, uid string, role string, audit models.AuditEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to grant role")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	userTable := getDBSchemaTable("users")
+	err = tx.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE uid = $1)", userTable), uid).Scan(&exists)
+	if err != nil {
+		return errors.New("failed to grant role")
+	}
+	if !exists {
+		return errors.New("user not found")
+	}
+
+	if err = grantRole(tx, uid, role); err != nil {
+		return errors.New("failed to grant role")
+	}
+
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to grant role")
+	}
+	return nil
+}
+
+// RevokeRole takes a role away from a user
+func RevokeRole(db *sql.DB, uid string, role string, audit models.AuditEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to revoke role")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("user_roles")
+	result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = $1 AND role = $2", table), uid, role)
+	if err != nil {
+		return errors.New("failed to revoke role")
+	}
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		return errors.New("user does not hold role")
+	}
+
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to revoke role")
+	}
+	return nil
+}
+
+// GetUserRoles returns the roles a user holds
+func GetUserRoles(db *sql.DB, uid string) ([]string, error) {
+	table := getDBSchemaTable("user_roles")
+	rows, err := db.Query(fmt.Sprintf("SELECT role FROM %s WHERE uid = $1 ORDER BY role", table), uid)
+	if err != nil {
+		return nil, errors.New("failed to get roles")
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err = rows.Scan(&role); err != nil {
+			return nil, errors.New("failed to get roles")
+		}
+		roles = append(roles, role)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to get roles")
+	}
+	return roles, nil
+}
@@ -0,0 +1,248 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/lib/pq"
+)
+
+// Sort columns accepted by SearchResources
+var resourceSortColumns = map[string]string{
+	"price":     "cost_per_hour",
+	"cores":     "cpu_cores",
+	"memory":    "memory",
+	"storage":   "storage",
+	"bandwidth": "bandwidth",
+	"gpus":      "gpu_count",
+	"vram":      "gpu_vram",
+	"created":   "rid",
+}
+
+// resourceSortColumn returns the column a search sorts on, the supplier reputation is not a column of its own
+func resourceSortColumn(sort string) (string, bool) {
+	if sort == "reputation" {
+		return supplierReputation(), true
+	}
+	column, ok := resourceSortColumns[sort]
+	return column, ok
+}
+
+const (
+	DefaultSearchPageSize = 20
+	MaxSearchPageSize     = 100
+)
+
+// ResourceSearch holds the filters, ordering and page of a resource search.
+type ResourceSearch struct {
+	MinCores      int
+	MinMemory     int
+	MinStorage    int
+	MinBandwidth  int
+	MinGPUs       int
+	MinVRAM       int     // per GPU, in GB
+	GPU           string  // case-insensitive substring of the GPU model
+	MaxPrice      float64 // ignored when zero
+	CPUArch       string
+	OS            string // case-insensitive substring of the OS image
+	CUDAVersion   string
+	Region        string
+	Zone          string
+	Tags          []string          // resources must carry every tag
+	Labels        map[string]string // resources must carry every label
+	VerifiedOnly  bool              // only resources whose hardware report matches their spec
+	MinReputation float64           // score of the supplier
+	Sort          string            // one of price, cores, memory, storage, bandwidth, gpus, vram, reputation, created
+	Descending    bool
+	PageSize      int
+	Cursor        string // opaque cursor returned by the previous page
+}
+
+// searchCursor is the keyset position a page ends at
+type searchCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	RID   int64  `json:"r"`
+}
+
+func encodeSearchCursor(cursor searchCursor) string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeSearchCursor(encoded string) (searchCursor, error) {
+	var cursor searchCursor
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	if err = json.Unmarshal(decoded, &cursor); err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// escapeLike escapes the LIKE wildcards of a substring pattern
+func escapeLike(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(pattern)
+}
+
+// buildResourceSearchQuery returns the WHERE and ORDER BY clauses of a search along with its arguments
+func buildResourceSearchQuery(uid string, search ResourceSearch) (string, []interface{}, error) {
+	if search.Sort == "" {
+		search.Sort = "created"
+	}
+	column, ok := resourceSortColumn(search.Sort)
+	if !ok {
+		return "", nil, errors.New("invalid sort, expected one of price, cores, memory, storage, bandwidth, gpus, vram, reputation, created")
+	}
+
+	args := []interface{}{uid}
+	conditions := []string{"available = true", "status = 'active'", "uid != $1"}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if search.MinCores > 0 {
+		addCondition("cpu_cores >= $%d", search.MinCores)
+	}
+	if search.MinMemory > 0 {
+		addCondition("memory >= $%d", search.MinMemory)
+	}
+	if search.MinStorage > 0 {
+		addCondition("storage >= $%d", search.MinStorage)
+	}
+	if search.MinBandwidth > 0 {
+		addCondition("bandwidth >= $%d", search.MinBandwidth)
+	}
+	if search.MinGPUs > 0 {
+		addCondition("gpu_count >= $%d", search.MinGPUs)
+	}
+	if search.MinVRAM > 0 {
+		addCondition("gpu_vram >= $%d", search.MinVRAM)
+	}
+	if search.GPU != "" {
+		addCondition("gpu ILIKE '%%' || $%d || '%%'", escapeLike(search.GPU))
+	}
+	if search.MaxPrice > 0 {
+		addCondition("cost_per_hour <= $%d", search.MaxPrice)
+	}
+	if search.CPUArch != "" {
+		addCondition("cpu_arch = $%d", search.CPUArch)
+	}
+	if search.OS != "" {
+		addCondition("os ILIKE '%%' || $%d || '%%'", escapeLike(search.OS))
+	}
+	if search.CUDAVersion != "" {
+		addCondition("cuda_version = $%d", search.CUDAVersion)
+	}
+	if search.Region != "" {
+		addCondition("region = LOWER($%d)", search.Region)
+	}
+	if search.Zone != "" {
+		addCondition("zone = LOWER($%d)", search.Zone)
+	}
+	if len(search.Tags) > 0 {
+		addCondition("tags @> $%d", pq.Array(search.Tags))
+	}
+	if len(search.Labels) > 0 {
+		labels, err := json.Marshal(search.Labels)
+		if err != nil {
+			return "", nil, errors.New("invalid labels")
+		}
+		addCondition("labels @> $%d::jsonb", string(labels))
+	}
+	if search.VerifiedOnly {
+		addCondition("verification = $%d", models.VerificationVerified)
+	}
+	if search.MinReputation > 0 {
+		addCondition(supplierReputation()+" >= $%d", search.MinReputation)
+	}
+
+	direction, comparison := "ASC", ">"
+	if search.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if search.Cursor != "" {
+		cursor, err := decodeSearchCursor(search.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		if cursor.Sort != search.Sort {
+			return "", nil, errors.New("cursor does not match the requested sort")
+		}
+		args = append(args, cursor.Value, cursor.RID)
+		conditions = append(conditions, fmt.Sprintf("(%s, rid) %s ($%d::numeric, $%d)", column, comparison, len(args)-1, len(args)))
+	}
+
+	query := fmt.Sprintf("WHERE %s ORDER BY %s %s, rid %s", strings.Join(conditions, " AND "), column, direction, direction)
+	return query, args, nil
+}
+
+// SearchResources returns a page of available resources not owned by uid matching the search, and the cursor of the
+// next page which is empty on the last page
+func SearchResources(db *sql.DB, uid string, search ResourceSearch) ([]models.ResourceWithID, string, error) {
+	if search.PageSize <= 0 {
+		search.PageSize = DefaultSearchPageSize
+	}
+	if search.PageSize > MaxSearchPageSize {
+		search.PageSize = MaxSearchPageSize
+	}
+	if search.Sort == "" {
+		search.Sort = "created"
+	}
+
+	clauses, args, err := buildResourceSearchQuery(uid, search)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	table := getDBSchemaTable("resources")
+	sortColumn, _ := resourceSortColumn(search.Sort)
+	sortValue := sortColumn + "::text"
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, %s FROM %s %s LIMIT %d", resourceColumns(), sortValue, table, clauses, search.PageSize+1), args...)
+	if err != nil {
+		return nil, "", errors.New("failed to search resources")
+	}
+	defer rows.Close()
+
+	resources := []models.ResourceWithID{}
+	values := []string{}
+	for rows.Next() {
+		var value string
+		resource, err := scanResource(rowWithExtra{rows, &value})
+		if err != nil {
+			return nil, "", errors.New("failed to search resources")
+		}
+		resources = append(resources, resource)
+		values = append(values, value)
+	}
+
+	nextCursor := ""
+	if len(resources) > search.PageSize {
+		resources = resources[:search.PageSize]
+		last := resources[len(resources)-1]
+		var rid int64
+		fmt.Sscan(last.RID, &rid)
+		nextCursor = encodeSearchCursor(searchCursor{Sort: search.Sort, Value: values[search.PageSize-1], RID: rid})
+	}
+	return resources, nextCursor, nil
+}
+
+// rowWithExtra scans additional trailing columns after the ones a scan function reads
+type rowWithExtra struct {
+	row   rowScanner
+	extra interface{}
+}
+
+func (r rowWithExtra) Scan(dest ...interface{}) error {
+	return r.row.Scan(append(dest, r.extra)...)
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestBuildResourceSearchQuery(t *testing.T) {
+	search := ResourceSearch{MinCores: 8, GPU: "A100_80%", Tags: []string{"nvlink"}, Sort: "price", Descending: true}
+	query, args, err := buildResourceSearchQuery("1", search)
+	if err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	if !strings.Contains(query, "cpu_cores >= $2") || !strings.Contains(query, "tags @> $4") {
+		t.Errorf("Missing filters in query %s", query)
+	}
+	if !strings.HasSuffix(query, "ORDER BY cost_per_hour DESC, rid DESC") {
+		t.Errorf("Unexpected ordering in query %s", query)
+	}
+	if args[2] != `A100\_80\%` {
+		t.Errorf("Expected escaped GPU pattern, got %v", args[2])
+	}
+
+	_, _, err = buildResourceSearchQuery("1", ResourceSearch{Sort: "name"})
+	if err == nil {
+		t.Errorf("Expected error for invalid sort")
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	cursor := encodeSearchCursor(searchCursor{Sort: "price", Value: "1.50", RID: 42})
+	query, args, err := buildResourceSearchQuery("1", ResourceSearch{Sort: "price", Cursor: cursor})
+	if err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	if !strings.Contains(query, "(cost_per_hour, rid) > ($2::numeric, $3)") {
+		t.Errorf("Missing keyset condition in query %s", query)
+	}
+	if args[1] != "1.50" || args[2] != int64(42) {
+		t.Errorf("Unexpected cursor arguments %v", args)
+	}
+
+	_, _, err = buildResourceSearchQuery("1", ResourceSearch{Sort: "cores", Cursor: cursor})
+	if err == nil {
+		t.Errorf("Expected error for cursor of another sort")
+	}
+	_, _, err = buildResourceSearchQuery("1", ResourceSearch{Cursor: "not a cursor"})
+	if err == nil {
+		t.Errorf("Expected error for malformed cursor")
+	}
+}
+
+func TestSearchByReputation(t *testing.T) {
+	query, args, err := buildResourceSearchQuery("1", ResourceSearch{MinReputation: 80, Sort: "reputation", Descending: true})
+	if err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	if !strings.Contains(query, "rep.uid = resources.uid), 100) >= $2") || args[1] != 80.0 {
+		t.Errorf("Missing reputation filter in query %s", query)
+	}
+	if !strings.HasSuffix(query, "), 100) DESC, rid DESC") {
+		t.Errorf("Unexpected ordering in query %s", query)
+	}
+}
+
+func TestResourceWithoutTagsIsStoredWithNone(t *testing.T) {
+	values, err := resourceSpecValues(models.Resource{CPUCores: 4})
+	if err != nil {
+		t.Fatalf("Failed to get resource values: %v", err)
+	}
+	// tags are searched with @>, a NULL array would match nothing and violates NOT NULL
+	if tags, err := values[7].(driver.Valuer).Value(); err != nil || tags != "{}" {
+		t.Errorf("Expected an empty tag array, got %v: %v", tags, err)
+	}
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// InsertSession starts a session for a signed in device with its first refresh token and drops the ended sessions
+// of the user
+func InsertSession(db *sql.DB, uid string, device string, ip string, refreshHash string, expiresAt time.Time) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", errors.New("failed to create session")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("sessions")
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = $1 AND expiresAt < CURRENT_TIMESTAMP", table), uid)
+	if err != nil {
+		return "", errors.New("failed to create session")
+	}
+
+	sid, err := auth.GenerateSessionID()
+	if err != nil {
+		return "", errors.New("failed to create session")
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (sid, uid, device, ip, expiresAt) VALUES ($1, $2, $3, $4, $5)", table), sid, uid, device, ip, expiresAt)
+	if err != nil {
+		return "", errors.New("failed to create session")
+	}
+
+	refreshTable := getDBSchemaTable("refresh_tokens")
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (tokenHash, sid) VALUES ($1, $2)", refreshTable), refreshHash, sid)
+	if err != nil {
+		return "", errors.New("failed to create session")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", errors.New("failed to create session")
+	}
+	return sid, nil
+}
+
+// GetSessionUser returns the owner of a live session and marks the session as used
+func GetSessionUser(db *sql.DB, sid string) (string, error) {
+	var uid string
+	table := getDBSchemaTable("sessions")
+	err := db.QueryRow(fmt.Sprintf(`UPDATE %s SET lastUsedAt = CURRENT_TIMESTAMP
+		WHERE sid = $1 AND revokedAt IS NULL AND expiresAt > CURRENT_TIMESTAMP RETURNING uid`, table), sid).Scan(&uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("session expired or revoked")
+		}
+		return "", errors.New("failed to check session")
+	}
+	return uid, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same session and extends the session. A refresh
+// token can only be used once, presenting it again means it was stolen so the whole session is revoked. The session
+// and the user it belongs to are returned.
+func RotateRefreshToken(db *sql.DB, refreshHash string, newRefreshHash string, expiresAt time.Time) (string, string, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", "", errors.New("failed to refresh session")
+	}
+	defer tx.Rollback()
+
+	var sid, uid, username string
+	var usedAt, revokedAt *time.Time
+	var sessionExpiresAt time.Time
+	table := getDBSchemaTable("sessions")
+	refreshTable := getDBSchemaTable("refresh_tokens")
+	userTable := getDBSchemaTable("users")
+	err = tx.QueryRow(fmt.Sprintf(`SELECT rt.sid, rt.usedAt, s.uid, u.username, s.revokedAt, s.expiresAt FROM %s rt
+		JOIN %s s ON s.sid = rt.sid JOIN %s u ON u.uid = s.uid WHERE rt.tokenHash = $1 FOR UPDATE OF rt, s`, refreshTable, table, userTable), refreshHash).Scan(&sid, &usedAt, &uid, &username, &revokedAt, &sessionExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", "", errors.New("invalid refresh token")
+		}
+		return "", "", "", errors.New("failed to refresh session")
+	}
+	if revokedAt != nil || sessionExpiresAt.Before(time.Now()) {
+		return "", "", "", errors.New("invalid refresh token")
+	}
+
+	if usedAt != nil {
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET revokedAt = CURRENT_TIMESTAMP WHERE sid = $1", table), sid)
+		if err != nil {
+			return "", "", "", errors.New("failed to refresh session")
+		}
+		if err = tx.Commit(); err != nil {
+			return "", "", "", errors.New("failed to refresh session")
+		}
+		return "", "", "", errors.New("refresh token reused")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET usedAt = CURRENT_TIMESTAMP WHERE tokenHash = $1", refreshTable), refreshHash)
+	if err != nil {
+		return "", "", "", errors.New("failed to refresh session")
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (tokenHash, sid) VALUES ($1, $2)", refreshTable), newRefreshHash, sid)
+	if err != nil {
+		return "", "", "", errors.New("failed to refresh session")
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET lastUsedAt = CURRENT_TIMESTAMP, expiresAt = $2 WHERE sid = $1", table), sid, expiresAt)
+	if err != nil {
+		return "", "", "", errors.New("failed to refresh session")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", "", "", errors.New("failed to refresh session")
+	}
+	return sid, uid, username, nil
+}
+
+// GetUserSessions lists the live sessions of a user, most recently used first
+func GetUserSessions(db *sql.DB, uid string) ([]models.Session, error) {
+	table := getDBSchemaTable("sessions")
+	rows, err := db.Query(fmt.Sprintf(`SELECT sid, device, ip, createdAt, lastUsedAt, expiresAt FROM %s
+		WHERE uid = $1 AND revokedAt IS NULL AND expiresAt > CURRENT_TIMESTAMP ORDER BY lastUsedAt DESC`, table), uid)
+	if err != nil {
+		return nil, errors.New("failed to get sessions")
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		err = rows.Scan(&session.SID, &session.Device, &session.IP, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, errors.New("failed to get sessions")
+		}
+		sessions = append(sessions, session)
+	}
+	if rows.Err() != nil {
+		return nil, errors.New("failed to get sessions")
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a session of a user, its access and refresh tokens stop working right away
+func RevokeSession(db *sql.DB, uid string, sid string) error {
+	table := getDBSchemaTable("sessions")
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET revokedAt = CURRENT_TIMESTAMP WHERE sid = $1 AND uid = $2 AND revokedAt IS NULL", table), sid, uid)
+	if err != nil {
+		return errors.New("failed to revoke session")
+	}
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		return errors.New("session not found")
+	}
+	return nil
+}
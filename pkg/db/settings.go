@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// Settings admins change at runtime
+const (
+	SettingRequireSupplierTOTP = "require_supplier_totp" // "true" when suppliers need two-factor authentication
+)
+
+// GetSetting returns the value of a setting and whether it was ever set
+func GetSetting(db *sql.DB, name string) (string, bool, error) {
+	var value string
+	table := getDBSchemaTable("settings")
+	err := db.QueryRow(fmt.Sprintf("SELECT value FROM %s WHERE name = $1", table), name).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, errors.New("failed to get setting")
+	}
+	return value, true, nil
+}
+
+// SetSetting stores the value of a setting, settings are changed by admins
+func SetSetting(db *sql.DB, name string, value string, audit models.AuditEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to store setting")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("settings")
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, updatedAt = CURRENT_TIMESTAMP`, table), name, value)
+	if err != nil {
+		return errors.New("failed to store setting")
+	}
+
+	if err = insertAuditEntry(tx, audit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to store setting")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func InsertResourceTemplate(db *sql.DB, uid string, name string, resource models.Resource) (string, error) {
+	spec, err := json.Marshal(resource)
+	if err != nil {
+		return "", errors.New("failed to store resource template")
+	}
+	var tid string
+	table := getDBSchemaTable("resource_templates")
+	err = db.QueryRow(fmt.Sprintf(`
+		INSERT INTO %s (uid, name, spec) VALUES ($1, $2, $3)
+		ON CONFLICT (uid, name) DO UPDATE SET spec = EXCLUDED.spec
+		RETURNING tid`, table), uid, name, spec).Scan(&tid)
+	if err != nil {
+		return "", errors.New("failed to store resource template")
+	}
+	return tid, nil
+}
+
+func scanResourceTemplate(row rowScanner) (models.ResourceTemplate, error) {
+	var template models.ResourceTemplate
+	var spec []byte
+	err := row.Scan(&template.TID, &template.Name, &spec, &template.CreatedAt)
+	if err != nil {
+		return template, err
+	}
+	err = json.Unmarshal(spec, &template.Resource)
+	return template, err
+}
+
+func GetUserResourceTemplates(db *sql.DB, uid string) ([]models.ResourceTemplate, error) {
+	table := getDBSchemaTable("resource_templates")
+	rows, err := db.Query(fmt.Sprintf("SELECT tid, name, spec, createdAt FROM %s WHERE uid = $1 ORDER BY name", table), uid)
+	if err != nil {
+		return nil, errors.New("failed to fetch resource templates")
+	}
+	defer rows.Close()
+
+	templates := []models.ResourceTemplate{}
+	for rows.Next() {
+		template, err := scanResourceTemplate(rows)
+		if err != nil {
+			return nil, errors.New("failed to fetch resource templates")
+		}
+		templates = append(templates, template)
+	}
+
+	return templates, nil
+}
+
+func DeleteResourceTemplate(db *sql.DB, uid string, tid string) error {
+	table := getDBSchemaTable("resource_templates")
+	result, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE tid = $1 AND uid = $2", table), tid, uid)
+	if err != nil {
+		return errors.New("failed to delete resource template")
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return errors.New("resource template not found")
+	}
+	return nil
+}
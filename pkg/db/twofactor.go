@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetTOTPState returns the TOTP secret of a user, whether two-factor authentication is enabled with it and the last
+// time step a code was accepted for. The secret is empty when the user never started enrolling.
+func GetTOTPState(db *sql.DB, uid string) (string, bool, int64, error) {
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	table := getDBSchemaTable("users")
+	err := db.QueryRow(fmt.Sprintf("SELECT totpSecret, totpEnabledAt IS NOT NULL, totpLastStep FROM %s WHERE uid = $1", table), uid).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, 0, errors.New("user not found")
+		}
+		return "", false, 0, errors.New("failed to get two-factor authentication")
+	}
+	return secret.String, enabled, lastStep, nil
+}
+
+// SetPendingTOTPSecret stores the secret a user is enrolling, it only takes effect once EnableTOTP confirms a code
+// of it. Enrolling again replaces a secret that was never confirmed.
+func SetPendingTOTPSecret(db *sql.DB, uid string, secret string) error {
+	table := getDBSchemaTable("users")
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET totpSecret = $2, totpLastStep = 0 WHERE uid = $1 AND totpEnabledAt IS NULL", table), uid, secret)
+	if err != nil {
+		return errors.New("failed to enroll two-factor authentication")
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return errors.New("two-factor authentication already enabled")
+	}
+	return nil
+}
+
+// replaceRecoveryCodes drops the recovery codes of a user and stores new ones
+func replaceRecoveryCodes(tx execer, uid string, codeHashes []string) error {
+	table := getDBSchemaTable("recovery_codes")
+	_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = $1", table), uid)
+	if err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (codeHash, uid) VALUES ($1, $2)", table), codeHash, uid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret of a user once a code of it was verified,
+// storing the step of that code and the recovery codes of the user
+func EnableTOTP(db *sql.DB, uid string, step int64, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to enable two-factor authentication")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("users")
+	result, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET totpEnabledAt = CURRENT_TIMESTAMP, totpLastStep = $2
+		WHERE uid = $1 AND totpSecret IS NOT NULL AND totpEnabledAt IS NULL`, table), uid, step)
+	if err != nil {
+		return errors.New("failed to enable two-factor authentication")
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return errors.New("two-factor authentication already enabled")
+	}
+
+	if err = replaceRecoveryCodes(tx, uid, codeHashes); err != nil {
+		return errors.New("failed to enable two-factor authentication")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to enable two-factor authentication")
+	}
+	return nil
+}
+
+// UseTOTPStep records that a code of a time step was accepted for a user, a code of that step or an earlier one is
+// then refused. Two requests racing with the same code cannot both record it.
+func UseTOTPStep(db *sql.DB, uid string, step int64) error {
+	table := getDBSchemaTable("users")
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET totpLastStep = $2 WHERE uid = $1 AND totpLastStep < $2", table), uid, step)
+	if err != nil {
+		return errors.New("failed to check code")
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return errors.New("invalid code")
+	}
+	return nil
+}
+
+// UseRecoveryCode spends one of the recovery codes of a user, each code works once
+func UseRecoveryCode(db *sql.DB, uid string, codeHash string) error {
+	table := getDBSchemaTable("recovery_codes")
+	result, err := db.Exec(fmt.Sprintf("UPDATE %s SET usedAt = CURRENT_TIMESTAMP WHERE codeHash = $1 AND uid = $2 AND usedAt IS NULL", table), codeHash, uid)
+	if err != nil {
+		return errors.New("failed to check code")
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return errors.New("invalid code")
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(db *sql.DB, uid string) (int, error) {
+	var count int
+	table := getDBSchemaTable("recovery_codes")
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE uid = $1 AND usedAt IS NULL", table), uid).Scan(&count)
+	if err != nil {
+		return 0, errors.New("failed to count recovery codes")
+	}
+	return count, nil
+}
+
+// ReplaceRecoveryCodes replaces all the recovery codes of a user, used or not
+func ReplaceRecoveryCodes(db *sql.DB, uid string, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to replace recovery codes")
+	}
+	defer tx.Rollback()
+
+	if err = replaceRecoveryCodes(tx, uid, codeHashes); err != nil {
+		return errors.New("failed to replace recovery codes")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to replace recovery codes")
+	}
+	return nil
+}
+
+// DisableTOTP turns off two-factor authentication for a user, dropping its secret, recovery codes and the logins
+// waiting for a second factor
+func DisableTOTP(db *sql.DB, uid string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+	defer tx.Rollback()
+
+	table := getDBSchemaTable("users")
+	result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET totpSecret = NULL, totpEnabledAt = NULL, totpLastStep = 0 WHERE uid = $1 AND totpEnabledAt IS NOT NULL", table), uid)
+	if err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return errors.New("two-factor authentication not enabled")
+	}
+
+	if err = replaceRecoveryCodes(tx, uid, nil); err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+	challengeTable := getDBSchemaTable("login_challenges")
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = $1", challengeTable), uid)
+	if err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+	return nil
+}
+
+// InsertLoginChallenge stores the challenge of a login whose password was accepted and that waits for its second
+// factor, the expired challenges of the user are dropped
+func InsertLoginChallenge(db *sql.DB, uid string, challengeHash string, expiresAt time.Time) error {
+	table := getDBSchemaTable("login_challenges")
+	_, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = $1 AND expiresAt < CURRENT_TIMESTAMP", table), uid)
+	if err != nil {
+		return errors.New("failed to store login challenge")
+	}
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (challengeHash, uid, expiresAt) VALUES ($1, $2, $3)", table), challengeHash, uid, expiresAt)
+	if err != nil {
+		return errors.New("failed to store login challenge")
+	}
+	return nil
+}
+
+// AttemptLoginChallenge counts an attempt at the second factor of a login and returns the user the login is for with
+// its username. Expired challenges and challenges out of attempts are refused.
+func AttemptLoginChallenge(db *sql.DB, challengeHash string, maxAttempts int) (string, string, error) {
+	var uid, username string
+	table := getDBSchemaTable("login_challenges")
+	userTable := getDBSchemaTable("users")
+	err := db.QueryRow(fmt.Sprintf(`UPDATE %s c SET attempts = c.attempts + 1 FROM %s u
+		WHERE c.challengeHash = $1 AND c.expiresAt > CURRENT_TIMESTAMP AND c.attempts < $2 AND u.uid = c.uid
+		RETURNING c.uid, u.username`, table, userTable), challengeHash, maxAttempts).Scan(&uid, &username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", errors.New("invalid or expired challenge")
+		}
+		return "", "", errors.New("failed to check login challenge")
+	}
+	return uid, username, nil
+}
+
+// CompleteLoginChallenge consumes the challenge of a login whose second factor was accepted, a challenge completes
+// only once
+func CompleteLoginChallenge(db *sql.DB, challengeHash string) error {
+	table := getDBSchemaTable("login_challenges")
+	result, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE challengeHash = $1", table), challengeHash)
+	if err != nil {
+		return errors.New("failed to complete login challenge")
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return errors.New("invalid or expired challenge")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func SetWorkloadPolicy(db *sql.DB, rid string, policy models.WorkloadPolicy) error {
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return errors.New("failed to encode workload policy")
+	}
+	table := getDBSchemaTable("workload_policies")
+	_, err = db.Exec(fmt.Sprintf(`
+		INSERT INTO %s (rid, policy) VALUES ($1, $2)
+		ON CONFLICT (rid) DO UPDATE SET policy = EXCLUDED.policy, updatedAt = CURRENT_TIMESTAMP`, table), rid, encoded)
+	if err != nil {
+		return errors.New("failed to store workload policy")
+	}
+	return nil
+}
+
+// GetWorkloadPolicy returns the workload policy of a resource, resources without a policy accept any workload
+func GetWorkloadPolicy(db *sql.DB, rid string) (models.WorkloadPolicy, error) {
+	var encoded []byte
+	table := getDBSchemaTable("workload_policies")
+	err := db.QueryRow(fmt.Sprintf("SELECT policy FROM %s WHERE rid = $1", table), rid).Scan(&encoded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.WorkloadPolicy{}, nil
+		}
+		return models.WorkloadPolicy{}, errors.New("failed to fetch workload policy")
+	}
+	return decodeWorkloadPolicy(encoded)
+}
+
+func decodeWorkloadPolicy(encoded []byte) (models.WorkloadPolicy, error) {
+	var policy models.WorkloadPolicy
+	if encoded == nil {
+		return policy, nil
+	}
+	if err := json.Unmarshal(encoded, &policy); err != nil {
+		return policy, errors.New("failed to decode workload policy")
+	}
+	return policy, nil
+}
+
+// encodeWorkload returns the value of the workload column of a bid, NULL for interactive leases
+func encodeWorkload(workload *models.Workload) (interface{}, error) {
+	if workload == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(workload)
+	if err != nil {
+		return nil, errors.New("failed to encode workload")
+	}
+	return string(encoded), nil
+}
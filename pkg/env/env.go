@@ -0,0 +1,58 @@
+package env
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Positive accepts numbers above zero
+func Positive(value float64) bool {
+	return value > 0
+}
+
+// NonNegative accepts zero and the numbers above it
+func NonNegative(value float64) bool {
+	return value >= 0
+}
+
+// Count accepts whole numbers above zero
+func Count(value float64) bool {
+	return value > 0 && value == math.Trunc(value)
+}
+
+// Whole accepts zero and the whole numbers above it
+func Whole(value float64) bool {
+	return value >= 0 && value == math.Trunc(value)
+}
+
+// lookup reads the number in the environment variable key, it is not ok when the variable is unset, not a number or
+// rejected by valid
+func lookup(key string, valid func(float64) bool) (float64, bool) {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || !valid(value) {
+		return 0, false
+	}
+	return value, true
+}
+
+// Number returns the number in the environment variable key, or fallback when the variable is unset, not a number
+// or rejected by valid
+func Number(key string, fallback float64, valid func(float64) bool) float64 {
+	value, ok := lookup(key, valid)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+// Duration returns the number of units in the environment variable key as a duration, or fallback when the variable
+// is unset, not a number or rejected by valid
+func Duration(key string, fallback time.Duration, unit time.Duration, valid func(float64) bool) time.Duration {
+	value, ok := lookup(key, valid)
+	if !ok {
+		return fallback
+	}
+	return time.Duration(value * float64(unit))
+}
@@ -0,0 +1,42 @@
+package env
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		env   string
+		valid func(float64) bool
+		want  float64
+	}{
+		{"", Positive, 5},
+		{"invalid", Positive, 5},
+		{"NaN", NonNegative, 5},
+		{"2.5", Positive, 2.5},
+		{"0", Positive, 5},
+		{"0", NonNegative, 0},
+		{"-1", NonNegative, 5},
+		{"2.5", Count, 5},
+		{"3", Count, 3},
+		{"0", Whole, 0},
+	}
+	for _, test := range tests {
+		t.Setenv("CYCLOUD_TEST_NUMBER", test.env)
+		if got := Number("CYCLOUD_TEST_NUMBER", 5, test.valid); got != test.want {
+			t.Errorf("Expected %q to read as %v, got %v", test.env, test.want, got)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	t.Setenv("CYCLOUD_TEST_DURATION", "1.5")
+	if got := Duration("CYCLOUD_TEST_DURATION", time.Minute, time.Hour, Positive); got != 90*time.Minute {
+		t.Errorf("Expected 1.5 hours, got %v", got)
+	}
+	t.Setenv("CYCLOUD_TEST_DURATION", "-2")
+	if got := Duration("CYCLOUD_TEST_DURATION", time.Minute, time.Hour, Positive); got != time.Minute {
+		t.Errorf("Expected the fallback, got %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/acceptance"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// GetAcceptancePolicy handles the retrieval of the loan requests a resource of the user accepts.
+func GetAcceptancePolicy(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	// The renter lists are private to the supplier
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	policy, err := pkg.GetAcceptancePolicy(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(policy)
+}
+
+// SetAcceptancePolicy handles replacing the loan requests a resource of the user accepts.
+func SetAcceptancePolicy(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "PUT") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var policy models.AcceptancePolicy
+	err = json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = acceptance.Validate(policy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.SetAcceptancePolicy(db, rid, policy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Acceptance policy updated successfully"})
+}
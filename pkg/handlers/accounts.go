@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/mailer"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// emailVerificationTTL is how long an email verification link stays valid
+const emailVerificationTTL = 24 * time.Hour
+
+// accountMailer sends the account emails, replaced with SetMailer
+var accountMailer mailer.Mailer = mailer.LogMailer{}
+
+// SetMailer sets the mailer account emails are sent with
+func SetMailer(m mailer.Mailer) {
+	accountMailer = m
+}
+
+// emailVerificationRequired tells whether EMAIL_VERIFICATION requires an email verified before the first login
+func emailVerificationRequired() bool {
+	return strings.ToLower(os.Getenv("EMAIL_VERIFICATION")) == "required"
+}
+
+// publicURL returns the address the server is reached at, used in the links it sends
+func publicURL() string {
+	if url := os.Getenv("PUBLIC_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:3001"
+}
+
+// Register handles the creation of an account. Users who give an email are sent a verification link, which must be
+// followed before logging in when EMAIL_VERIFICATION is required.
+func Register(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "content-type", "POST") {
+		return
+	}
+
+	var registration struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&registration)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err = auth.ValidateUsername(registration.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = auth.ValidatePassword(registration.Password, registration.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	registration.Email = strings.TrimSpace(registration.Email)
+	if registration.Email == "" && emailVerificationRequired() {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+	if registration.Email != "" {
+		if err = auth.ValidateEmail(registration.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	passwordHash, err := auth.LoadPasswordPolicy().HashPassword(registration.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	// Users listed in ADMIN_USERNAMES are made admins once, when they register, so revoking the role later sticks
+	roles := auth.DefaultRoles()
+	var grant *models.AuditEntry
+	if listedAdmin(registration.Username) {
+		roles = append(roles, auth.RoleAdmin)
+		audit := adminAudit("", auditGrantRole, "", map[string]interface{}{"role": auth.RoleAdmin, "reason": "listed in ADMIN_USERNAMES"})
+		grant = &audit
+	}
+
+	uid, err := pkg.RegisterUser(db, auth.HashString(registration.Username), passwordHash, registration.Email, roles, grant)
+	if err != nil {
+		if err.Error() == "username already taken" {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	verificationSent := false
+	if registration.Email != "" {
+		if err = sendEmailVerification(r, uid, registration.Email); err != nil {
+			log.Printf("Failed to send email verification to user %s: %v", uid, err)
+		} else {
+			verificationSent = true
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "User registered", "uid": uid, "verificationSent": verificationSent})
+}
+
+// sendEmailVerification mails a user a link to verify its email
+func sendEmailVerification(r *http.Request, uid string, email string) error {
+	token, tokenHash, err := auth.GenerateVerificationToken()
+	if err != nil {
+		return err
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.InsertEmailVerification(db, uid, tokenHash, time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return err
+	}
+
+	return accountMailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your Cycloud email",
+		Body:    "Follow this link within 24 hours to verify your email:\n" + publicURL() + "/verify-email/" + token,
+	})
+}
+
+// VerifyEmail handles a user following the link of its verification email.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "content-type", "GET, POST") {
+		return
+	}
+
+	token := mux.Vars(r)["token"]
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err := pkg.VerifyEmail(db, auth.HashString(token))
+	if err != nil {
+		if err.Error() == "invalid or expired verification token" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Email verified"})
+}
+
+// GetJWKS handles the request for the public keys JWTs are signed with, so other services can verify them
+func GetJWKS(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "", "GET") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(auth.JWKS())
+}
@@ -0,0 +1,639 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	"github.com/gunrgnhsr/Cycloud/pkg/bidding"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const (
+	defaultArchiveRetentionDays = 365  // used when ARCHIVE_RETENTION_DAYS is not set
+	maxAdminReasonLength        = 1000 // bounds the reason admins give for their actions
+)
+
+// Actions recorded in the audit log
+const (
+	auditPurgeResources      = "purge_resources"
+	auditResolveDispute      = "resolve_dispute"
+	auditGrantRole           = "grant_role"
+	auditRevokeRole          = "revoke_role"
+	auditSuspendUser         = "suspend_user"
+	auditUnsuspendUser       = "unsuspend_user"
+	auditAdjustCredits       = "adjust_credits"
+	auditEndLease            = "end_lease"
+	auditCancelAuction       = "cancel_auction"
+	auditRemoveResource      = "remove_resource"
+	auditRequireSupplierTOTP = "require_supplier_totp"
+)
+
+// adminAudit returns the audit log entry of an action taken by an admin, it is recorded along with the action so an
+// action that cannot be audited fails
+func adminAudit(adminUID string, action string, target string, details map[string]interface{}) models.AuditEntry {
+	return models.AuditEntry{Admin: adminUID, Action: action, Target: target, Details: details}
+}
+
+// decodeAdminReason reads the reason an admin gives for an action from the request body
+func decodeAdminReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" || len(body.Reason) > maxAdminReasonLength {
+		http.Error(w, "A reason of at most "+strconv.Itoa(maxAdminReasonLength)+" characters is required", http.StatusBadRequest)
+		return "", false
+	}
+	return body.Reason, true
+}
+
+// archiveRetentionDays returns the minimum number of days archived resources are kept
+func archiveRetentionDays() int {
+	return int(env.Number("ARCHIVE_RETENTION_DAYS", defaultArchiveRetentionDays, env.Whole))
+}
+
+// PurgeArchivedResources handles the permanent deletion of resources archived for longer than the retention policy.
+func PurgeArchivedResources(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionPurgeResources)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	// The retention may be extended per request but never shortened below the policy
+	retentionDays := archiveRetentionDays()
+	if value := r.URL.Query().Get("retentionDays"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < retentionDays {
+			http.Error(w, "Invalid retentionDays, the minimum retention is "+strconv.Itoa(retentionDays)+" days", http.StatusBadRequest)
+			return
+		}
+		retentionDays = days
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	audit := adminAudit(adminUID, auditPurgeResources, "", map[string]interface{}{"retentionDays": retentionDays})
+	purged, err := pkg.PurgeArchivedResources(db, time.Now().AddDate(0, 0, -retentionDays), audit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Archived resources purged", "purged": purged, "retentionDays": retentionDays})
+}
+
+// listedAdmin reports whether ADMIN_USERNAMES lists the username, so a new deployment has admins who can grant roles
+// to others. Usernames are listed rather than uids, which are handed out again when the tables are recreated.
+func listedAdmin(username string) bool {
+	for _, listed := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		if strings.TrimSpace(listed) == username {
+			return true
+		}
+	}
+	return false
+}
+
+// userRoleFromRequest checks that the request may manage roles and returns the admin making it and the user and role
+// of its path
+func userRoleFromRequest(w http.ResponseWriter, r *http.Request) (string, string, string, bool) {
+	adminUID, err := checkPermission(r, auth.PermissionManageRoles)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return "", "", "", false
+	}
+
+	uid := mux.Vars(r)["uid"]
+	role := mux.Vars(r)["role"]
+	if uid == "" || role == "" {
+		http.Error(w, "Missing user ID or role", http.StatusBadRequest)
+		return "", "", "", false
+	}
+	if err = auth.ValidateRole(role); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", "", false
+	}
+	return adminUID, uid, role, true
+}
+
+// GrantRole handles an admin giving a role to a user, it takes effect when the user's access token is refreshed.
+func GrantRole(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "POST") {
+		return
+	}
+
+	adminUID, uid, role, ok := userRoleFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err := pkg.GrantRole(db, uid, role, adminAudit(adminUID, auditGrantRole, uid, map[string]interface{}{"role": role}))
+	if err != nil {
+		if err.Error() == "user not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Role granted"})
+}
+
+// RevokeRole handles an admin taking a role away from a user, it takes effect when the user's access token is
+// refreshed.
+func RevokeRole(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	adminUID, uid, role, ok := userRoleFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err := pkg.RevokeRole(db, uid, role, adminAudit(adminUID, auditRevokeRole, uid, map[string]interface{}{"role": role}))
+	if err != nil {
+		if err.Error() == "user does not hold role" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Role revoked"})
+}
+
+// ListUsers handles the listing of users to admins. The username query parameter is matched exactly as usernames are
+// only stored hashed, email matches part of the email and role and suspended narrow the list further.
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkPermission(r, auth.PermissionManageUsers)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.UserFilter{Email: query.Get("email"), Role: query.Get("role")}
+	if username := query.Get("username"); username != "" {
+		filter.Username = auth.HashString(username)
+	}
+	if value := query.Get("suspended"); value != "" {
+		suspended, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid suspended", http.StatusBadRequest)
+			return
+		}
+		filter.Suspended = &suspended
+	}
+	for name, target := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if value := query.Get(name); value != "" {
+			*target, err = strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	users, err := pkg.ListUsers(db, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// SuspendUser handles an admin suspending a user, its sessions are revoked and it cannot sign in until unsuspended.
+func SuspendUser(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionManageUsers)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	uid := mux.Vars(r)["uid"]
+	if uid == "" {
+		http.Error(w, "Missing user ID", http.StatusBadRequest)
+		return
+	}
+	if uid == adminUID {
+		http.Error(w, "admins cannot suspend themselves", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.SuspendUser(db, uid, reason, adminAudit(adminUID, auditSuspendUser, uid, map[string]interface{}{"reason": reason}))
+	if err != nil {
+		switch err.Error() {
+		case "user not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "user already suspended":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "User suspended"})
+}
+
+// UnsuspendUser handles an admin lifting the suspension of a user.
+func UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionManageUsers)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	uid := mux.Vars(r)["uid"]
+	if uid == "" {
+		http.Error(w, "Missing user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.UnsuspendUser(db, uid, adminAudit(adminUID, auditUnsuspendUser, uid, nil))
+	if err != nil {
+		switch err.Error() {
+		case "user not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "user is not suspended":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "User unsuspended"})
+}
+
+// AdjustCredits handles an admin crediting or debiting the wallet of a user, the movement is ledgered with the
+// admin's reason. Admins cannot adjust their own wallet.
+func AdjustCredits(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionManageUsers)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	uid := mux.Vars(r)["uid"]
+	if uid == "" {
+		http.Error(w, "Missing user ID", http.StatusBadRequest)
+		return
+	}
+	if uid == adminUID {
+		http.Error(w, "admins cannot adjust their own credits", http.StatusForbidden)
+		return
+	}
+
+	var adjustment struct {
+		Amount float64 `json:"amount"` // negative to debit
+		Reason string  `json:"reason"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&adjustment)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	adjustment.Reason = strings.TrimSpace(adjustment.Reason)
+	if adjustment.Amount == 0 {
+		http.Error(w, "Amount must not be zero", http.StatusBadRequest)
+		return
+	}
+	if adjustment.Reason == "" || len(adjustment.Reason) > maxAdminReasonLength {
+		http.Error(w, "A reason of at most "+strconv.Itoa(maxAdminReasonLength)+" characters is required", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	audit := adminAudit(adminUID, auditAdjustCredits, uid, map[string]interface{}{"amount": adjustment.Amount, "reason": adjustment.Reason})
+	credits, err := pkg.AdjustCredits(db, uid, adjustment.Amount, adjustment.Reason, audit)
+	if err != nil {
+		switch err.Error() {
+		case "user not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "insufficient credits":
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Credits adjusted", "credits": credits})
+}
+
+// ForceEndLease handles an admin terminating a running lease, its renter is not charged and its resource is freed.
+func ForceEndLease(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionManageLeases)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	bidId := mux.Vars(r)["bidId"]
+	if bidId == "" {
+		http.Error(w, "Missing bid ID", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	rid, err := pkg.ForceEndLease(db, bidId, adminAudit(adminUID, auditEndLease, bidId, map[string]interface{}{"reason": reason}))
+	if err != nil {
+		if err.Error() == "lease is not running" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bidding.EndLease(rid, bidId)
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Lease ended"})
+}
+
+// CancelAuction handles an admin cancelling the open auction of a resource, all of its pending bids are rejected.
+func CancelAuction(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionManageLeases)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the max bid first, its bidder is told right away
+	cancelErr := bidding.CancelAuction(rid)
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	rejected, err := pkg.RejectPendingBids(db, rid, adminAudit(adminUID, auditCancelAuction, rid, map[string]interface{}{"reason": reason}))
+	if err != nil {
+		switch {
+		case err.Error() != "no pending bids":
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case cancelErr != nil:
+			http.Error(w, cancelErr.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Auction cancelled", "rejectedBids": rejected})
+}
+
+// RemoveResource handles an admin taking a fraudulent resource off the exchange: its running lease is ended without
+// charge, its pending bids are rejected and it is archived.
+func RemoveResource(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionModerateResources)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	reason, ok := decodeAdminReason(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	endedBid, err := pkg.RemoveResource(db, rid, adminAudit(adminUID, auditRemoveResource, rid, map[string]interface{}{"reason": reason}))
+	if err != nil {
+		switch err.Error() {
+		case "resource not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "resource is archived":
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	bidding.CancelAuction(rid)
+	if endedBid != "" {
+		bidding.EndLease(rid, endedBid)
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Resource removed"})
+}
+
+// GetSystemStats handles the retrieval of the state of the exchange by admins.
+func GetSystemStats(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkPermission(r, auth.PermissionViewStats)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	stats, err := pkg.GetSystemStats(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats.ActiveAuctions = bidding.ActiveAuctions()
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetAuditLog handles the retrieval of the latest admin actions, limit defaults to 100.
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkPermission(r, auth.PermissionViewStats)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	entries, err := pkg.GetAuditLog(db, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GetLoginEvents handles the retrieval of the latest failed logins and lockouts, optionally of one address.
+func GetLoginEvents(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkPermission(r, auth.PermissionViewStats)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	events, err := pkg.GetLoginEvents(db, r.URL.Query().Get("ip"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+)
+
+// adminRequest returns a request of the admin signed in as uid 7 to a route with the path variables
+func adminRequest(t *testing.T, method string, path string, body string, vars map[string]string) *http.Request {
+	return mux.SetURLVars(sessionRequest(t, method, path, body, []string{auth.RoleAdmin}), vars)
+}
+
+func TestListedUsernameIsRegisteredAsAdmin(t *testing.T) {
+	t.Setenv("ADMIN_USERNAMES", "root, operator")
+	t.Setenv("DEFAULT_ROLES", "renter")
+	credentials := `{"username": "operator", "password": "correct-Horse-battery-9"}`
+
+	db, fake := newFakeDB(t,
+		row("(username, password, email)", "7"),
+		exec("INSERT INTO .wallets", 1),
+		exec("INSERT INTO .user_roles", 1),
+		exec("INSERT INTO .user_roles", 1),
+		exec("INSERT INTO .admin_audit", 1),
+	)
+	recorder := httptest.NewRecorder()
+	Register(recorder, withDB(httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(credentials)), db))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d %s", recorder.Code, recorder.Body)
+	}
+	if args, _ := fake.args("INSERT INTO .user_roles"); args[1] != auth.RoleAdmin {
+		t.Errorf("Expected the admin role to be stored, got %v", args)
+	}
+	if args, _ := fake.args("INSERT INTO .admin_audit"); args[0] != "7" || args[1] != auditGrantRole || args[2] != "7" {
+		t.Errorf("Expected the grant to be audited, got %v", args)
+	}
+}
+
+func TestUnlistedUsernameIsNotRegisteredAsAdmin(t *testing.T) {
+	t.Setenv("ADMIN_USERNAMES", "operator")
+	t.Setenv("DEFAULT_ROLES", "renter")
+	credentials := `{"username": "operator2", "password": "correct-Horse-battery-9"}`
+
+	db, fake := newFakeDB(t,
+		row("(username, password, email)", "7"),
+		exec("INSERT INTO .wallets", 1),
+		exec("INSERT INTO .user_roles", 1),
+	)
+	recorder := httptest.NewRecorder()
+	Register(recorder, withDB(httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(credentials)), db))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Failed to register user: %d %s", recorder.Code, recorder.Body)
+	}
+	if args, _ := fake.args("INSERT INTO .user_roles"); args[1] != auth.RoleRenter || fake.ran("INSERT INTO .admin_audit") {
+		t.Errorf("Expected only the renter role, got %v", args)
+	}
+}
+
+func TestRevokedAdminRoleIsNotGrantedAgain(t *testing.T) {
+	t.Setenv("ADMIN_USERNAMES", "operator")
+	db, fake := newFakeDB(t, rows("SELECT role FROM", "renter"))
+
+	roles, _, err := sessionRoles(db, "7")
+	if err != nil {
+		t.Fatalf("Failed to get roles: %v", err)
+	}
+	if len(roles) != 1 || roles[0] != auth.RoleRenter || fake.ran("INSERT INTO .user_roles") {
+		t.Errorf("Expected only the renter role, got %v", roles)
+	}
+}
+
+func TestAdjustCreditsRefusesTheAdminsOwnWallet(t *testing.T) {
+	db, fake := newFakeDB(t, sessionQuery("7"))
+	request := adminRequest(t, http.MethodPost, "/admin/adjust-credits/7", `{"amount": 500, "reason": "bonus"}`, map[string]string{"uid": "7"})
+
+	recorder := httptest.NewRecorder()
+	AdjustCredits(recorder, withDB(request, db))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected an admin adjusting its own credits to be refused, got %d", recorder.Code)
+	}
+	if fake.ran(".wallets") {
+		t.Errorf("Expected the wallet to be left alone")
+	}
+}
+
+func TestAdjustCreditsIsAuditedWithTheAdjustment(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT credits FROM .wallets", 100.0),
+		row("UPDATE .wallets", 150.0),
+		exec("INSERT INTO .ledger", 1),
+		exec("INSERT INTO .admin_audit", 1),
+	)
+	request := adminRequest(t, http.MethodPost, "/admin/adjust-credits/8", `{"amount": 50, "reason": "refund of outage"}`, map[string]string{"uid": "8"})
+
+	recorder := httptest.NewRecorder()
+	AdjustCredits(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the credits to be adjusted, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	args, _ := fake.args("INSERT INTO .admin_audit")
+	var details map[string]interface{}
+	json.Unmarshal(args[3].([]byte), &details)
+	if args[0] != "7" || args[1] != auditAdjustCredits || args[2] != "8" || details["credits"] != 150.0 || details["reason"] != "refund of outage" {
+		t.Errorf("Unexpected audit entry %v %v", args[:3], details)
+	}
+}
+
+func TestAdminActionFailsWhenItCannotBeAudited(t *testing.T) {
+	db, _ := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT suspendedAt IS NOT NULL", false),
+		exec("UPDATE .users", 1),
+		exec("UPDATE .sessions", 1),
+		fakeQuery{match: "INSERT INTO .admin_audit", err: errors.New("connection reset")},
+	)
+	request := adminRequest(t, http.MethodPost, "/admin/suspend-user/8", `{"reason": "fraud"}`, map[string]string{"uid": "8"})
+
+	recorder := httptest.NewRecorder()
+	SuspendUser(recorder, withDB(request, db))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the suspension to fail without its audit entry, got %d", recorder.Code)
+	}
+}
+
+func TestCancelAuctionWithoutPendingBids(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		exec("UPDATE .bids", 0),
+	)
+	request := adminRequest(t, http.MethodPost, "/admin/cancel-auction/4", `{"reason": "mispriced"}`, map[string]string{"rid": "4"})
+
+	recorder := httptest.NewRecorder()
+	CancelAuction(recorder, withDB(request, db))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected no auction to cancel, got %d", recorder.Code)
+	}
+	if fake.ran("INSERT INTO .admin_audit") {
+		t.Errorf("Expected nothing to be audited")
+	}
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	"github.com/gunrgnhsr/Cycloud/pkg/bidding"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+	"github.com/gunrgnhsr/Cycloud/pkg/hardware"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/settlement"
+)
+
+const (
+	defaultHeartbeatInterval = 30 * time.Second // used when AGENT_HEARTBEAT_INTERVAL_SECONDS is not set
+	defaultMissedHeartbeats  = 3                // used when AGENT_MISSED_HEARTBEATS is not set
+)
+
+// heartbeatInterval returns how often supplier agents are asked to send a heartbeat
+func heartbeatInterval() time.Duration {
+	return env.Duration("AGENT_HEARTBEAT_INTERVAL_SECONDS", defaultHeartbeatInterval, time.Second, env.Count)
+}
+
+// heartbeatTimeout returns how long a resource may go without a heartbeat before it is considered offline
+func heartbeatTimeout() time.Duration {
+	missed := env.Number("AGENT_MISSED_HEARTBEATS", defaultMissedHeartbeats, env.Count)
+	return time.Duration(missed) * heartbeatInterval()
+}
+
+// checkAPIKeyAuthorization checks the API key of a supplier agent's request and returns the uid of the key's owner
+func checkAPIKeyAuthorization(r *http.Request) (string, error) {
+	return checkAPIKeyPermission(r, auth.PermissionRunAgents)
+}
+
+// AddAPIKey handles the creation of an API key for machine clients and supplier agents, the key is only returned
+// once. Its scopes must be permissions of the user's roles, a key without scopes may only run supplier agents. Keys
+// are created from a signed in session only.
+func AddAPIKey(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		http.Error(w, "Missing api key name", http.StatusBadRequest)
+		return
+	}
+	if len(body.Scopes) == 0 {
+		body.Scopes = auth.DefaultAPIKeyScopes
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	// A key cannot do more than the session of its owner
+	roles, _, err := sessionRoles(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = auth.ValidateScopes(body.Scopes, roles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, keyHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate api key", http.StatusInternalServerError)
+		return
+	}
+
+	kid, err := pkg.InsertAPIKey(db, uid, body.Name, keyHash, body.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "API key created, store it now as it will not be shown again", "kid": kid, "key": key, "scopes": body.Scopes})
+}
+
+// GetAPIKeys handles the listing of the user's API keys with their scopes and when they were last used, the keys
+// themselves are never shown again.
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	keys, err := pkg.GetUserAPIKeys(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey handles the revocation of one of the user's API keys from a signed in session, it stops working right
+// away.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	kid := mux.Vars(r)["kid"]
+	if kid == "" {
+		http.Error(w, "Missing api key ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.RevokeAPIKey(db, uid, kid)
+	if err != nil {
+		if err.Error() == "api key not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "API key revoked"})
+}
+
+// withReportedHardware returns the resource with the hardware facts measured by an agent
+func withReportedHardware(resource models.Resource, reported models.Resource) models.Resource {
+	resource.CPUCores = reported.CPUCores
+	resource.Memory = reported.Memory
+	resource.Storage = reported.Storage
+	resource.CPUArch = reported.CPUArch
+	resource.CPUModel = reported.CPUModel
+	resource.OS = reported.OS
+	return resource
+}
+
+// RegisterAgent handles the registration of a supplier agent. Without a resource ID a new resource is created from
+// the reported spec, otherwise the measured hardware of the existing resource is refreshed and the rest of its spec
+// is kept as edited by the supplier.
+func RegisterAgent(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "X-API-Key, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAPIKeyAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var registration struct {
+		RID      string          `json:"rid"`
+		Resource models.Resource `json:"resource"`
+		AgentKey string          `json:"agentKey"` // public key the agent signs its hardware reports with
+	}
+	err = json.NewDecoder(r.Body).Decode(&registration)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	_, err = hardware.ParseAgentKey(registration.AgentKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	rid := registration.RID
+	if rid == "" {
+		resource := hardware.Normalize(registration.Resource)
+		resource.Available = false
+		resource.Computing = false
+		err = hardware.Validate(resource)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rid, err = pkg.InsertAgentResource(db, resource, uid, registration.AgentKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		err = checkThatResourceBelongsToUser(r, uid, rid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		current, err := pkg.GetResourceByID(db, rid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if current.Status == models.ResourceArchived {
+			http.Error(w, "resource is archived", http.StatusPreconditionFailed)
+			return
+		}
+
+		resource := hardware.Normalize(withReportedHardware(current.Resource, registration.Resource))
+		err = hardware.Validate(resource)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The hardware can only be refreshed between leases, a running lease keeps the spec it was accepted with
+		if hardware.SpecChanged(current.Resource, resource) && !current.Computing {
+			_, err = pkg.UpdateResource(db, rid, uid, resource, true)
+			if err != nil && err.Error() != "resource is currently computing" && err.Error() != "resource is under auction" {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_, _, err = pkg.RecordHeartbeat(db, rid, uid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = pkg.SetAgentKey(db, rid, registration.AgentKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Agent registered", "rid": rid, "heartbeatInterval": heartbeatInterval().Seconds()})
+}
+
+// setAgentResourceAvailability lists or unlists a resource on behalf of its agent the way the supplier would
+func setAgentResourceAvailability(db *sql.DB, rid string, available bool) error {
+	err := pkg.SetResourceAvailability(db, rid, available)
+	if err != nil {
+		return err
+	}
+
+	// Pool nodes are leased through their pool, they never go through an auction
+	pid, err := pkg.GetResourcePool(db, rid)
+	if err != nil || pid != "" {
+		return err
+	}
+
+	if !available {
+		err = pkg.UpdateBidsForResourceInavailablity(db, rid)
+		if err != nil {
+			return err
+		}
+		bidding.MakeResourceUnavailable(rid)
+	} else {
+		closeAuctionInBackground(db, rid)
+	}
+	return nil
+}
+
+// AgentHeartbeat handles the periodic heartbeat of a supplier agent. The agent may ask for its resource to be listed
+// or unlisted, which only takes effect between leases.
+func AgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "X-API-Key, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAPIKeyAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	var heartbeat struct {
+		Available *bool `json:"available"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&heartbeat)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	available, computing, err := pkg.RecordHeartbeat(db, rid, uid)
+	if err != nil {
+		if err.Error() == "resource not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if heartbeat.Available != nil && *heartbeat.Available != available && !computing {
+		err = setAgentResourceAvailability(db, rid, *heartbeat.Available)
+		if err == nil {
+			available = *heartbeat.Available
+		} else if err.Error() != "resource is currently computing" && err.Error() != "resource is under maintenance" {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"available": available, "computing": computing, "heartbeatInterval": heartbeatInterval().Seconds()})
+}
+
+// expireOfflineAgents unlists resources whose agent missed its heartbeats and fails the leases running on them
+func expireOfflineAgents(db *sql.DB) {
+	unlisted, leases, err := pkg.ExpireAgentResources(db, time.Now().Add(-heartbeatTimeout()))
+	if err != nil {
+		log.Printf("Failed to expire offline agents: %v", err)
+		return
+	}
+
+	for _, rid := range unlisted {
+		bidding.MakeResourceUnavailable(rid)
+	}
+
+	noShowPolicy := settlement.LoadNoShowPolicy()
+	for _, bid := range leases {
+		err = pkg.SettleNoShow(db, bid.BID, noShowPolicy.Settle(settlement.SupplierOffline, bid))
+		if err != nil && err.Error() != "bid already settled" {
+			log.Printf("Failed to fail lease of bid %s on offline resource %s: %v", bid.BID, bid.RID, err)
+		}
+	}
+}
+
+// MonitorAgentHeartbeats periodically takes resources whose agent went offline off the market. It never returns.
+func MonitorAgentHeartbeats(db *sql.DB) {
+	ticker := time.NewTicker(heartbeatInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		expireOfflineAgents(db)
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+)
+
+// sessionRequest returns a request signed in as uid 7 with a session of the given roles
+func sessionRequest(t *testing.T, method string, path string, body string, roles []string) *http.Request {
+	token, err := auth.GenerateJWT("testuser", roles, "7", "1")
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", token)
+	return request
+}
+
+// sessionQuery answers the lookup of the session of sessionRequest
+func sessionQuery(uid string) fakeQuery {
+	return row("SET lastUsedAt = CURRENT_TIMESTAMP", uid)
+}
+
+func TestAddAPIKeyRefusesAPIKeys(t *testing.T) {
+	db, _ := newFakeDB(t)
+	request := httptest.NewRequest(http.MethodPost, "/add-api-key", strings.NewReader(`{"name": "escalate", "scopes": ["admin:users"]}`))
+	request.Header.Set("X-API-Key", auth.APIKeyPrefix+"key")
+
+	recorder := httptest.NewRecorder()
+	AddAPIKey(recorder, withDB(request, db))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected an API key to be refused with %d, got %d", http.StatusForbidden, recorder.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodDelete, "/revoke-api-key/3", nil)
+	request.Header.Set("Authorization", auth.APIKeyPrefix+"key")
+	recorder = httptest.NewRecorder()
+	RevokeAPIKey(recorder, withDB(request, db))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected an API key to be refused with %d, got %d", http.StatusForbidden, recorder.Code)
+	}
+}
+
+func TestAddAPIKeyFromSession(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		rows("SELECT role FROM", "renter", "supplier"),
+		noRows("SELECT value FROM"),
+		row("INSERT INTO .api_keys", "3"),
+	)
+	request := sessionRequest(t, http.MethodPost, "/add-api-key", `{"name": "agent"}`, []string{auth.RoleSupplier})
+
+	recorder := httptest.NewRecorder()
+	AddAPIKey(recorder, withDB(request, db))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected the key to be created, got %d: %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Key    string   `json:"key"`
+		Scopes []string `json:"scopes"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if !strings.HasPrefix(response.Key, auth.APIKeyPrefix) || len(response.Scopes) != 1 || response.Scopes[0] != string(auth.PermissionRunAgents) {
+		t.Errorf("Expected an agent key, got %+v", response)
+	}
+	if !fake.ran("INSERT INTO .api_keys") {
+		t.Errorf("Expected the key to be stored")
+	}
+}
+
+func TestAddAPIKeyRefusesScopesBeyondRoles(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		rows("SELECT role FROM", "renter"),
+	)
+	request := sessionRequest(t, http.MethodPost, "/add-api-key", `{"name": "admin", "scopes": ["admin:users"]}`, []string{auth.RoleRenter})
+
+	recorder := httptest.NewRecorder()
+	AddAPIKey(recorder, withDB(request, db))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected scopes beyond the roles to be refused, got %d", recorder.Code)
+	}
+	if fake.ran("INSERT INTO .api_keys") {
+		t.Errorf("Expected no key to be stored")
+	}
+}
+
+func TestAPIKeyOfSupplierWithoutRequiredTOTPIsRefused(t *testing.T) {
+	db, fake := newFakeDB(t,
+		row("UPDATE .api_keys", "7", "{agents:run}", "{renter,supplier}"),
+		row("SELECT value FROM", "true"),
+		row("SELECT totpSecret", nil, false, int64(0)),
+	)
+	request := httptest.NewRequest(http.MethodPost, "/agent-heartbeat/4", strings.NewReader(`{"available": true}`))
+	request.Header.Set("X-API-Key", auth.APIKeyPrefix+"key")
+
+	recorder := httptest.NewRecorder()
+	AgentHeartbeat(recorder, withDB(request, db))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the key to be refused while the supplier has no second factor, got %d", recorder.Code)
+	}
+	if !fake.ran("SELECT totpSecret") {
+		t.Errorf("Expected the second factor of the supplier to be checked")
+	}
+}
+
+func TestHeartbeatSetsTheRequestedAvailability(t *testing.T) {
+	db, fake := newFakeDB(t,
+		row("UPDATE .api_keys", "7", "{agents:run}", "{renter,supplier}"),
+		row("SELECT value FROM", "false"),
+		row("RETURNING available, computing", true, false),
+		exec("SET available = $2", 1),
+		noRows("SELECT pid FROM"),
+		exec("UPDATE .bids SET status = 'rejected'", 0),
+	)
+	request := httptest.NewRequest(http.MethodPost, "/agent-heartbeat/4", strings.NewReader(`{"available": false}`))
+	request.Header.Set("X-API-Key", auth.APIKeyPrefix+"key")
+	request = mux.SetURLVars(request, map[string]string{"rid": "4"})
+
+	recorder := httptest.NewRecorder()
+	AgentHeartbeat(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the heartbeat to be accepted, got %d: %s", recorder.Code, recorder.Body)
+	}
+	args, _ := fake.args("SET available = $2")
+	if len(args) != 2 || args[1] != false {
+		t.Errorf("Expected the resource to be unlisted explicitly, got %v", args)
+	}
+	var response struct {
+		Available bool `json:"available"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if response.Available {
+		t.Errorf("Expected the heartbeat to report the resource as unlisted")
+	}
+}
+
+func TestHeartbeatKeepsAvailabilityOfResourceUnderMaintenance(t *testing.T) {
+	db, _ := newFakeDB(t,
+		row("UPDATE .api_keys", "7", "{agents:run}", "{renter,supplier}"),
+		row("SELECT value FROM", "false"),
+		row("RETURNING available, computing", false, false),
+		exec("SET available = $2", 0),
+		row("SELECT status FROM", "maintenance"),
+	)
+	request := httptest.NewRequest(http.MethodPost, "/agent-heartbeat/4", strings.NewReader(`{"available": true}`))
+	request.Header.Set("X-API-Key", auth.APIKeyPrefix+"key")
+	request = mux.SetURLVars(request, map[string]string{"rid": "4"})
+
+	recorder := httptest.NewRecorder()
+	AgentHeartbeat(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the heartbeat to be accepted, got %d: %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Available bool `json:"available"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if response.Available {
+		t.Errorf("Expected a resource under maintenance to stay unlisted")
+	}
+}
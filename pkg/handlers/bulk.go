@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/bidding"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/hardware"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const (
+	maxBulkItems     = 500 // items per bulk request
+	maxBulkCreations = 500 // resources created per bulk request
+)
+
+// Integer spec columns of a bulk CSV payload
+var bulkCSVIntColumns = []string{"cpuCores", "memory", "storage", "bandwidth", "egressLimit"}
+
+// Text spec columns of a bulk CSV payload
+var bulkCSVTextColumns = []string{"gpu", "region", "zone", "cpuArch", "cpuModel", "os", "cudaVersion"}
+
+// parseBulkCSV reads bulk items from CSV with a header row. Spec columns left empty keep the template's value,
+// tags are separated by semicolons and a single GPU type is given by the gpuModel, gpuCount and gpuVram columns.
+func parseBulkCSV(body io.Reader) ([]models.BulkResourceItem, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.New("invalid csv: " + err.Error())
+	}
+	if len(records) < 2 {
+		return nil, errors.New("csv must have a header row and at least one item")
+	}
+
+	header := map[string]int{}
+	for i, column := range records[0] {
+		header[strings.TrimSpace(column)] = i
+	}
+	if _, ok := header["action"]; !ok {
+		return nil, errors.New("csv is missing the action column")
+	}
+
+	items := []models.BulkResourceItem{}
+	for line, record := range records[1:] {
+		get := func(column string) string {
+			if i, ok := header[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		lineError := func(message string) error {
+			return fmt.Errorf("csv line %d: %s", line+2, message)
+		}
+
+		item := models.BulkResourceItem{Action: get("action"), RID: get("rid"), Template: get("template")}
+		if value := get("count"); value != "" {
+			if item.Count, err = strconv.Atoi(value); err != nil {
+				return nil, lineError("invalid count")
+			}
+		}
+		if value := get("available"); value != "" {
+			available, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, lineError("invalid available")
+			}
+			item.Available = &available
+		}
+
+		spec := map[string]interface{}{}
+		for _, column := range bulkCSVIntColumns {
+			if value := get(column); value != "" {
+				if spec[column], err = strconv.Atoi(value); err != nil {
+					return nil, lineError("invalid " + column)
+				}
+			}
+		}
+		for _, column := range bulkCSVTextColumns {
+			if value := get(column); value != "" {
+				spec[column] = value
+			}
+		}
+		if value := get("costPerHour"); value != "" {
+			if spec["costPerHour"], err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, lineError("invalid costPerHour")
+			}
+		}
+		if value := get("tags"); value != "" {
+			spec["tags"] = strings.Split(value, ";")
+		}
+		if model := get("gpuModel"); model != "" {
+			gpu := models.GPU{Model: model, Count: 1}
+			if value := get("gpuCount"); value != "" {
+				if gpu.Count, err = strconv.Atoi(value); err != nil {
+					return nil, lineError("invalid gpuCount")
+				}
+			}
+			if value := get("gpuVram"); value != "" {
+				if gpu.VRAM, err = strconv.Atoi(value); err != nil {
+					return nil, lineError("invalid gpuVram")
+				}
+			}
+			spec["gpus"] = []models.GPU{gpu}
+		}
+		if len(spec) > 0 {
+			item.Resource, _ = json.Marshal(spec)
+		}
+
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// resolveBulkItems validates the items and resolves the spec of create actions from their template and overrides.
+// It returns one result per item, failing ones carry the error.
+func resolveBulkItems(items []models.BulkResourceItem, templates []models.ResourceTemplate) ([]models.BulkResourceItem, []models.BulkResourceResult, bool) {
+	templateSpecs := map[string][]byte{}
+	for _, template := range templates {
+		templateSpecs[template.Name], _ = json.Marshal(template.Resource)
+	}
+
+	valid := true
+	creations := 0
+	results := make([]models.BulkResourceResult, len(items))
+	for i := range items {
+		item := &items[i]
+		results[i] = models.BulkResourceResult{Index: i, Action: item.Action, Status: "ok"}
+
+		var err error
+		switch item.Action {
+		case models.BulkCreate:
+			if item.Count == 0 {
+				item.Count = 1
+			}
+			creations += item.Count
+			err = resolveBulkSpec(item, templateSpecs)
+			if err == nil && (item.Count < 0 || creations > maxBulkCreations) {
+				err = fmt.Errorf("count must be positive and at most %d resources may be created per request", maxBulkCreations)
+			}
+		case models.BulkSetAvailability:
+			if item.RID == "" || item.Available == nil {
+				err = errors.New("rid and available are required")
+			}
+		case models.BulkRetire:
+			if item.RID == "" {
+				err = errors.New("rid is required")
+			}
+		default:
+			err = errors.New("unknown action, expected create, set-availability or retire")
+		}
+
+		if err != nil {
+			valid = false
+			results[i].Status = "error"
+			results[i].Error = err.Error()
+		}
+	}
+	return items, results, valid
+}
+
+// resolveBulkSpec builds, normalizes and validates the spec of a create action
+func resolveBulkSpec(item *models.BulkResourceItem, templateSpecs map[string][]byte) error {
+	var spec models.Resource
+	if item.Template != "" {
+		templateSpec, ok := templateSpecs[item.Template]
+		if !ok {
+			return errors.New("unknown template " + item.Template)
+		}
+		if err := json.Unmarshal(templateSpec, &spec); err != nil {
+			return errors.New("invalid template " + item.Template)
+		}
+	}
+	if len(item.Resource) > 0 {
+		if err := json.Unmarshal(item.Resource, &spec); err != nil {
+			return errors.New("invalid resource spec")
+		}
+	}
+	if item.Template == "" && len(item.Resource) == 0 {
+		return errors.New("template or resource is required")
+	}
+
+	spec.Available = false
+	spec.Computing = false
+	spec = hardware.Normalize(spec)
+	if err := hardware.Validate(spec); err != nil {
+		return err
+	}
+	item.Spec = spec
+	return nil
+}
+
+// closeAuctionInBackground closes the auction of a resource made available without a supplier stream attached,
+// the renter's stream drives the lease once the auction is closed
+func closeAuctionInBackground(db *sql.DB, rid string) {
+	go func() {
+		time.Sleep(auctionWindow)
+		_, err := bidding.CheckBidsForResource(rid)
+		if err != nil {
+			// No bids for the resource, make it unavailable again
+			if err = pkg.SetResourceAvailability(db, rid, false); err != nil {
+				log.Printf("Failed to make resource %s unavailable: %v", rid, err)
+			}
+		}
+	}()
+}
+
+// BulkResources handles creating, changing the availability of and retiring many resources atomically.
+func BulkResources(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Parse the request body, either CSV or JSON
+	var items []models.BulkResourceItem
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		items, err = parseBulkCSV(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var request struct {
+			Items []models.BulkResourceItem `json:"items"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		items = request.Items
+	}
+
+	if len(items) == 0 || len(items) > maxBulkItems {
+		http.Error(w, fmt.Sprintf("A bulk request must have between 1 and %d items", maxBulkItems), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	templates, err := pkg.GetUserResourceTemplates(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items, results, valid := resolveBulkItems(items, templates)
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Bulk request is invalid", "results": results})
+		return
+	}
+
+	results, err = pkg.ApplyBulkResourceItems(db, uid, items)
+	if err != nil {
+		if results == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": err.Error(), "results": results})
+		return
+	}
+
+	// Keep the in-memory auctions in line with the committed changes
+	for _, item := range items {
+		if item.Action == models.BulkRetire || (item.Action == models.BulkSetAvailability && !*item.Available) {
+			bidding.MakeResourceUnavailable(item.RID)
+		} else if item.Action == models.BulkSetAvailability {
+			closeAuctionInBackground(db, item.RID)
+		}
+	}
+
+	// Return the per-item results
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Bulk request applied", "results": results})
+}
+
+// AddResourceTemplate handles saving a named resource template, an existing template with the same name is replaced.
+func AddResourceTemplate(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var template struct {
+		Name     string          `json:"name"`
+		Resource models.Resource `json:"resource"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		http.Error(w, "Missing template name", http.StatusBadRequest)
+		return
+	}
+
+	resource := hardware.Normalize(template.Resource)
+	resource.Available = false
+	resource.Computing = false
+	err = hardware.Validate(resource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	tid, err := pkg.InsertResourceTemplate(db, uid, template.Name, resource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Resource template saved successfully", "tid": tid})
+}
+
+// GetResourceTemplates handles the retrieval of the user's resource templates.
+func GetResourceTemplates(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	templates, err := pkg.GetUserResourceTemplates(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the templates
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(templates)
+}
+
+// DeleteResourceTemplate handles the deletion of a resource template by ID.
+func DeleteResourceTemplate(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	tid := mux.Vars(r)["tid"]
+	if tid == "" {
+		http.Error(w, "Missing template ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.DeleteResourceTemplate(db, uid, tid)
+	if err != nil {
+		if err.Error() == "resource template not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Resource template deleted successfully"})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestParseBulkCSV(t *testing.T) {
+	body := `action,rid,template,count,available,cpuCores,memory,tags,gpuModel,gpuCount,gpuVram
+create,,rack-a,10,,,,,,,
+create,,,,,8,32,spot;nvlink,A100,4,80
+set-availability,12,,,false,,,,,,
+retire,13,,,,,,,,,`
+
+	items, err := parseBulkCSV(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to parse csv: %v", err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("Expected 4 items, got %d", len(items))
+	}
+	if items[0].Template != "rack-a" || items[0].Count != 10 || len(items[0].Resource) != 0 {
+		t.Errorf("Unexpected template item %+v", items[0])
+	}
+	if items[2].Available == nil || *items[2].Available || items[2].RID != "12" {
+		t.Errorf("Unexpected availability item %+v", items[2])
+	}
+
+	resolved, results, valid := resolveBulkItems(items, []models.ResourceTemplate{{Name: "rack-a", Resource: models.Resource{CPUCores: 4, Memory: 16}}})
+	if !valid {
+		t.Fatalf("Expected items to be valid, got %+v", results)
+	}
+	if resolved[0].Spec.CPUCores != 4 {
+		t.Errorf("Expected template spec, got %+v", resolved[0].Spec)
+	}
+	spec := resolved[1].Spec
+	if spec.CPUCores != 8 || len(spec.Tags) != 2 || spec.GPU != "4x A100 80GB" {
+		t.Errorf("Unexpected spec %+v", spec)
+	}
+}
+
+func TestResolveBulkItemsReportsEveryError(t *testing.T) {
+	items := []models.BulkResourceItem{
+		{Action: models.BulkCreate, Template: "missing"},
+		{Action: models.BulkSetAvailability, RID: "1"},
+		{Action: models.BulkRetire, RID: "2"},
+		{Action: "reboot"},
+	}
+
+	_, results, valid := resolveBulkItems(items, nil)
+	if valid {
+		t.Fatalf("Expected items to be invalid")
+	}
+	statuses := []string{"error", "error", "ok", "error"}
+	for i, status := range statuses {
+		if results[i].Status != status {
+			t.Errorf("Expected item %d status %s, got %s (%s)", i, status, results[i].Status, results[i].Error)
+		}
+	}
+}
+
+func TestResolveBulkSpecRejectsCorruptTemplates(t *testing.T) {
+	item := models.BulkResourceItem{Action: models.BulkCreate, Template: "gpu-node", Resource: []byte(`{"cpu_cores": 8}`)}
+
+	err := resolveBulkSpec(&item, map[string][]byte{"gpu-node": []byte(`{"cpu_cores": `)})
+	if err == nil || err.Error() != "invalid template gpu-node" {
+		t.Errorf("Expected the corrupt template to be reported, got %v", err)
+	}
+}
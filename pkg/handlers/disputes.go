@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+// escrowReleaseInterval is how often the escrows of undisputed leases are released to their suppliers
+const escrowReleaseInterval = time.Minute
+
+// maxDisputeReasonLength bounds the reason given when opening a dispute
+const maxDisputeReasonLength = 2000
+
+// OpenDispute handles a party of a lease disputing it. The lease payment stays in escrow until an admin resolves it.
+func OpenDispute(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	bidId := mux.Vars(r)["bidId"]
+	if bidId == "" {
+		http.Error(w, "Missing bid ID", http.StatusBadRequest)
+		return
+	}
+
+	var dispute struct {
+		Reason string `json:"reason"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&dispute)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	dispute.Reason = strings.TrimSpace(dispute.Reason)
+	if dispute.Reason == "" || len(dispute.Reason) > maxDisputeReasonLength {
+		http.Error(w, "Invalid dispute reason", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	did, err := pkg.OpenDispute(db, bidId, uid, dispute.Reason)
+	if err != nil {
+		switch err.Error() {
+		case "bid not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "user is not a party of the lease":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "lease cannot be disputed", "dispute window is over", "lease already disputed":
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Dispute opened", "did": did})
+}
+
+// GetUserDisputes handles the retrieval of the disputes opened by or against the user.
+func GetUserDisputes(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	disputes, err := pkg.GetUserDisputes(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(disputes)
+}
+
+// GetDispute handles the retrieval of a dispute with the evidence recorded of its lease, for its parties and admins.
+func GetDispute(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	did := mux.Vars(r)["did"]
+	if did == "" {
+		http.Error(w, "Missing dispute ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	dispute, err := pkg.GetDispute(db, did)
+	if err != nil {
+		if err.Error() == "dispute not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if dispute.Opener != uid && dispute.Against != uid {
+		if _, err = tokenPermits(r, auth.PermissionResolveDisputes); err != nil {
+			http.Error(w, "dispute does not concern user", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+// GetOpenDisputes handles the retrieval of the disputes waiting for an admin.
+func GetOpenDisputes(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkPermission(r, auth.PermissionResolveDisputes)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	disputes, err := pkg.GetOpenDisputes(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(disputes)
+}
+
+// ResolveDispute handles an admin settling a dispute with a full, partial or no refund of the lease to its renter.
+func ResolveDispute(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionResolveDisputes)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	did := mux.Vars(r)["did"]
+	if did == "" {
+		http.Error(w, "Missing dispute ID", http.StatusBadRequest)
+		return
+	}
+
+	var resolution struct {
+		Refund     float64 `json:"refund"`
+		Upheld     bool    `json:"upheld"`
+		Resolution string  `json:"resolution"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&resolution)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	audit := adminAudit(adminUID, auditResolveDispute, did, map[string]interface{}{"refund": resolution.Refund, "upheld": resolution.Upheld})
+	err = pkg.ResolveDispute(db, did, resolution.Refund, resolution.Upheld, strings.TrimSpace(resolution.Resolution), audit)
+	if err != nil {
+		switch err.Error() {
+		case "dispute not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "refund must be between 0 and the escrowed amount":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case "dispute already resolved", "lease is still running", "escrow is not held for the dispute":
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Dispute resolved"})
+}
+
+// GetLedger handles the retrieval of the credit movements of the user.
+func GetLedger(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	entries, err := pkg.GetUserLedger(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// RunEscrowRelease periodically pays the suppliers of leases whose dispute window passed undisputed. It never returns.
+func RunEscrowRelease(db *sql.DB) {
+	ticker := time.NewTicker(escrowReleaseInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := pkg.ReleaseDueEscrows(db, time.Now()); err != nil {
+			log.Printf("Failed to release escrows: %v", err)
+		}
+	}
+}
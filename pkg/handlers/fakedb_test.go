@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+// fakeQuery answers the first query containing match, once
+type fakeQuery struct {
+	match    string
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// fakeDB is a database answering scripted queries, for handler tests that cannot reach Postgres
+type fakeDB struct {
+	t        *testing.T
+	mutex    sync.Mutex
+	expected []*fakeQuery
+	executed []executedQuery
+	commits  int
+}
+
+type executedQuery struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	fakeDBsMutex sync.Mutex
+	fakeDBs      = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB returns a database answering the queries in turn, queries nobody expects fail the test
+func newFakeDB(t *testing.T, queries ...fakeQuery) (*sql.DB, *fakeDB) {
+	fake := &fakeDB{t: t}
+	for i := range queries {
+		fake.expected = append(fake.expected, &queries[i])
+	}
+
+	fakeDBsMutex.Lock()
+	fakeDBs[t.Name()] = fake
+	fakeDBsMutex.Unlock()
+
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatalf("Failed to open fake database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMutex.Lock()
+		delete(fakeDBs, t.Name())
+		fakeDBsMutex.Unlock()
+	})
+	return db, fake
+}
+
+// withDB returns the request as the router passes it to the handlers
+func withDB(r *http.Request, db *sql.DB) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), pkg.GetDBContextKey(), db))
+}
+
+// ran reports whether a query containing match was executed
+func (f *fakeDB) ran(match string) bool {
+	_, ok := f.args(match)
+	return ok
+}
+
+// args returns the arguments of the last query containing match that was executed
+func (f *fakeDB) args(match string) ([]driver.Value, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := len(f.executed) - 1; i >= 0; i-- {
+		if strings.Contains(f.executed[i].query, match) {
+			return f.executed[i].args, true
+		}
+	}
+	return nil, false
+}
+
+// committed returns the number of transactions committed
+func (f *fakeDB) committed() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.commits
+}
+
+// answer returns the scripted answer of a query
+func (f *fakeDB) answer(query string, args []driver.Value) (*fakeQuery, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.executed = append(f.executed, executedQuery{query: query, args: args})
+	for i, expected := range f.expected {
+		if strings.Contains(query, expected.match) {
+			f.expected = append(f.expected[:i], f.expected[i+1:]...)
+			return expected, expected.err
+		}
+	}
+	f.t.Errorf("Unexpected query %s with %v", query, args)
+	return nil, errors.New("unexpected query")
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMutex.Lock()
+	defer fakeDBsMutex.Unlock()
+	fake, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %s", name)
+	}
+	return &fakeConn{db: fake}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{db: c.db}, nil
+}
+
+// fakeTx only counts commits, the statements of a transaction that rolls back stay executed
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.mutex.Lock()
+	defer tx.db.mutex.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	answer, err := s.db.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(answer.affected), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	answer, err := s.db.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: answer.columns, rows: answer.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// row answers a query with a single row
+func row(match string, values ...driver.Value) fakeQuery {
+	columns := make([]string, len(values))
+	for i := range columns {
+		columns[i] = fmt.Sprint("column", i)
+	}
+	return fakeQuery{match: match, columns: columns, rows: [][]driver.Value{values}}
+}
+
+// rows answers a query with a row per value, each of a single column
+func rows(match string, values ...driver.Value) fakeQuery {
+	query := fakeQuery{match: match, columns: []string{"column0"}}
+	for _, value := range values {
+		query.rows = append(query.rows, []driver.Value{value})
+	}
+	return query
+}
+
+// noRows answers a query with no rows
+func noRows(match string) fakeQuery {
+	return fakeQuery{match: match, columns: []string{"column0"}}
+}
+
+// exec answers a statement changing the given number of rows
+func exec(match string, affected int64) fakeQuery {
+	return fakeQuery{match: match, affected: affected}
+}
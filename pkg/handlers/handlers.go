@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"sync"
 	"time"
@@ -14,9 +15,16 @@ import (
 	"github.com/gunrgnhsr/Cycloud/pkg/auth"
 	"github.com/gunrgnhsr/Cycloud/pkg/bidding"
 	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/hardware"
 	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/reputation"
+	"github.com/gunrgnhsr/Cycloud/pkg/settlement"
+	"github.com/gunrgnhsr/Cycloud/pkg/workload"
 )
 
+// auctionWindow is how long a resource made available collects bids before its auction closes
+const auctionWindow = 1 * time.Minute
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -60,34 +68,46 @@ func handleSSERequestCORS(w http.ResponseWriter, r *http.Request, headers string
 	return res
 }
 
-func checkAuthorizationWithToken(r *http.Request, tokenString string) (string, error) {
+// checkSessionWithToken validates an access token and returns the user and the session it was issued for, the
+// session must not have been revoked since and must still belong to the user of the token
+func checkSessionWithToken(r *http.Request, tokenString string) (string, string, error) {
 	// Validate the token
 	claims, err := auth.ValidateJWT(tokenString)
-	if err != nil {
-		return "", errors.New("invalid token")
+	if err != nil || claims.SessionID == "" || claims.Subject == "" {
+		return "", "", errors.New("invalid token")
 	}
 
 	// Get the database connection from the request context
 	db := getDB(r)
 
-	// Check if the token exists in the tokens table
-	uid, err := pkg.GetUserIDFromToken(db, tokenString)
+	// Check that the session of the token is still live
+	uid, err := pkg.GetSessionUser(db, claims.SessionID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-
-	// Check if the token is expired
-	if claims.ExpiresAt < time.Now().Unix() {
-		// Remove the expired token from the database
-		pkg.RemoveExpiredToken(db, tokenString)
+	if uid != claims.Subject {
+		return "", "", errors.New("invalid token")
 	}
 
-	// Return the username in the response
-	return uid, nil
+	return uid, claims.SessionID, nil
+}
+
+func checkAuthorizationWithToken(r *http.Request, tokenString string) (string, error) {
+	uid, _, err := checkSessionWithToken(r, tokenString)
+	return uid, err
 }
 
-// checkAuthorization checks if the request is authorized by validating the JWT token
+// checkAuthorization checks if the request is authorized by validating the JWT token, or the API key sent instead of
+// it which must hold the permission of the route as a scope
 func checkAuthorization(r *http.Request) (string, error) {
+	if requestAPIKey(r) != "" {
+		permission, ok := routePermission(r)
+		if !ok {
+			return "", errors.New("permission denied")
+		}
+		return checkAPIKeyPermission(r, permission)
+	}
+
 	// Get the token from the Authorization header
 	tokenString := r.Header.Get("Authorization")
 	if tokenString == "" {
@@ -148,7 +168,29 @@ func checkThatTheresABidForTheResourceByUser(r *http.Request, rid string, uid st
 	return nil
 }
 
-// Login handles user login and generates a JWT
+// settleNoShow applies a no-show settlement to the accepted bid, a bid already settled by its peer is left untouched
+func settleNoShow(r *http.Request, bid models.BidWithID, s settlement.Settlement) {
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err := pkg.SettleNoShow(db, bid.BID, s)
+	if err != nil && err.Error() != "bid already settled" {
+		log.Printf("Failed to settle %s for bid %s: %v", s.Outcome, bid.BID, err)
+	}
+}
+
+// logSignaling keeps an event of the signaling session of a lease as evidence for disputes
+func logSignaling(r *http.Request, bid string, uid string, event string) {
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err := pkg.InsertSignalingLog(db, bid, uid, event)
+	if err != nil {
+		log.Printf("Failed to log %s for bid %s: %v", event, bid, err)
+	}
+}
+
+// Login handles user login and generates a JWT, unknown users must register first
 func Login(w http.ResponseWriter, r *http.Request) {
 	if handleCORS(w, r, "Authorization, content-type", "POST") {
 		return
@@ -169,81 +211,108 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	// Get the database connection from the request context
 	db := getDB(r)
 
-	// Hash the username and password
+	// Hash the username, it is the lookup key of the user
 	hashedUsername := auth.HashString(credentials.Username)
 
-	hashedPassword := auth.HashString(credentials.Password)
+	// Refuse guesses while the username or the address backs off or is locked out
+	attempt, refused := throttleLogin(w, r, hashedUsername)
+	if refused {
+		return
+	}
+	defer forgetLoginAttempt(attempt)
 
 	// Query the database to check if the user exists and the password matches
-	uid, err := pkg.GetUserOrRegisterIfNotExist(db, hashedUsername, hashedPassword)
+	passwordPolicy := auth.LoadPasswordPolicy()
+	uid, passwordHash, emailPending, suspended, err := pkg.GetUserCredentials(db, hashedUsername)
 	if err != nil {
-		if err.Error() == "failed to authenticate user" {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		} else if err.Error() == "invalid username or password" {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-		} else if err.Error() == "failed to register user" {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		if err.Error() == "user registered" {
-			// w.WriteHeader(http.StatusCreated)
-		} else {
+		if err.Error() == "user not found" {
+			loginFailed(w, r, attempt, "", hashedUsername, loginEventFailed, "invalid username or password")
 			return
 		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matches, rehash, err := passwordPolicy.VerifyPassword(credentials.Password, passwordHash)
+	if err != nil {
+		http.Error(w, "failed to authenticate user", http.StatusInternalServerError)
+		return
+	}
+	if !matches {
+		loginFailed(w, r, attempt, uid, hashedUsername, loginEventFailed, "invalid username or password")
+		return
 	}
 
-	if uid == "" {
-		http.Error(w, "Failed to authenticate or register user", http.StatusUnauthorized)
+	if emailPending && emailVerificationRequired() {
+		http.Error(w, "email not verified", http.StatusForbidden)
 		return
 	}
 
-	// Generate a JWT token
-	tokenString, err := auth.GenerateJWT(hashedUsername, "client")
+	if suspended {
+		http.Error(w, "account suspended", http.StatusForbidden)
+		return
+	}
+
+	// Legacy and outdated hashes are replaced while the password is at hand
+	if rehash {
+		if passwordHash, err = passwordPolicy.HashPassword(credentials.Password); err == nil {
+			err = pkg.UpdatePasswordHash(db, uid, passwordHash)
+		}
+		if err != nil {
+			log.Printf("Failed to rehash password of user %s: %v", uid, err)
+		}
+	}
+
+	// Users with two-factor authentication get their tokens from VerifyLogin
+	_, totpEnabled, _, err := pkg.GetTOTPState(db, uid)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if totpEnabled {
+		startLoginChallenge(w, r, uid)
+		return
+	}
+	loginSucceeded(attempt, hashedUsername)
 
-	// Insert the generated token into the database
-	err = pkg.InsertToken(db, uid, tokenString)
+	// Start a session for the device and issue its tokens
+	tokens, err := startSession(r, uid, hashedUsername)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Return the token in the response
+	// Return the tokens in the response
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{"token": tokenString})
+	json.NewEncoder(w).Encode(tokens)
 }
 
-// Logout handles user logout by invalidating the JWT token
+// Logout handles user logout by revoking the session of the JWT token, the other sessions of the user stay signed in
 func Logout(w http.ResponseWriter, r *http.Request) {
 	if handleCORS(w, r, "Authorization", "DELETE") {
 		return
 	}
 
-	var (
-		err error
-		uid string
-	)
-	uid, err = checkAuthorization(r)
+	tokenString := r.Header.Get("Authorization")
+	if tokenString == "" {
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return
+	}
+	uid, sid, err := checkSessionWithToken(r, tokenString)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	// TODO: Add the token to a blacklist or perform other invalidation logic
 	// Get the database connection from the request context
 	db := getDB(r)
 
-	// Remove the token from the database
-	err = pkg.RemoveExpiredToken(db, uid)
-	if err != nil {
-		if err.Error() != "failed to remove expired token" {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Revoke the session, its access and refresh tokens stop working
+	err = pkg.RevokeSession(db, uid, sid)
+	if err != nil && err.Error() != "session not found" {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	// For example, you could store invalidated tokens in a database or in-memory store
 
 	// Return a success response
 	w.WriteHeader(http.StatusOK)
@@ -275,6 +344,14 @@ func CreateResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate the hardware spec
+	resource = hardware.Normalize(resource)
+	err = hardware.Validate(resource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get the database connection from the request context
 	db := getDB(r)
 
@@ -325,7 +402,7 @@ func UpdateResourceAvailability(w http.ResponseWriter, r *http.Request) {
 	// Update the resource availability in the database
 	available, err := pkg.UpdateResourceAvailability(db, rid)
 	if err != nil {
-		if(err.Error() == "resource is currently computing") {
+		if(err.Error() == "resource is currently computing" || err.Error() == "resource is archived" || err.Error() == "resource is under maintenance") {
 			http.Error(w, err.Error(), http.StatusPreconditionFailed)
 			return
 		}
@@ -333,6 +410,18 @@ func UpdateResourceAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Pool nodes are leased through their pool, they never go through an auction
+	pid, err := pkg.GetResourcePool(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pid != "" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Availability changed", "available": available})
+		return
+	}
+
 	if !available {
 		err = pkg.UpdateBidsForResourceInavailablity(db, rid)
 		if err != nil {
@@ -352,7 +441,7 @@ func UpdateResourceAvailability(w http.ResponseWriter, r *http.Request) {
 		var wg sync.WaitGroup
 		wg.Add(1)
 		go func(resourcesID string) {
-			time.Sleep(1 * time.Minute)
+			time.Sleep(auctionWindow)
 			bid, err := bidding.CheckBidsForResource(resourcesID)
 			if err != nil {
 				pkg.UpdateResourceAvailability(db, resourcesID)
@@ -423,9 +512,13 @@ func DeleteResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete the resource from the database
-	err = pkg.DeleteResource(db, rid)
+	// Archive the resource, its bids are kept for historical leases and price history
+	err = pkg.ArchiveResource(db, rid)
 	if err != nil {
+		if err.Error() == "resource is currently computing or already archived" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -544,6 +637,16 @@ func PlaceBid(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate the optional workload specification
+	if bid.Workload != nil {
+		*bid.Workload = workload.Normalize(*bid.Workload)
+		err = workload.Validate(*bid.Workload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get the database connection from the request context
 	db := getDB(r)
 
@@ -561,6 +664,10 @@ func PlaceBid(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusPreconditionFailed)
 			return
 		}
+		if errType == "lease overlaps maintenance" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
 		if errType == "resource is currently computing" {
 			http.Error(w, err.Error(), http.StatusPreconditionFailed)
 			return
@@ -573,6 +680,14 @@ func PlaceBid(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusPreconditionFailed)
 			return
 		}
+		if errType == "workload rejected by policy" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if errType == "bid rejected by policy" {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		return
 	}
 
@@ -615,12 +730,32 @@ func PlaceBid(w http.ResponseWriter, r *http.Request) {
 			// TODO: implement the connection from the accepted bid to the resource
 			duration := bidPtr.MaxBid.Duration
 			timer := time.NewTimer(time.Duration(duration) * time.Minute)
+			leaseEnded := bidding.WatchLease(bidPtr.MaxBid.BID)
 			go func() {
-				<-timer.C
+				defer bidding.ForgetLease(bidPtr.MaxBid.BID)
+				ended := false
+				select {
+				case <-timer.C:
+				case <-leaseEnded:
+					ended = true
+				case <-r.Context().Done():
+					// The renter left before the lease ran out, it is still charged for the whole lease
+					recordLeaseEvent(db, bidPtr.MaxBid.BID, uid, reputation.EarlyTermination)
+					select {
+					case <-timer.C:
+					case <-leaseEnded:
+						ended = true
+					}
+				}
 				fmt.Fprintf(w, `{"data": "%s"}`+"\n\n", "connection ended")
 				flusher.Flush()
 				// TODO: implement the connection termination
-				err = pkg.FinishCompute(db, bidPtr.MaxBid.RID, uid, bidPtr.MaxBid)
+				if ended {
+					// An admin ended the lease and already freed its resource
+					timer.Stop()
+				} else {
+					err = pkg.FinishCompute(db, bidPtr.MaxBid.RID, uid, bidPtr.MaxBid)
+				}
 				<-r.Context().Done()
 				wg.Done()
 			}()
@@ -792,6 +927,13 @@ func GetUserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Fetch the user's reputation
+	userReputation, err := pkg.GetReputationDetails(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Return the credits data
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -800,6 +942,7 @@ func GetUserInfo(w http.ResponseWriter, r *http.Request) {
 		"activeResources": activeResources,
 		"pendingBids":     pendingBids,
 		"activeLoans":     activeLoans,
+		"reputation":      userReputation,
 	})
 }
 
@@ -890,10 +1033,12 @@ func PassConnectionOffer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	logSignaling(r, winningBid.BID, uid, "supplier_joined")
 
+	noShowPolicy := settlement.LoadNoShowPolicy()
 	var loanerWS *websocket.Conn
-	duration := winningBid.Duration*int(time.Minute) // Duration in seconds
-	for i := 0; i < duration; i++ {
+	deadline := time.Now().Add(noShowPolicy.GracePeriod)
+	for time.Now().Before(deadline) {
 		loanerWS, err = bidding.GetPeerWS(rid, models.Renter)		
 		if err != nil {
 			ws.WriteJSON(map[string]interface{}{"error": err.Error()})
@@ -905,23 +1050,33 @@ func PassConnectionOffer(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(1 * time.Second)
 	}
 	if loanerWS == nil {
+		// The renter never showed up, the supplier is paid the no-show fee from the renter's escrow
+		settleNoShow(r, winningBid, noShowPolicy.Settle(settlement.RenterNoShow, winningBid))
 		ws.WriteJSON(map[string]interface{}{"error": "Renter not found"})
-		// TODO: charge the user for the bid
 		return
 	}
 
-	ws.WriteJSON(map[string]interface{}{"type": "start"})
+	recordConnection(getDB(r), winningBid.BID, uid)
+
+	// The supplier learns what to run as the lease starts
+	start := map[string]interface{}{"type": "start", "bid": winningBid.BID}
+	if winningBid.Workload != nil {
+		start["workload"] = winningBid.Workload
+	}
+	ws.WriteJSON(start)
 
 	for {
 		var msg map[string]interface{}
 		err := ws.ReadJSON(&msg)
 		if err != nil {
+			logSignaling(r, winningBid.BID, uid, "supplier_left")
 			ws.WriteJSON(map[string]interface{}{"error": err.Error()})
 			break
 		}
 
 		switch msg["type"] {
 		case "offer", "iceCandidates":
+			logSignaling(r, winningBid.BID, uid, "supplier_sent_"+msg["type"].(string))
 			err = loanerWS.WriteJSON(msg)
 			if err != nil {
 				ws.WriteJSON(map[string]interface{}{"error": err.Error()})
@@ -984,10 +1139,12 @@ func PassConnectionAnswer(w http.ResponseWriter, r *http.Request) {
 		ws.WriteJSON(map[string]interface{}{"error": err.Error()})
 		return
 	}
+	logSignaling(r, winningBid.BID, uid, "renter_joined")
 
+	noShowPolicy := settlement.LoadNoShowPolicy()
 	var renterWS *websocket.Conn
-	duration := winningBid.Duration*int(time.Minute) // Duration in seconds
-	for i := 0; i < duration; i++ {
+	deadline := time.Now().Add(noShowPolicy.GracePeriod)
+	for time.Now().Before(deadline) {
 		renterWS, err = bidding.GetPeerWS(rid, models.Loaner)		
 		if err != nil {
 			ws.WriteJSON(map[string]interface{}{"error": err.Error()})
@@ -999,8 +1156,9 @@ func PassConnectionAnswer(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(1 * time.Second)
 	}
 	if renterWS == nil {
-		ws.WriteJSON(map[string]interface{}{"error": "Renter not found"})
-		// TODO: don't charge the user for the bid
+		// The supplier never opened the offer socket, the renter is refunded and the supplier penalised
+		settleNoShow(r, winningBid, noShowPolicy.Settle(settlement.SupplierNoShow, winningBid))
+		ws.WriteJSON(map[string]interface{}{"error": "Supplier not found"})
 		return
 	}
 
@@ -1008,12 +1166,14 @@ func PassConnectionAnswer(w http.ResponseWriter, r *http.Request) {
 		var msg map[string]interface{}
 		err := ws.ReadJSON(&msg)
 		if err != nil {
+			logSignaling(r, winningBid.BID, uid, "renter_left")
 			ws.WriteJSON(map[string]interface{}{"error": err.Error()})
 			break
 		}
 
 		switch msg["type"] {
 		case "answer", "iceCandidates":
+			logSignaling(r, winningBid.BID, uid, "renter_sent_"+msg["type"].(string))
 			err = renterWS.WriteJSON(msg)
 			if err != nil {
 				ws.WriteJSON(map[string]interface{}{"error": err.Error()})
@@ -3,11 +3,14 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
 	_ "github.com/lib/pq"
 )
 
@@ -52,3 +55,59 @@ func TestLogin(t *testing.T) {
 		t.Errorf("handler returned an invalid token: %v", err)
 	}
 }
+
+func TestTokenOfAReusedSessionIsRejected(t *testing.T) {
+	// The session of the token was handed out again to another user
+	db, fake := newFakeDB(t, sessionQuery("8"))
+	request := sessionRequest(t, http.MethodGet, "/get-sessions", "", []string{auth.RoleRenter})
+
+	recorder := httptest.NewRecorder()
+	GetSessions(recorder, withDB(request, db))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the token to be rejected with %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+	if fake.ran("SELECT sid, device") {
+		t.Errorf("Expected the sessions of the other user to stay hidden")
+	}
+}
+
+func TestFinishComputeLeavesTheResourceOfASettledLeaseAlone(t *testing.T) {
+	// The lease was settled as a no-show and its resource may be running a newer lease by now
+	db, fake := newFakeDB(t, exec("UPDATE .bids SET computing = false", 0))
+	bid := models.BidWithID{BID: "5", Bid: models.Bid{RID: "4", Amount: 2, Duration: 10}}
+
+	if err := pkg.FinishCompute(db, "4", "8", bid); err != nil {
+		t.Fatalf("Failed to finish compute: %v", err)
+	}
+	if fake.ran(".resources") || fake.ran(".wallets") {
+		t.Errorf("Expected a settled lease to leave its resource and the wallets alone")
+	}
+}
+
+func TestWinningBidIsAcceptedWithItsClearingPrice(t *testing.T) {
+	bid := models.BidWithID{BID: "5", Bid: models.Bid{RID: "4", Amount: 2, Duration: 10}}
+
+	db, fake := newFakeDB(t,
+		exec("UPDATE .bids SET status = 'accepted'", 1),
+		exec("UPDATE .resources SET computing = true", 1),
+		fakeQuery{match: "INSERT INTO .clearing_prices", err: errors.New("connection reset")},
+	)
+	if err := pkg.UpdateWinningBid(db, bid); err == nil {
+		t.Errorf("Expected the acceptance to fail without its clearing price")
+	}
+	if fake.committed() != 0 {
+		t.Errorf("Expected the bid to stay unaccepted without its clearing price")
+	}
+
+	db, fake = newFakeDB(t,
+		exec("UPDATE .bids SET status = 'accepted'", 1),
+		exec("UPDATE .resources SET computing = true", 1),
+		exec("INSERT INTO .clearing_prices", 1),
+	)
+	if err := pkg.UpdateWinningBid(db, bid); err != nil {
+		t.Fatalf("Failed to accept the winning bid: %v", err)
+	}
+	if fake.committed() != 1 {
+		t.Errorf("Expected the acceptance and its clearing price to be committed together, got %d commits", fake.committed())
+	}
+}
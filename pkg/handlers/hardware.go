@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/hardware"
+)
+
+// SubmitHardwareReport handles a hardware report signed by a supplier agent. The measured facts are compared to the
+// claimed spec of the resource, which is marked verified or diverged accordingly.
+func SubmitHardwareReport(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "X-API-Key, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAPIKeyAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// The report is kept as sent, its signature covers the exact bytes
+	var submission struct {
+		Report    json.RawMessage `json:"report"`
+		Signature string          `json:"signature"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&submission)
+	if err != nil || len(submission.Report) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	agentKey, err := pkg.GetAgentKey(db, rid)
+	if err != nil {
+		if err.Error() == "resource has no registered agent" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := hardware.VerifyReport(agentKey, submission.Report, submission.Signature, rid, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resource, err := pkg.GetResourceByID(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	divergences := hardware.CompareReport(resource.Resource, report.Facts)
+	hid, err := pkg.InsertHardwareReport(db, rid, submission.Report, submission.Signature, divergences)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Hardware report stored", "hid": hid, "verified": len(divergences) == 0, "divergences": divergences})
+}
+
+// GetHardwareReport handles the retrieval of the latest hardware report of a resource and its divergences.
+func GetHardwareReport(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	verification, err := pkg.GetLatestHardwareReport(db, rid)
+	if err != nil {
+		if err.Error() == "no hardware report for resource" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
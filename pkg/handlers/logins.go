@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+// Login events recorded for admins
+const (
+	loginEventFailed             = "login_failed"
+	loginEventSecondFactorFailed = "second_factor_failed"
+	loginEventLockedOut          = "locked_out"
+)
+
+// loginThrottle slows down and locks out repeated failed logins, replaced with SetLoginThrottle
+var loginThrottle = auth.NewLoginThrottle(auth.NewMemoryAttemptStore(), auth.DefaultUserThrottle, auth.DefaultIPThrottle)
+
+// SetLoginThrottle sets the throttle failed logins are counted with
+func SetLoginThrottle(throttle *auth.LoginThrottle) {
+	loginThrottle = throttle
+}
+
+// setRetryAfter tells the client how long to wait before its next login attempt
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
+
+// throttleLogin counts a login of the username before its credentials are checked and refuses it while the username
+// or the address of the request backs off or is locked out. It returns true when the login was refused, otherwise the
+// attempt is settled with loginFailed or loginSucceeded, or forgotten by the deferred forgetLoginAttempt.
+func throttleLogin(w http.ResponseWriter, r *http.Request, username string) (*auth.LoginAttempt, bool) {
+	attempt, wait, err := loginThrottle.Attempt(username, clientIP(r), time.Now())
+	if err != nil {
+		http.Error(w, "failed to authenticate user", http.StatusInternalServerError)
+		return attempt, true
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return attempt, true
+	}
+	return attempt, false
+}
+
+// forgetLoginAttempt uncounts a login that ended without its credentials being rejected
+func forgetLoginAttempt(attempt *auth.LoginAttempt) {
+	if err := loginThrottle.Forget(attempt); err != nil {
+		log.Printf("Failed to forget login attempt: %v", err)
+	}
+}
+
+// recordLoginEvent records a login event for admins, the login was already answered so a failure is only logged
+func recordLoginEvent(r *http.Request, uid string, username string, event string) {
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err := pkg.InsertLoginEvent(db, uid, username, clientIP(r), event)
+	if err != nil {
+		log.Printf("Failed to record %s of user %s: %v", event, username, err)
+	}
+}
+
+// loginFailed records the failed login attempt of the username, uid is empty when the username does not exist, and
+// refuses the login with the message
+func loginFailed(w http.ResponseWriter, r *http.Request, attempt *auth.LoginAttempt, uid string, username string, event string, message string) {
+	wait, lockedOut := loginThrottle.Failed(attempt)
+	recordLoginEvent(r, uid, username, event)
+	if lockedOut {
+		recordLoginEvent(r, uid, username, loginEventLockedOut)
+	}
+
+	setRetryAfter(w, wait)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// loginSucceeded forgets the failed logins of the username once its attempt signed in
+func loginSucceeded(attempt *auth.LoginAttempt, username string) {
+	if err := loginThrottle.Succeeded(attempt); err != nil {
+		log.Printf("Failed to clear failed logins of user %s: %v", username, err)
+	}
+}
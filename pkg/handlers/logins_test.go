@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+)
+
+func TestThrottleLoginRefusesLockedOutUsernames(t *testing.T) {
+	policy := auth.ThrottlePolicy{BackoffAfter: 5, BackoffBase: time.Second, MaxBackoff: time.Minute, LockoutAfter: 2, Lockout: time.Minute, Window: time.Minute}
+	defer SetLoginThrottle(loginThrottle)
+	SetLoginThrottle(auth.NewLoginThrottle(auth.NewMemoryAttemptStore(), policy, auth.DefaultIPThrottle))
+
+	request := httptest.NewRequest(http.MethodPost, "/login", nil)
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		attempt, refused := throttleLogin(recorder, request, "alice")
+		if refused {
+			t.Fatalf("Expected login %d to be allowed, got %d", i, recorder.Code)
+		}
+		loginThrottle.Failed(attempt)
+	}
+	recorder := httptest.NewRecorder()
+	if _, refused := throttleLogin(recorder, request, "alice"); !refused {
+		t.Fatalf("Expected a locked out username to be refused")
+	}
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected to be told to retry after 60 seconds, got %q", recorder.Header().Get("Retry-After"))
+	}
+
+	recorder = httptest.NewRecorder()
+	if _, refused := throttleLogin(recorder, request, "bob"); refused {
+		t.Errorf("Expected other usernames to be allowed, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/bidding"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// maintenanceCheckInterval is how often scheduled maintenance windows are started and ended
+const maintenanceCheckInterval = time.Minute
+
+// DrainResource handles taking a resource of the user off the market for maintenance. A running lease finishes
+// first, after which the resource goes into maintenance on its own.
+func DrainResource(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "PUT") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	status, unlisted, err := pkg.DrainResource(db, rid)
+	if err != nil {
+		if err.Error() == "resource is archived" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Close the running auction, its bids were rejected with the drain
+	if unlisted {
+		bidding.MakeResourceUnavailable(rid)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Resource drained", "status": status})
+}
+
+// EndResourceMaintenance handles returning a drained resource of the user to the market. It comes back unlisted.
+func EndResourceMaintenance(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "PUT") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.EndMaintenance(db, rid)
+	if err != nil {
+		if err.Error() == "resource is not under maintenance" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Maintenance ended"})
+}
+
+// ScheduleResourceMaintenance handles setting the maintenance window of a resource of the user. Renters see the
+// window in listings, and the resource is drained when it starts.
+func ScheduleResourceMaintenance(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "PUT") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var window models.MaintenanceWindow
+	err = json.NewDecoder(r.Body).Decode(&window)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !window.End.After(window.Start) {
+		http.Error(w, "Maintenance must end after it starts", http.StatusBadRequest)
+		return
+	}
+	if !window.End.After(time.Now()) {
+		http.Error(w, "Maintenance window is already over", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.ScheduleMaintenance(db, rid, window)
+	if err != nil {
+		if err.Error() == "resource is archived" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Maintenance scheduled", "maintenance": window})
+}
+
+// CancelResourceMaintenance handles removing the maintenance window of a resource of the user. A drain that already
+// started is ended through EndResourceMaintenance.
+func CancelResourceMaintenance(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.CancelScheduledMaintenance(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Maintenance cancelled"})
+}
+
+// runScheduledMaintenance drains the resources whose maintenance window started and returns the ones whose window
+// ended to the market
+func runScheduledMaintenance(db *sql.DB) {
+	now := time.Now()
+	unlisted, err := pkg.StartDueMaintenance(db, now)
+	for _, rid := range unlisted {
+		bidding.MakeResourceUnavailable(rid)
+	}
+	if err != nil {
+		log.Printf("Failed to start scheduled maintenance: %v", err)
+	}
+
+	if _, err = pkg.EndDueMaintenance(db, now); err != nil {
+		log.Printf("Failed to end scheduled maintenance: %v", err)
+	}
+}
+
+// RunMaintenanceScheduler periodically starts and ends scheduled maintenance windows. It never returns.
+func RunMaintenanceScheduler(db *sql.DB) {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		runScheduledMaintenance(db)
+	}
+}
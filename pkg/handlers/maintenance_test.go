@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// maintenanceRequest returns a request of the owner of resource 4, who is uid 7
+func maintenanceRequest(t *testing.T, method string, path string, body string) *http.Request {
+	request := sessionRequest(t, method, path, body, []string{auth.RoleSupplier})
+	return mux.SetURLVars(request, map[string]string{"rid": "4"})
+}
+
+func TestDrainIdleResourceGoesToMaintenance(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		row("FOR UPDATE", models.ResourceActive, true, false),
+		exec("SET status = $2, available = false", 1),
+		exec("UPDATE .bids SET status = 'rejected'", 2),
+	)
+	request := maintenanceRequest(t, http.MethodPut, "/drain-resource/4", "")
+
+	recorder := httptest.NewRecorder()
+	DrainResource(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the resource to be drained, got %d: %s", recorder.Code, recorder.Body)
+	}
+	args, _ := fake.args("SET status = $2, available = false")
+	if len(args) != 2 || args[1] != models.ResourceMaintenance {
+		t.Errorf("Expected an idle resource to go to maintenance right away, got %v", args)
+	}
+	if !fake.ran("UPDATE .bids SET status = 'rejected'") {
+		t.Errorf("Expected the pending bids to be rejected")
+	}
+}
+
+func TestDrainComputingResourceWaitsForItsLease(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		row("FOR UPDATE", models.ResourceActive, false, true),
+		exec("SET status = $2, available = false", 1),
+		exec("UPDATE .bids SET status = 'rejected'", 0),
+	)
+	request := maintenanceRequest(t, http.MethodPut, "/drain-resource/4", "")
+
+	recorder := httptest.NewRecorder()
+	DrainResource(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the resource to be drained, got %d: %s", recorder.Code, recorder.Body)
+	}
+	args, _ := fake.args("SET status = $2, available = false")
+	if len(args) != 2 || args[1] != models.ResourceDraining {
+		t.Errorf("Expected a computing resource to drain until its lease ends, got %v", args)
+	}
+}
+
+func TestDrainArchivedResource(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		row("FOR UPDATE", models.ResourceArchived, false, false),
+	)
+	request := maintenanceRequest(t, http.MethodPut, "/drain-resource/4", "")
+
+	recorder := httptest.NewRecorder()
+	DrainResource(recorder, withDB(request, db))
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected an archived resource to be refused with %d, got %d", http.StatusPreconditionFailed, recorder.Code)
+	}
+	if fake.ran("SET status = $2, available = false") {
+		t.Errorf("Expected an archived resource to be left alone")
+	}
+}
+
+func TestDrainResourceOfAnotherUser(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "8"),
+	)
+	request := maintenanceRequest(t, http.MethodPut, "/drain-resource/4", "")
+
+	recorder := httptest.NewRecorder()
+	DrainResource(recorder, withDB(request, db))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the resource of another user to be refused with %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+	if fake.ran("FOR UPDATE") {
+		t.Errorf("Expected the resource of another user to be left alone")
+	}
+}
+
+func TestEndResourceMaintenance(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		exec("SET status = 'active'", 1),
+	)
+	request := maintenanceRequest(t, http.MethodPut, "/end-resource-maintenance/4", "")
+
+	recorder := httptest.NewRecorder()
+	EndResourceMaintenance(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected maintenance to end, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if !fake.ran("SET status = 'active'") {
+		t.Errorf("Expected the resource to return to the market")
+	}
+}
+
+func TestEndMaintenanceOfActiveResource(t *testing.T) {
+	db, _ := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		exec("SET status = 'active'", 0),
+	)
+	request := maintenanceRequest(t, http.MethodPut, "/end-resource-maintenance/4", "")
+
+	recorder := httptest.NewRecorder()
+	EndResourceMaintenance(recorder, withDB(request, db))
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a resource not under maintenance to be refused with %d, got %d", http.StatusPreconditionFailed, recorder.Code)
+	}
+}
+
+func TestScheduleResourceMaintenance(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		exec("SET maintenanceStart = $2", 1),
+	)
+	start := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	end := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	request := maintenanceRequest(t, http.MethodPut, "/schedule-resource-maintenance/4", `{"start": "`+start+`", "end": "`+end+`", "reason": "disk swap"}`)
+
+	recorder := httptest.NewRecorder()
+	ScheduleResourceMaintenance(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected maintenance to be scheduled, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if !fake.ran("SET maintenanceStart = $2") {
+		t.Errorf("Expected the maintenance window to be stored")
+	}
+}
+
+func TestScheduleResourceMaintenanceRefusesInvalidWindows(t *testing.T) {
+	now := time.Now().UTC()
+	windows := map[string][2]time.Time{
+		"ending before it starts": {now.Add(2 * time.Hour), now.Add(time.Hour)},
+		"already over":            {now.Add(-2 * time.Hour), now.Add(-time.Hour)},
+	}
+	for name, window := range windows {
+		t.Run(name, func(t *testing.T) {
+			db, fake := newFakeDB(t,
+				sessionQuery("7"),
+				row("SELECT uid FROM .resources", "7"),
+			)
+			body := `{"start": "` + window[0].Format(time.RFC3339) + `", "end": "` + window[1].Format(time.RFC3339) + `"}`
+			request := maintenanceRequest(t, http.MethodPut, "/schedule-resource-maintenance/4", body)
+
+			recorder := httptest.NewRecorder()
+			ScheduleResourceMaintenance(recorder, withDB(request, db))
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("Expected the window to be refused with %d, got %d", http.StatusBadRequest, recorder.Code)
+			}
+			if fake.ran("SET maintenanceStart") {
+				t.Errorf("Expected no maintenance window to be stored")
+			}
+		})
+	}
+}
+
+func TestCancelResourceMaintenance(t *testing.T) {
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT uid FROM .resources", "7"),
+		exec("SET maintenanceStart = NULL", 1),
+	)
+	request := maintenanceRequest(t, http.MethodDelete, "/cancel-resource-maintenance/4", "")
+
+	recorder := httptest.NewRecorder()
+	CancelResourceMaintenance(recorder, withDB(request, db))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected maintenance to be cancelled, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if !fake.ran("SET maintenanceStart = NULL") {
+		t.Errorf("Expected the maintenance window to be cleared")
+	}
+}
+
+// scheduledResource answers the lookup of resource 4, listed with a maintenance window from start to end
+func scheduledResource(start time.Time, end time.Time) fakeQuery {
+	values := []driver.Value{"4", int64(8), int64(32), int64(100), "", int64(1000), 2.0, true, false, "eu", "{}",
+		[]byte("[]"), "", "", "", "", "", int64(0), []byte("{}"), models.ResourceActive, models.VerificationUnverified,
+		start, end, "disk swap", nil, time.Now(), 50.0}
+	return row("FROM .resources WHERE rid = $1", values...)
+}
+
+func TestBidRunningIntoMaintenanceIsRejected(t *testing.T) {
+	start := time.Now().Add(time.Hour)
+	db, fake := newFakeDB(t,
+		sessionQuery("7"),
+		row("SELECT credits FROM .wallets", 100.0),
+		scheduledResource(start, start.Add(2*time.Hour)),
+	)
+	request := sessionRequest(t, http.MethodPost, "/place-loan-request", `{"rid": "4", "amount": 5, "duration": 10}`, []string{auth.RoleRenter})
+
+	recorder := httptest.NewRecorder()
+	PlaceBid(recorder, withDB(request, db))
+	if recorder.Code != http.StatusPreconditionFailed || !strings.Contains(recorder.Body.String(), "maintenance") {
+		t.Errorf("Expected a lease running into the maintenance window to be refused with %d, got %d: %s", http.StatusPreconditionFailed, recorder.Code, recorder.Body)
+	}
+	if fake.ran("INSERT INTO .bids") {
+		t.Errorf("Expected no bid to be placed")
+	}
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+// publicRoute marks routes that are open to anyone
+const publicRoute auth.Permission = ""
+
+// routePermissions maps the path template of every route to the permission its caller needs. Routes missing from
+// the map are rejected, so a new route must be given a permission here before it can be reached.
+var routePermissions = map[string]auth.Permission{
+	"/login":                 publicRoute,
+	"/register":              publicRoute,
+	"/verify-email/{token}":  publicRoute,
+	"/refresh-token":         publicRoute,
+	"/verify-login":          publicRoute,
+	"/.well-known/jwks.json": publicRoute,
+
+	"/logout":                    auth.PermissionManageAccount,
+	"/get-sessions":              auth.PermissionManageAccount,
+	"/get-2fa-status":            auth.PermissionManageAccount,
+	"/enroll-2fa":                auth.PermissionManageAccount,
+	"/confirm-2fa":               auth.PermissionManageAccount,
+	"/disable-2fa":               auth.PermissionManageAccount,
+	"/regenerate-recovery-codes": auth.PermissionManageAccount,
+	"/revoke-session/{sid}":      auth.PermissionManageAccount,
+	"/get-info":                  auth.PermissionManageAccount,
+	"/add-credits":               auth.PermissionManageAccount,
+	"/get-ledger":                auth.PermissionManageAccount,
+	"/add-api-key":               auth.PermissionManageAccount,
+	"/get-api-keys":              auth.PermissionManageAccount,
+	"/revoke-api-key/{kid}":      auth.PermissionManageAccount,
+
+	"/price-history":                         auth.PermissionBrowseResources,
+	"/price-percentiles":                     auth.PermissionBrowseResources,
+	"/available-resources/{rid}/{direction}": auth.PermissionBrowseResources,
+	"/search-resources":                      auth.PermissionBrowseResources,
+	"/get-resource/{rid}":                    auth.PermissionBrowseResources,
+	"/available-pools":                       auth.PermissionBrowseResources,
+	"/get-hardware-report/{rid}":             auth.PermissionBrowseResources,
+	"/get-resource-ratings/{rid}":            auth.PermissionBrowseResources,
+
+	"/place-loan-request":                    auth.PermissionRentResources,
+	"/get-loan-requests":                     auth.PermissionRentResources,
+	"/get-loan-request-spec/{bidId}":         auth.PermissionRentResources,
+	"/delete-loan-request/{bidId}":           auth.PermissionRentResources,
+	"/place-pool-loan-request":               auth.PermissionRentResources,
+	"/accept-connection-offer/{rid}/{token}": auth.PermissionRentResources,
+
+	"/get-user-resources":                  auth.PermissionSupplyResources,
+	"/add-user-resources":                  auth.PermissionSupplyResources,
+	"/bulk-user-resources":                 auth.PermissionSupplyResources,
+	"/add-resource-template":               auth.PermissionSupplyResources,
+	"/get-resource-templates":              auth.PermissionSupplyResources,
+	"/delete-resource-template/{tid}":      auth.PermissionSupplyResources,
+	"/delete-user-resource/{rid}":          auth.PermissionSupplyResources,
+	"/update-resource-availability/{rid}":  auth.PermissionSupplyResources,
+	"/update-user-resource/{rid}":          auth.PermissionSupplyResources,
+	"/get-resource-revisions/{rid}":        auth.PermissionSupplyResources,
+	"/get-pricing-rules/{rid}":             auth.PermissionSupplyResources,
+	"/set-pricing-rules/{rid}":             auth.PermissionSupplyResources,
+	"/get-workload-policy/{rid}":           auth.PermissionSupplyResources,
+	"/set-workload-policy/{rid}":           auth.PermissionSupplyResources,
+	"/get-acceptance-policy/{rid}":         auth.PermissionSupplyResources,
+	"/set-acceptance-policy/{rid}":         auth.PermissionSupplyResources,
+	"/suggest-price":                       auth.PermissionSupplyResources,
+	"/add-pool":                            auth.PermissionSupplyResources,
+	"/get-user-pools":                      auth.PermissionSupplyResources,
+	"/add-pool-members/{pid}":              auth.PermissionSupplyResources,
+	"/remove-pool-member/{pid}/{rid}":      auth.PermissionSupplyResources,
+	"/delete-pool/{pid}":                   auth.PermissionSupplyResources,
+	"/get-pool-leases/{pid}":               auth.PermissionSupplyResources,
+	"/drain-resource/{rid}":                auth.PermissionSupplyResources,
+	"/end-resource-maintenance/{rid}":      auth.PermissionSupplyResources,
+	"/schedule-resource-maintenance/{rid}": auth.PermissionSupplyResources,
+	"/cancel-resource-maintenance/{rid}":   auth.PermissionSupplyResources,
+	"/make-connection-offer/{rid}/{token}": auth.PermissionSupplyResources,
+
+	"/register-agent":              auth.PermissionRunAgents,
+	"/agent-heartbeat/{rid}":       auth.PermissionRunAgents,
+	"/agent-hardware-report/{rid}": auth.PermissionRunAgents,
+
+	"/rate-lease/{bidId}":   auth.PermissionReviewLeases,
+	"/open-dispute/{bidId}": auth.PermissionReviewLeases,
+	"/get-disputes":         auth.PermissionReviewLeases,
+	"/get-dispute/{did}":    auth.PermissionReviewLeases,
+
+	"/admin/purge-archived-resources": auth.PermissionPurgeResources,
+	"/admin/disputes":                 auth.PermissionResolveDisputes,
+	"/admin/resolve-dispute/{did}":    auth.PermissionResolveDisputes,
+	"/admin/grant-role/{uid}/{role}":  auth.PermissionManageRoles,
+	"/admin/revoke-role/{uid}/{role}": auth.PermissionManageRoles,
+	"/admin/require-supplier-2fa":     auth.PermissionManageRoles,
+	"/admin/users":                    auth.PermissionManageUsers,
+	"/admin/suspend-user/{uid}":       auth.PermissionManageUsers,
+	"/admin/unsuspend-user/{uid}":     auth.PermissionManageUsers,
+	"/admin/adjust-credits/{uid}":     auth.PermissionManageUsers,
+	"/admin/end-lease/{bidId}":        auth.PermissionManageLeases,
+	"/admin/cancel-auction/{rid}":     auth.PermissionManageLeases,
+	"/admin/remove-resource/{rid}":    auth.PermissionModerateResources,
+	"/admin/stats":                    auth.PermissionViewStats,
+	"/admin/audit-log":                auth.PermissionViewStats,
+	"/admin/login-events":             auth.PermissionViewStats,
+}
+
+// requestToken returns the JWT of a request, websockets cannot set headers so theirs is part of the path
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get("Authorization"); token != "" {
+		return token
+	}
+	return mux.Vars(r)["token"]
+}
+
+// tokenPermits checks that the JWT of a request is valid and that one of its roles grants the permission
+func tokenPermits(r *http.Request, permission auth.Permission) (*auth.Claims, error) {
+	tokenString := requestToken(r)
+	if tokenString == "" {
+		return nil, errors.New("missing token")
+	}
+	claims, err := auth.ValidateJWT(tokenString)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	if !auth.HasPermission(claims.Roles, permission) {
+		return claims, errors.New("permission denied")
+	}
+	return claims, nil
+}
+
+// authorizationStatus returns the status code of an authorization error
+func authorizationStatus(err error) int {
+	if err.Error() == "permission denied" {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// requestAPIKey returns the API key of a request, sent in the X-API-Key header or in place of the JWT
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token := r.Header.Get("Authorization"); strings.HasPrefix(token, auth.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// routePermission returns the permission of the route a request matched, unknown routes grant nothing
+func routePermission(r *http.Request) (auth.Permission, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+	permission, ok := routePermissions[template]
+	return permission, ok
+}
+
+// checkAPIKeyPermission checks the API key of a request and returns the uid of its owner. The key must hold the
+// permission as a scope and the roles its owner acts with, as a session would get them, must still grant it.
+func checkAPIKeyPermission(r *http.Request, permission auth.Permission) (string, error) {
+	key := requestAPIKey(r)
+	if key == "" {
+		return "", errors.New("missing api key")
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	uid, scopes, roles, err := pkg.UseAPIKey(db, auth.HashString(key), clientIP(r))
+	if err != nil {
+		return "", err
+	}
+	roles, _, err = effectiveRoles(db, uid, roles)
+	if err != nil {
+		return "", err
+	}
+	if !auth.ScopesAllow(scopes, permission) || !auth.HasPermission(roles, permission) {
+		return "", errors.New("permission denied")
+	}
+	return uid, nil
+}
+
+// checkSessionAuthorization checks that a request is made by a signed in user. API keys are refused, they cannot
+// manage the keys or the second factor of their owner, or a key could mint keys with more scopes than its own.
+func checkSessionAuthorization(r *http.Request) (string, error) {
+	if requestAPIKey(r) != "" {
+		return "", errors.New("permission denied")
+	}
+	return checkAuthorization(r)
+}
+
+// checkPermission checks that the request is authorized and that the roles of its token, or the scopes of its API
+// key, grant the permission
+func checkPermission(r *http.Request, permission auth.Permission) (string, error) {
+	if requestAPIKey(r) != "" {
+		return checkAPIKeyPermission(r, permission)
+	}
+	if _, err := tokenPermits(r, permission); err != nil {
+		return "", err
+	}
+	return checkAuthorization(r)
+}
+
+// AuthorizeRoute is a middleware rejecting requests whose token lacks the permission of the matched route. The
+// handlers still check the session of the token and the ownership of what they act on, and check API keys against
+// the database.
+func AuthorizeRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests carry no token
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		permission, ok := routePermissions[template]
+		if !ok {
+			log.Printf("Rejected request to %s, the route has no permission", template)
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+
+		if permission != publicRoute && requestAPIKey(r) == "" {
+			if _, err = tokenPermits(r, permission); err != nil {
+				http.Error(w, err.Error(), authorizationStatus(err))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+)
+
+var pathVariable = regexp.MustCompile(`\{[^}]+\}`)
+
+// permissionRouter serves every route of routePermissions behind AuthorizeRoute with a handler answering 200
+func permissionRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(AuthorizeRoute)
+	for template := range routePermissions {
+		router.HandleFunc(template, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	router.HandleFunc("/unlisted", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return router
+}
+
+func routeStatus(t *testing.T, router *mux.Router, path string, roles []string) int {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if roles != nil {
+		token, err := auth.GenerateJWT("testuser", roles, "7", "1")
+		if err != nil {
+			t.Fatalf("Failed to generate JWT: %v", err)
+		}
+		request.Header.Set("Authorization", token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestRoutesRejectCallersLackingPermission(t *testing.T) {
+	router := permissionRouter()
+	roleSets := [][]string{
+		{},
+		{auth.RoleRenter},
+		{auth.RoleSupplier},
+		{auth.RoleRenter, auth.RoleSupplier},
+		{auth.RoleAdmin},
+	}
+
+	for template, permission := range routePermissions {
+		path := pathVariable.ReplaceAllString(template, "1")
+
+		if permission == publicRoute {
+			if status := routeStatus(t, router, path, nil); status != http.StatusOK {
+				t.Errorf("Expected public route %s to be reachable without a token, got %d", template, status)
+			}
+			continue
+		}
+
+		if status := routeStatus(t, router, path, nil); status != http.StatusUnauthorized {
+			t.Errorf("Expected %s without a token to be unauthorized, got %d", template, status)
+		}
+		for _, roles := range roleSets {
+			want := http.StatusForbidden
+			if auth.HasPermission(roles, permission) {
+				want = http.StatusOK
+			}
+			if status := routeStatus(t, router, path, roles); status != want {
+				t.Errorf("Expected %s with roles %v to answer %d, got %d", template, roles, want, status)
+			}
+		}
+	}
+}
+
+func TestUnlistedRoutesAreRejected(t *testing.T) {
+	router := permissionRouter()
+	if status := routeStatus(t, router, "/unlisted", []string{auth.RoleAdmin}); status != http.StatusForbidden {
+		t.Errorf("Expected route without a permission to be forbidden, got %d", status)
+	}
+}
+
+func TestWebsocketRoutesTakeTheTokenFromThePath(t *testing.T) {
+	router := permissionRouter()
+	token, _ := auth.GenerateJWT("testuser", []string{auth.RoleRenter}, "7", "1")
+
+	for template, want := range map[string]int{
+		"/accept-connection-offer/1/": http.StatusOK,
+		"/make-connection-offer/1/":   http.StatusForbidden,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, template+token, nil))
+		if recorder.Code != want {
+			t.Errorf("Expected %s with a renter token in the path to answer %d, got %d", template, want, recorder.Code)
+		}
+	}
+}
+
+func TestAdminHoldsEveryPermission(t *testing.T) {
+	for template, permission := range routePermissions {
+		if permission != publicRoute && !auth.HasPermission([]string{auth.RoleAdmin}, permission) {
+			t.Errorf("Expected admins to reach %s", template)
+		}
+	}
+}
+
+func TestAPIKeyRequestsAreLeftToTheHandlers(t *testing.T) {
+	router := permissionRouter()
+	for path, want := range map[string]int{
+		"/register-agent": http.StatusOK,
+		"/unlisted":       http.StatusForbidden,
+	} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("X-API-Key", auth.APIKeyPrefix+"key")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != want {
+			t.Errorf("Expected %s with an API key to answer %d, got %d", path, want, recorder.Code)
+		}
+	}
+}
+
+func TestRequestAPIKey(t *testing.T) {
+	key := auth.APIKeyPrefix + "key"
+	for header, want := range map[string]string{
+		"X-API-Key":     key,
+		"Authorization": key,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(header, key)
+		if got := requestAPIKey(request); got != want {
+			t.Errorf("Expected the API key from %s, got %q", header, got)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "jwt")
+	if got := requestAPIKey(request); got != "" {
+		t.Errorf("Expected a JWT not to be taken for an API key, got %q", got)
+	}
+}
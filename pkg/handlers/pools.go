@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/bidding"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+	"github.com/gunrgnhsr/Cycloud/pkg/workload"
+)
+
+const maxPoolNodes = 256 // nodes per pool loan request
+
+func checkThatPoolBelongsToUser(r *http.Request, uid string, pid string) error {
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	ownerUID, err := pkg.GetPoolOwner(db, pid)
+	if err != nil {
+		return err
+	}
+
+	if ownerUID != uid {
+		return errors.New("pool does not belong to user")
+	}
+
+	return nil
+}
+
+// decodePoolMembers reads the resource IDs of a pool request body
+func decodePoolMembers(r *http.Request) (string, []string, error) {
+	var body struct {
+		Name string   `json:"name"`
+		RIDs []string `json:"rids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return "", nil, errors.New("Invalid request body")
+	}
+	if len(body.RIDs) == 0 {
+		return "", nil, errors.New("Missing resource IDs")
+	}
+	seen := map[string]bool{}
+	for _, rid := range body.RIDs {
+		if seen[rid] {
+			return "", nil, errors.New("Duplicate resource ID " + rid)
+		}
+		seen[rid] = true
+	}
+	return strings.TrimSpace(body.Name), body.RIDs, nil
+}
+
+// AddPool handles the creation of a pool from resources of the user.
+func AddPool(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	name, rids, err := decodePoolMembers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name == "" {
+		http.Error(w, "Missing pool name", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	pid, err := pkg.InsertNewPool(db, uid, name, rids)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "resources must") {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Pool created successfully", "pid": pid})
+}
+
+// AddPoolMembers handles adding resources of the user to one of their pools.
+func AddPoolMembers(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	pid := mux.Vars(r)["pid"]
+	if pid == "" {
+		http.Error(w, "Missing pool ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatPoolBelongsToUser(r, uid, pid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, rids, err := decodePoolMembers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.AddPoolMembers(db, pid, uid, rids)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "resources must") {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Pool members added successfully"})
+}
+
+// RemovePoolMember handles removing an idle resource from a pool of the user.
+func RemovePoolMember(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	pid := mux.Vars(r)["pid"]
+	rid := mux.Vars(r)["rid"]
+	if pid == "" || rid == "" {
+		http.Error(w, "Missing pool or resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatPoolBelongsToUser(r, uid, pid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.RemovePoolMember(db, pid, rid)
+	if err != nil {
+		if err.Error() == "resource is not an idle member of the pool" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Pool member removed successfully"})
+}
+
+// DeletePool handles the deletion of a pool of the user, its resources are kept as regular resources.
+func DeletePool(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	pid := mux.Vars(r)["pid"]
+	if pid == "" {
+		http.Error(w, "Missing pool ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatPoolBelongsToUser(r, uid, pid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.DeletePool(db, pid)
+	if err != nil {
+		if err.Error() == "pool has nodes currently computing" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Pool deleted successfully"})
+}
+
+// GetUserPools handles the retrieval of the user's pools.
+func GetUserPools(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	pools, err := pkg.GetUserPools(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pools)
+}
+
+// GetAvailablePools handles the retrieval of the pools of other users with available nodes.
+func GetAvailablePools(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	pools, err := pkg.GetAvailablePools(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pools)
+}
+
+// GetPoolLeases handles the retrieval of the running leases of a pool of the user.
+func GetPoolLeases(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	pid := mux.Vars(r)["pid"]
+	if pid == "" {
+		http.Error(w, "Missing pool ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatPoolBelongsToUser(r, uid, pid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	leases, err := pkg.GetActivePoolLeases(db, pid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(leases)
+}
+
+// PlacePoolBid handles a loan request for several nodes of a pool. The nodes are allocated all-or-nothing and
+// without an auction, the renter then opens one signaling session per node using the node's resource ID.
+func PlacePoolBid(w http.ResponseWriter, r *http.Request) {
+	if handleSSERequestCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var bid models.PoolBid
+	err = json.NewDecoder(r.Body).Decode(&bid)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if bid.PID == "" || bid.Nodes <= 0 || bid.Nodes > maxPoolNodes || bid.Amount <= 0 || bid.Duration <= 0 {
+		http.Error(w, fmt.Sprintf("A pool loan request needs a pool, 1 to %d nodes and a positive amount and duration", maxPoolNodes), http.StatusBadRequest)
+		return
+	}
+	if bid.Workload != nil {
+		*bid.Workload = workload.Normalize(*bid.Workload)
+		err = workload.Validate(*bid.Workload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	lease, errType, err := pkg.AllocatePoolLease(db, uid, bid)
+	if err != nil {
+		if errType == "insufficient credits to place bid" {
+			http.Error(w, err.Error(), http.StatusPaymentRequired)
+			return
+		}
+		if errType == "not enough nodes" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	nodes := []map[string]string{}
+	for _, nodeBid := range lease.NodeBids {
+		bidding.RegisterLease(nodeBid)
+		nodes = append(nodes, map[string]string{"rid": nodeBid.RID, "bid": nodeBid.BID})
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	started, _ := json.Marshal(map[string]interface{}{"data": "starting connection", "plid": lease.PLID, "nodes": nodes})
+	fmt.Fprintf(w, "%s\n\n", started)
+	flusher.Flush()
+
+	timer := time.NewTimer(time.Duration(lease.Duration) * time.Minute)
+	<-timer.C
+	for _, nodeBid := range lease.NodeBids {
+		err = pkg.FinishCompute(db, nodeBid.RID, uid, nodeBid)
+		if err != nil {
+			log.Printf("Failed to finish pool lease %s node %s: %v", lease.PLID, nodeBid.RID, err)
+		}
+	}
+	fmt.Fprintf(w, `{"data": "%s"}`+"\n\n", "connection ended")
+	flusher.Flush()
+	<-r.Context().Done()
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// poolNode is a candidate node of a pool as the allocation reads it, the policies are JSON or nil
+func poolNode(rid string, costPerHour float64, workloadPolicy driver.Value, acceptancePolicy driver.Value) []driver.Value {
+	return []driver.Value{rid, costPerHour, int64(100), nil, workloadPolicy, acceptancePolicy}
+}
+
+// poolNodes answers the lookup of the candidate nodes of a pool
+func poolNodes(nodes ...[]driver.Value) fakeQuery {
+	return fakeQuery{match: "SKIP LOCKED", columns: []string{"rid", "cost_per_hour", "storage", "rules", "workload", "acceptance"}, rows: nodes}
+}
+
+// nodeAllocation answers the statements allocating one node
+func nodeAllocation(rid string) []fakeQuery {
+	return []fakeQuery{
+		row("INSERT INTO .bids", "b"+rid, rid, 5.0, int64(2), "accepted", true, time.Now()),
+		exec("UPDATE .resources SET computing = true", 1),
+		exec("INSERT INTO .clearing_prices", 1),
+	}
+}
+
+func TestAllocatePoolLeaseWithEnoughNodes(t *testing.T) {
+	queries := []fakeQuery{
+		noRows("FROM .reputations"),
+		poolNodes(poolNode("1", 3, nil, nil), poolNode("2", 4, nil, nil), poolNode("3", 4.5, nil, nil)),
+		row("SELECT credits FROM .wallets", 100.0),
+		row("COALESCE(SUM(amount * duration), 0)", 0.0),
+		row("INSERT INTO .pool_leases", "9", time.Now()),
+	}
+	queries = append(queries, nodeAllocation("1")...)
+	queries = append(queries, nodeAllocation("2")...)
+	db, fake := newFakeDB(t, queries...)
+
+	lease, errType, err := pkg.AllocatePoolLease(db, "8", models.PoolBid{PID: "6", Nodes: 2, Amount: 5, Duration: 2})
+	if err != nil {
+		t.Fatalf("Failed to allocate pool lease (%s): %v", errType, err)
+	}
+	if lease.PLID != "9" || len(lease.NodeBids) != 2 || lease.NodeBids[0].RID != "1" || lease.NodeBids[1].RID != "2" {
+		t.Errorf("Expected the two cheapest nodes to be leased, got %+v", lease)
+	}
+	if args, _ := fake.args("UPDATE .resources SET computing = true"); args[0] != "2" {
+		t.Errorf("Expected the leased nodes to be marked computing, got %v", args)
+	}
+}
+
+func TestAllocatePoolLeaseWithTooFewNodes(t *testing.T) {
+	db, fake := newFakeDB(t,
+		noRows("FROM .reputations"),
+		poolNodes(poolNode("1", 3, nil, nil)),
+	)
+
+	_, errType, err := pkg.AllocatePoolLease(db, "8", models.PoolBid{PID: "6", Nodes: 2, Amount: 5, Duration: 2})
+	if err == nil || errType != "not enough nodes" {
+		t.Fatalf("Expected the allocation to fail for lack of nodes, got %q: %v", errType, err)
+	}
+	if fake.ran("INSERT INTO .pool_leases") || fake.ran("INSERT INTO .bids") {
+		t.Errorf("Expected no node to be leased when the pool cannot serve every node")
+	}
+}
+
+func TestAllocatePoolLeaseSkipsNodesRejectingTheBid(t *testing.T) {
+	nodes := map[string][]driver.Value{
+		"price":      poolNode("1", 6, nil, nil),
+		"workload":   poolNode("1", 3, []byte(`{"requireWorkload": true}`), nil),
+		"acceptance": poolNode("1", 3, nil, []byte(`{"blockedRenters": ["8"]}`)),
+	}
+	for name, node := range nodes {
+		t.Run(name, func(t *testing.T) {
+			db, fake := newFakeDB(t,
+				noRows("FROM .reputations"),
+				poolNodes(node, poolNode("2", 4, nil, nil)),
+			)
+
+			_, errType, err := pkg.AllocatePoolLease(db, "8", models.PoolBid{PID: "6", Nodes: 2, Amount: 5, Duration: 2})
+			if err == nil || errType != "not enough nodes" {
+				t.Fatalf("Expected the node rejecting the bid to be skipped, got %q: %v", errType, err)
+			}
+			if fake.ran("INSERT INTO .bids") {
+				t.Errorf("Expected no node to be leased")
+			}
+		})
+	}
+}
+
+func TestAllocatePoolLeaseWithInsufficientCredits(t *testing.T) {
+	db, fake := newFakeDB(t,
+		noRows("FROM .reputations"),
+		poolNodes(poolNode("1", 3, nil, nil), poolNode("2", 4, nil, nil)),
+		row("SELECT credits FROM .wallets", 19.0),
+		row("COALESCE(SUM(amount * duration), 0)", 0.0),
+	)
+
+	_, errType, err := pkg.AllocatePoolLease(db, "8", models.PoolBid{PID: "6", Nodes: 2, Amount: 5, Duration: 2})
+	if err == nil || errType != "insufficient credits to place bid" {
+		t.Fatalf("Expected the allocation to need credits for every node, got %q: %v", errType, err)
+	}
+	if fake.ran("INSERT INTO .pool_leases") {
+		t.Errorf("Expected no lease to be recorded")
+	}
+}
+
+func TestPlacePoolBidValidatesTheRequest(t *testing.T) {
+	bodies := map[string]string{
+		"invalid JSON":      `{"pid": `,
+		"no pool":           `{"nodes": 2, "amount": 5, "duration": 2}`,
+		"no nodes":          `{"pid": "6", "nodes": 0, "amount": 5, "duration": 2}`,
+		"too many nodes":    `{"pid": "6", "nodes": 257, "amount": 5, "duration": 2}`,
+		"no amount":         `{"pid": "6", "nodes": 2, "amount": 0, "duration": 2}`,
+		"negative duration": `{"pid": "6", "nodes": 2, "amount": 5, "duration": -1}`,
+		"invalid workload":  `{"pid": "6", "nodes": 2, "amount": 5, "duration": 2, "workload": {"image": ""}}`,
+	}
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			db, fake := newFakeDB(t, sessionQuery("7"))
+			request := sessionRequest(t, http.MethodPost, "/place-pool-loan-request", body, []string{auth.RoleRenter})
+
+			recorder := httptest.NewRecorder()
+			PlacePoolBid(recorder, withDB(request, db))
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("Expected the request to be refused with %d, got %d: %s", http.StatusBadRequest, recorder.Code, recorder.Body)
+			}
+			if fake.ran("SKIP LOCKED") {
+				t.Errorf("Expected no nodes to be allocated")
+			}
+		})
+	}
+}
+
+func TestPlacePoolBidWithTooFewNodes(t *testing.T) {
+	db, _ := newFakeDB(t,
+		sessionQuery("7"),
+		noRows("FROM .reputations"),
+		poolNodes(poolNode("1", 3, nil, nil)),
+	)
+	request := sessionRequest(t, http.MethodPost, "/place-pool-loan-request", `{"pid": "6", "nodes": 2, "amount": 5, "duration": 2}`, []string{auth.RoleRenter})
+
+	recorder := httptest.NewRecorder()
+	PlacePoolBid(recorder, withDB(request, db))
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a pool without enough nodes to be refused with %d, got %d", http.StatusPreconditionFailed, recorder.Code)
+	}
+}
+
+func TestFinishComputeReturnsPoolNodesToTheirPool(t *testing.T) {
+	db, fake := newFakeDB(t,
+		exec("UPDATE .bids SET computing = false", 1),
+		row("UPDATE .resources SET computing = false", "9"),
+		exec("UPDATE .wallets", 1),
+		exec("INSERT INTO .escrows", 1),
+		exec("INSERT INTO .ledger", 1),
+	)
+	bid := models.BidWithID{BID: "5", Bid: models.Bid{RID: "4", Amount: 2, Duration: 10}}
+
+	if err := pkg.FinishCompute(db, "4", "8", bid); err != nil {
+		t.Fatalf("Failed to finish compute: %v", err)
+	}
+	if !fake.ran("EXISTS (SELECT 1 FROM .pool_members WHERE rid = $1)") {
+		t.Errorf("Expected pool nodes to be listed again as their lease ends")
+	}
+}
+
+func TestAllocatePoolLeaseSkipsNodesUnderMaintenanceDuringTheLease(t *testing.T) {
+	db, fake := newFakeDB(t,
+		noRows("FROM .reputations"),
+		poolNodes(),
+	)
+
+	before := time.Now()
+	pkg.AllocatePoolLease(db, "8", models.PoolBid{PID: "6", Nodes: 1, Amount: 5, Duration: 10})
+	args, _ := fake.args("SKIP LOCKED")
+	if !fake.ran("r.maintenanceStart >= $3 OR r.maintenanceEnd <= $4") || len(args) != 4 {
+		t.Fatalf("Expected nodes to be filtered on their maintenance window, got %v", args)
+	}
+	if leaseEnd := args[2].(time.Time); leaseEnd.Before(before.Add(10 * time.Hour)) {
+		t.Errorf("Expected nodes under maintenance before %v to be skipped, got %v", before.Add(10*time.Hour), leaseEnd)
+	}
+}
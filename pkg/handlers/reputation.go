@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/reputation"
+	"github.com/gunrgnhsr/Cycloud/pkg/settlement"
+)
+
+// recordLeaseEvent counts an event of a lease against the reputation of uid, failures only cost the signal
+func recordLeaseEvent(db *sql.DB, bid string, uid string, event string) {
+	err := pkg.RecordLeaseEvent(db, bid, uid, event)
+	if err != nil {
+		log.Printf("Failed to record %s of bid %s: %v", event, bid, err)
+	}
+}
+
+// recordConnection counts a lease whose peers both showed up towards the reputation of its supplier and renter
+func recordConnection(db *sql.DB, bid string, supplierUID string) {
+	renterUID, err := pkg.GetBidOwner(db, bid)
+	if err != nil {
+		log.Printf("Failed to record connection of bid %s: %v", bid, err)
+		return
+	}
+	recordLeaseEvent(db, bid, supplierUID, string(settlement.Connected))
+	recordLeaseEvent(db, bid, renterUID, string(settlement.Connected))
+}
+
+// RateLease handles a party of a lease that is over rating and commenting on the other party.
+func RateLease(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	bidId := mux.Vars(r)["bidId"]
+	if bidId == "" {
+		http.Error(w, "Missing bid ID", http.StatusBadRequest)
+		return
+	}
+
+	var rating struct {
+		Score   int    `json:"score"`
+		Comment string `json:"comment"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&rating)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err = reputation.ValidateRating(rating.Score, rating.Comment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.InsertRating(db, bidId, uid, rating.Score, rating.Comment)
+	if err != nil {
+		switch err.Error() {
+		case "bid not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "user is not a party of the lease":
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case "lease is not over", "lease already rated":
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Lease rated"})
+}
+
+// GetResourceRatings handles the retrieval of the ratings renters gave the supplier of a resource.
+func GetResourceRatings(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	_, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	ratings, err := pkg.GetResourceRatings(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ratings)
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import "testing"
+
+func TestRecordConnectionCountsALeaseOnce(t *testing.T) {
+	// The lease was already recorded as connected, the peers reconnecting leaves the reputations alone
+	db, fake := newFakeDB(t,
+		row("SELECT uid FROM .bids", "8"),
+		exec("INSERT INTO .lease_events", 0),
+		exec("INSERT INTO .lease_events", 0),
+	)
+
+	recordConnection(db, "5", "7")
+	if args, _ := fake.args("INSERT INTO .lease_events"); args[1] != "8" {
+		t.Errorf("Expected the connection of the renter to be recorded, got %v", args)
+	}
+	if fake.ran("INTO .reputations") {
+		t.Errorf("Expected a reconnection not to update the reputations")
+	}
+}
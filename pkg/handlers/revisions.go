@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+	"github.com/gunrgnhsr/Cycloud/pkg/hardware"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// UpdateResource handles partial updates of a resource's spec and cost, recording every change as a revision.
+func UpdateResource(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "PATCH") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	current, err := pkg.GetResourceByID(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if current.Status == models.ResourceArchived {
+		http.Error(w, "resource is archived", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Fields missing from the request body keep their current value, labels in the body are merged into the current ones.
+	// Slices and maps are copied so decoding doesn't overwrite the current spec.
+	updated := current.Resource
+	updated.GPUs = append([]models.GPU{}, current.Resource.GPUs...)
+	updated.Tags = append([]string{}, current.Resource.Tags...)
+	updated.Labels = map[string]string{}
+	for key, value := range current.Resource.Labels {
+		updated.Labels[key] = value
+	}
+	err = json.NewDecoder(r.Body).Decode(&updated)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Availability is changed through its own endpoint and computing by leases
+	updated.Available = current.Resource.Available
+	updated.Computing = current.Resource.Computing
+
+	// Derive the GPU summary again when it was derived from GPU entries that changed
+	if updated.GPU == current.Resource.GPU && current.Resource.GPU == hardware.DescribeGPUs(current.Resource.GPUs) && !reflect.DeepEqual(updated.GPUs, current.Resource.GPUs) {
+		updated.GPU = ""
+	}
+
+	updated = hardware.Normalize(updated)
+	err = hardware.Validate(updated)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	specChanged := hardware.SpecChanged(current.Resource, updated)
+	if !specChanged && updated.CostPerMinute == current.Resource.CostPerMinute {
+		http.Error(w, "No changes to apply", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := pkg.UpdateResource(db, rid, uid, updated, specChanged)
+	if err != nil {
+		if err.Error() == "resource is currently computing" || err.Error() == "resource is under auction" {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Resource updated successfully", "revision": revision})
+}
+
+// GetResourceRevisions handles the retrieval of the change history of a resource.
+func GetResourceRevisions(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rid := mux.Vars(r)["rid"]
+	if rid == "" {
+		http.Error(w, "Missing resource ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatResourceBelongsToUser(r, uid, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	revisions, err := pkg.GetResourceRevisions(db, rid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the revisions
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetLoanRequestAcceptedSpec handles the retrieval of the resource spec in force when a loan request was accepted.
+func GetLoanRequestAcceptedSpec(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	bidId := mux.Vars(r)["bidId"]
+	if bidId == "" {
+		http.Error(w, "Missing bid ID", http.StatusBadRequest)
+		return
+	}
+
+	err = checkThatBidBelongsToUser(r, uid, bidId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	revision, err := pkg.GetBidResourceRevision(db, bidId)
+	if err != nil {
+		if err.Error() == "bid has not been accepted" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the revision
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revision)
+}
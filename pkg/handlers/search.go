@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+// parseResourceSearch reads the resource search filters, ordering and page from the query string
+func parseResourceSearch(query url.Values) (pkg.ResourceSearch, error) {
+	search := pkg.ResourceSearch{
+		GPU:         query.Get("gpu"),
+		CPUArch:     strings.ToLower(query.Get("cpuArch")),
+		OS:          query.Get("os"),
+		CUDAVersion: query.Get("cudaVersion"),
+		Region:      query.Get("region"),
+		Zone:        query.Get("zone"),
+		Sort:        query.Get("sort"),
+		Cursor:      query.Get("cursor"),
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"minCores", &search.MinCores},
+		{"minMemory", &search.MinMemory},
+		{"minStorage", &search.MinStorage},
+		{"minBandwidth", &search.MinBandwidth},
+		{"minGpus", &search.MinGPUs},
+		{"minVram", &search.MinVRAM},
+		{"limit", &search.PageSize},
+	}
+	for _, param := range ints {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return search, errors.New("invalid " + param.name)
+			}
+			*param.value = parsed
+		}
+	}
+
+	floats := []struct {
+		name  string
+		value *float64
+	}{
+		{"maxPrice", &search.MaxPrice},
+		{"minReputation", &search.MinReputation},
+	}
+	for _, param := range floats {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 {
+				return search, errors.New("invalid " + param.name)
+			}
+			*param.value = parsed
+		}
+	}
+
+	if value := query.Get("tags"); value != "" {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+				search.Tags = append(search.Tags, tag)
+			}
+		}
+	}
+
+	// Labels are given as repeated label=key:value parameters
+	for _, label := range query["label"] {
+		key, value, found := strings.Cut(label, ":")
+		if !found || key == "" {
+			return search, errors.New("invalid label, expected key:value")
+		}
+		if search.Labels == nil {
+			search.Labels = map[string]string{}
+		}
+		search.Labels[strings.ToLower(key)] = value
+	}
+
+	if value := query.Get("verified"); value != "" {
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			return search, errors.New("invalid verified")
+		}
+		search.VerifiedOnly = verified
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		search.Descending = true
+	default:
+		return search, errors.New("invalid order, expected asc or desc")
+	}
+
+	return search, nil
+}
+
+// SearchResources handles searching the available resources with filters, sorting and cursor pagination.
+func SearchResources(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	search, err := parseResourceSearch(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	resources, nextCursor, err := pkg.SearchResources(db, uid, search)
+	if err != nil {
+		if err.Error() == "failed to search resources" {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Return the resources page
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resources":  resources,
+		"nextCursor": nextCursor,
+	})
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+const maxDeviceLength = 256 // longer User-Agent headers are cut when stored with a session
+
+// clientIP returns the address a request was made from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionTokens returns the response of a sign in or a refresh
+func sessionTokens(accessToken string, refreshToken string) map[string]interface{} {
+	return map[string]interface{}{"token": accessToken, "refreshToken": refreshToken, "expiresIn": auth.AccessTokenLifetime().Seconds()}
+}
+
+// startSession records a session for the device of a request and issues its access and refresh tokens, the access
+// token carries the session roles of the user
+func startSession(r *http.Request, uid string, username string) (map[string]interface{}, error) {
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	device := r.UserAgent()
+	if len(device) > maxDeviceLength {
+		device = device[:maxDeviceLength]
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	roles, totpSetupRequired, err := sessionRoles(db, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := pkg.InsertSession(db, uid, device, clientIP(r), refreshHash, time.Now().Add(auth.RefreshTokenLifetime()))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := auth.GenerateJWT(username, roles, uid, sid)
+	if err != nil {
+		return nil, err
+	}
+	tokens := sessionTokens(accessToken, refreshToken)
+	if totpSetupRequired {
+		tokens["twoFactorSetupRequired"] = true
+	}
+	return tokens, nil
+}
+
+// RefreshToken handles the exchange of a refresh token for a new access token and a new refresh token. Each refresh
+// token works once, reusing one revokes its session.
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "content-type", "POST") {
+		return
+	}
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	sid, uid, username, err := pkg.RotateRefreshToken(db, auth.HashString(body.RefreshToken), refreshHash, time.Now().Add(auth.RefreshTokenLifetime()))
+	if err != nil {
+		if err.Error() == "invalid refresh token" || err.Error() == "refresh token reused" {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Roles granted or revoked, and two-factor authentication enabled, since the last refresh take effect with the
+	// new access token
+	roles, _, err := sessionRoles(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := auth.GenerateJWT(username, roles, uid, sid)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessionTokens(accessToken, refreshToken))
+}
+
+// GetSessions handles the request for the signed in devices of the user, marking the one making the request
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, sid, err := checkSessionWithToken(r, r.Header.Get("Authorization"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	sessions, err := pkg.GetUserSessions(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SID == sid
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession handles signing out one of the devices of the user
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "DELETE") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	sid := mux.Vars(r)["sid"]
+	if sid == "" {
+		http.Error(w, "Missing session ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.RevokeSession(db, uid, sid)
+	if err != nil {
+		if err.Error() == "session not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session revoked"})
+}
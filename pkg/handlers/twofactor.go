@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/auth"
+	pkg "github.com/gunrgnhsr/Cycloud/pkg/db"
+)
+
+const maxTOTPAccountLength = 100 // bounds the account name shown in authenticator apps
+
+// supplierTOTPRequired reports whether admins require suppliers to use two-factor authentication
+func supplierTOTPRequired(db *sql.DB) (bool, error) {
+	value, _, err := pkg.GetSetting(db, pkg.SettingRequireSupplierTOTP)
+	if err != nil {
+		return false, err
+	}
+	return value == "true", nil
+}
+
+// hasRole reports whether the roles include the role
+func hasRole(roles []string, role string) bool {
+	for _, held := range roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionRoles returns the roles the tokens of a user carry, see effectiveRoles
+func sessionRoles(db *sql.DB, uid string) ([]string, bool, error) {
+	roles, err := pkg.GetUserRoles(db, uid)
+	if err != nil {
+		return nil, false, err
+	}
+	return effectiveRoles(db, uid, roles)
+}
+
+// effectiveRoles returns the roles a user acts with, from the roles stored for it. While suppliers are required to use
+// two-factor authentication, a supplier without it gets no supplier role until enabling it, and true is returned so the client
+// can ask for the enrollment.
+func effectiveRoles(db *sql.DB, uid string, roles []string) ([]string, bool, error) {
+	if !hasRole(roles, auth.RoleSupplier) {
+		return roles, false, nil
+	}
+
+	required, err := supplierTOTPRequired(db)
+	if err != nil || !required {
+		return roles, false, err
+	}
+	_, enabled, _, err := pkg.GetTOTPState(db, uid)
+	if err != nil || enabled {
+		return roles, false, err
+	}
+
+	granted := []string{}
+	for _, role := range roles {
+		if role != auth.RoleSupplier {
+			granted = append(granted, role)
+		}
+	}
+	return granted, true, nil
+}
+
+// decodeTOTPCode reads the TOTP or recovery code of the request body
+func decodeTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || strings.TrimSpace(body.Code) == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	return strings.TrimSpace(body.Code), true
+}
+
+// verifySecondFactor checks a TOTP code or spends a recovery code of a user with two-factor authentication enabled
+func verifySecondFactor(db *sql.DB, uid string, code string) error {
+	secret, enabled, lastStep, err := pkg.GetTOTPState(db, uid)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return errors.New("two-factor authentication not enabled")
+	}
+
+	if !auth.IsTOTPCode(code) {
+		return pkg.UseRecoveryCode(db, uid, auth.HashString(auth.NormalizeRecoveryCode(code)))
+	}
+	step, ok := auth.VerifyTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return errors.New("invalid code")
+	}
+	return pkg.UseTOTPStep(db, uid, step)
+}
+
+// secondFactorStatus returns the status of an error checking the second factor of a signed in user
+func secondFactorStatus(err error) int {
+	switch err.Error() {
+	case "invalid code":
+		return http.StatusForbidden
+	case "two-factor authentication not enabled":
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// startLoginChallenge answers a login of a user with two-factor authentication, the tokens are only issued by
+// VerifyLogin once the second factor is given with the challenge. The failed logins of the user are kept until then.
+func startLoginChallenge(w http.ResponseWriter, r *http.Request, uid string) {
+	challenge, challengeHash, err := auth.GenerateLoginChallenge()
+	if err != nil {
+		http.Error(w, "Failed to generate login challenge", http.StatusInternalServerError)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.InsertLoginChallenge(db, uid, challengeHash, time.Now().Add(auth.LoginChallengeLifetime))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"twoFactorRequired": true, "challenge": challenge, "expiresIn": auth.LoginChallengeLifetime.Seconds()})
+}
+
+// VerifyLogin handles the second step of the login of a user with two-factor authentication. The challenge given by
+// Login is exchanged with a TOTP or recovery code for the tokens of a new session.
+func VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "content-type", "POST") {
+		return
+	}
+
+	var body struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Challenge == "" || strings.TrimSpace(body.Code) == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	challengeHash := auth.HashString(body.Challenge)
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	uid, username, err := pkg.AttemptLoginChallenge(db, challengeHash, auth.LoginChallengeAttempts)
+	if err != nil {
+		if err.Error() == "invalid or expired challenge" {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Second factors are guessed as passwords are, they count against the same throttle
+	attempt, refused := throttleLogin(w, r, username)
+	if refused {
+		return
+	}
+	defer forgetLoginAttempt(attempt)
+
+	err = verifySecondFactor(db, uid, strings.TrimSpace(body.Code))
+	if err != nil {
+		if err.Error() == "invalid code" || err.Error() == "two-factor authentication not enabled" {
+			loginFailed(w, r, attempt, uid, username, loginEventSecondFactorFailed, "invalid code")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = pkg.CompleteLoginChallenge(db, challengeHash)
+	if err != nil {
+		if err.Error() == "invalid or expired challenge" {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	loginSucceeded(attempt, username)
+
+	// Start a session for the device and issue its tokens
+	tokens, err := startSession(r, uid, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// GetTOTPStatus handles the request for whether the user has two-factor authentication and how many recovery codes
+// are left
+func GetTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization", "GET") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	_, enabled, _, err := pkg.GetTOTPState(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recoveryCodes, err := pkg.CountRecoveryCodes(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	required, err := supplierTOTPRequired(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"enabled": enabled, "recoveryCodesLeft": recoveryCodes, "requiredForSuppliers": required})
+}
+
+// EnrollTOTP handles the start of the enrollment of two-factor authentication. It returns a new secret with its
+// otpauth URI, the secret only takes effect once ConfirmTOTP is given a code of it.
+func EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	// Usernames are only stored hashed, the client names the account shown in authenticator apps
+	var body struct {
+		Account string `json:"account"`
+	}
+	if r.ContentLength != 0 {
+		if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	account := strings.TrimSpace(body.Account)
+	if account == "" || len(account) > maxTOTPAccountLength {
+		account = "user " + uid
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = pkg.SetPendingTOTPSecret(db, uid, secret)
+	if err != nil {
+		if err.Error() == "two-factor authentication already enabled" {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"secret": secret, "uri": auth.TOTPURI(account, secret)})
+}
+
+// ConfirmTOTP handles the end of the enrollment of two-factor authentication with a code of the new secret. The
+// recovery codes of the user are returned once.
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	secret, enabled, lastStep, err := pkg.GetTOTPState(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	if secret == "" {
+		http.Error(w, "two-factor authentication not enrolled", http.StatusPreconditionFailed)
+		return
+	}
+
+	step, ok := auth.VerifyTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		http.Error(w, "invalid code", http.StatusForbidden)
+		return
+	}
+
+	codes, codeHashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	err = pkg.EnableTOTP(db, uid, step, codeHashes)
+	if err != nil {
+		if err.Error() == "two-factor authentication already enabled" {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Two-factor authentication enabled, store the recovery codes now as they will not be shown again", "recoveryCodes": codes})
+}
+
+// DisableTOTP handles the user turning off two-factor authentication with a TOTP or recovery code
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = verifySecondFactor(db, uid, code)
+	if err != nil {
+		http.Error(w, err.Error(), secondFactorStatus(err))
+		return
+	}
+
+	err = pkg.DisableTOTP(db, uid)
+	if err != nil {
+		http.Error(w, err.Error(), secondFactorStatus(err))
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes handles the replacement of the recovery codes of the user with a TOTP or recovery code,
+// the new codes are returned once
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	uid, err := checkSessionAuthorization(r)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	err = verifySecondFactor(db, uid, code)
+	if err != nil {
+		http.Error(w, err.Error(), secondFactorStatus(err))
+		return
+	}
+
+	codes, codeHashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	err = pkg.ReplaceRecoveryCodes(db, uid, codeHashes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Recovery codes replaced, store them now as they will not be shown again", "recoveryCodes": codes})
+}
+
+// RequireSupplierTOTP handles an admin requiring, or no longer requiring, suppliers to use two-factor
+// authentication. Suppliers without it lose their supplier role from their next sign in or token refresh.
+func RequireSupplierTOTP(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, "Authorization, content-type", "POST") {
+		return
+	}
+
+	// Check if the request is authorized
+	adminUID, err := checkPermission(r, auth.PermissionManageRoles)
+	if err != nil {
+		http.Error(w, err.Error(), authorizationStatus(err))
+		return
+	}
+
+	var body struct {
+		Required *bool `json:"required"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Required == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get the database connection from the request context
+	db := getDB(r)
+
+	value := "false"
+	if *body.Required {
+		value = "true"
+	}
+	audit := adminAudit(adminUID, auditRequireSupplierTOTP, pkg.SettingRequireSupplierTOTP, map[string]interface{}{"required": *body.Required})
+	err = pkg.SetSetting(db, pkg.SettingRequireSupplierTOTP, value, audit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return a success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Supplier two-factor requirement updated", "required": *body.Required})
+}
@@ -0,0 +1,191 @@
+package hardware
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const (
+	maxGPUsPerModel = 16
+	maxTags         = 20
+	maxLabels       = 20
+	maxLabelValue   = 128
+)
+
+var (
+	locationPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+	tagPattern      = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+	cudaPattern     = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,2}$`)
+)
+
+// Aliases of the supported CPU architectures
+var cpuArchs = map[string]string{
+	"x86_64":  "x86_64",
+	"amd64":   "x86_64",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+	"ppc64le": "ppc64le",
+	"riscv64": "riscv64",
+}
+
+// GPUCount returns the total number of GPUs of the resource.
+func GPUCount(resource models.Resource) int {
+	count := 0
+	for _, gpu := range resource.GPUs {
+		count += gpu.Count
+	}
+	return count
+}
+
+// MaxVRAM returns the VRAM of the resource's largest GPU in GB.
+func MaxVRAM(resource models.Resource) int {
+	vram := 0
+	for _, gpu := range resource.GPUs {
+		if gpu.VRAM > vram {
+			vram = gpu.VRAM
+		}
+	}
+	return vram
+}
+
+// DescribeGPUs summarises GPU entries as text, e.g. "4x NVIDIA A100 80GB".
+func DescribeGPUs(gpus []models.GPU) string {
+	descriptions := []string{}
+	for _, gpu := range gpus {
+		description := fmt.Sprintf("%dx %s", gpu.Count, gpu.Model)
+		if gpu.VRAM > 0 {
+			description += fmt.Sprintf(" %dGB", gpu.VRAM)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// Normalize canonicalises a resource's free-form fields: trims text, lowercases tags and locations,
+// maps CPU architecture aliases and derives the GPU summary from the GPU entries.
+func Normalize(resource models.Resource) models.Resource {
+	resource.GPU = strings.TrimSpace(resource.GPU)
+	resource.CPUArch = strings.ToLower(strings.TrimSpace(resource.CPUArch))
+	if arch, ok := cpuArchs[resource.CPUArch]; ok {
+		resource.CPUArch = arch
+	}
+	resource.CPUModel = strings.TrimSpace(resource.CPUModel)
+	resource.OS = strings.TrimSpace(resource.OS)
+	resource.CUDAVersion = strings.TrimSpace(resource.CUDAVersion)
+	resource.Region = strings.ToLower(strings.TrimSpace(resource.Region))
+	resource.Zone = strings.ToLower(strings.TrimSpace(resource.Zone))
+
+	gpus := []models.GPU{}
+	for _, gpu := range resource.GPUs {
+		gpu.Model = strings.TrimSpace(gpu.Model)
+		gpus = append(gpus, gpu)
+	}
+	resource.GPUs = gpus
+	if resource.GPU == "" {
+		resource.GPU = DescribeGPUs(resource.GPUs)
+	}
+
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, tag := range resource.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	resource.Tags = tags
+
+	labels := map[string]string{}
+	for key, value := range resource.Labels {
+		labels[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	resource.Labels = labels
+
+	return resource
+}
+
+// Validate checks that a normalized resource spec is complete and consistent.
+func Validate(resource models.Resource) error {
+	if resource.CPUCores <= 0 {
+		return errors.New("cpu cores must be positive")
+	}
+	if resource.Memory <= 0 {
+		return errors.New("memory must be positive")
+	}
+	if resource.Storage < 0 || resource.Bandwidth < 0 || resource.EgressLimit < 0 {
+		return errors.New("storage, bandwidth and egress limit must not be negative")
+	}
+	if resource.CostPerMinute < 0 {
+		return errors.New("cost per hour must not be negative")
+	}
+
+	for _, gpu := range resource.GPUs {
+		if gpu.Model == "" {
+			return errors.New("gpu model is required")
+		}
+		if gpu.Count < 1 || gpu.Count > maxGPUsPerModel {
+			return fmt.Errorf("gpu count must be between 1 and %d", maxGPUsPerModel)
+		}
+		if gpu.VRAM < 0 {
+			return errors.New("gpu vram must not be negative")
+		}
+	}
+
+	if resource.CPUArch != "" {
+		if _, ok := cpuArchs[resource.CPUArch]; !ok {
+			return errors.New("unsupported cpu architecture " + resource.CPUArch)
+		}
+	}
+	if resource.CUDAVersion != "" {
+		if !cudaPattern.MatchString(resource.CUDAVersion) {
+			return errors.New("invalid cuda version " + resource.CUDAVersion)
+		}
+		if len(resource.GPUs) == 0 {
+			return errors.New("cuda version requires at least one gpu")
+		}
+	}
+	if resource.Region != "" && !locationPattern.MatchString(resource.Region) {
+		return errors.New("invalid region " + resource.Region)
+	}
+	if resource.Zone != "" && !locationPattern.MatchString(resource.Zone) {
+		return errors.New("invalid zone " + resource.Zone)
+	}
+
+	if len(resource.Tags) > maxTags {
+		return fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range resource.Tags {
+		if !tagPattern.MatchString(tag) {
+			return errors.New("invalid tag " + tag)
+		}
+	}
+	if len(resource.Labels) > maxLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxLabels)
+	}
+	for key, value := range resource.Labels {
+		if !tagPattern.MatchString(key) {
+			return errors.New("invalid label key " + key)
+		}
+		if len(value) > maxLabelValue {
+			return fmt.Errorf("label %s value is longer than %d characters", key, maxLabelValue)
+		}
+	}
+	return nil
+}
+
+// SpecChanged reports whether two resources differ in anything but their cost and state.
+func SpecChanged(before models.Resource, after models.Resource) bool {
+	before.CostPerMinute, after.CostPerMinute = 0, 0
+	before.Available, after.Available = false, false
+	before.Computing, after.Computing = false, false
+	before, after = Normalize(before), Normalize(after)
+	return !reflect.DeepEqual(before, after)
+}
@@ -0,0 +1,82 @@
+package hardware
+
+import (
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestNormalize(t *testing.T) {
+	resource := Normalize(models.Resource{
+		CPUArch: " AMD64 ",
+		Region:  "EU-West",
+		Tags:    []string{"NVLink", "nvlink", " ", "spot"},
+		GPUs:    []models.GPU{{Model: " NVIDIA A100 ", Count: 4, VRAM: 80}},
+		Labels:  map[string]string{"Rack": " r12 "},
+	})
+
+	if resource.CPUArch != "x86_64" {
+		t.Errorf("Expected x86_64, got %s", resource.CPUArch)
+	}
+	if resource.Region != "eu-west" {
+		t.Errorf("Expected eu-west, got %s", resource.Region)
+	}
+	if len(resource.Tags) != 2 || resource.Tags[0] != "nvlink" || resource.Tags[1] != "spot" {
+		t.Errorf("Unexpected tags %v", resource.Tags)
+	}
+	if resource.GPU != "4x NVIDIA A100 80GB" {
+		t.Errorf("Unexpected gpu summary %q", resource.GPU)
+	}
+	if resource.Labels["rack"] != "r12" {
+		t.Errorf("Unexpected labels %v", resource.Labels)
+	}
+	if GPUCount(resource) != 4 || MaxVRAM(resource) != 80 {
+		t.Errorf("Unexpected gpu count %d or vram %d", GPUCount(resource), MaxVRAM(resource))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := models.Resource{CPUCores: 8, Memory: 32, GPUs: []models.GPU{{Model: "A100", Count: 1}}, CUDAVersion: "12.2"}
+	if err := Validate(Normalize(valid)); err != nil {
+		t.Errorf("Expected valid resource, got %v", err)
+	}
+
+	invalid := []models.Resource{
+		{CPUCores: 0, Memory: 32},
+		{CPUCores: 8, Memory: 32, GPUs: []models.GPU{{Model: "A100", Count: 0}}},
+		{CPUCores: 8, Memory: 32, GPUs: []models.GPU{{Count: 1}}},
+		{CPUCores: 8, Memory: 32, CPUArch: "sparc"},
+		{CPUCores: 8, Memory: 32, CUDAVersion: "12.2"},
+		{CPUCores: 8, Memory: 32, Region: "eu west"},
+		{CPUCores: 8, Memory: 32, Tags: []string{"no spaces"}},
+		{CPUCores: 8, Memory: 32, EgressLimit: -1},
+	}
+	for i, resource := range invalid {
+		if err := Validate(Normalize(resource)); err == nil {
+			t.Errorf("Expected resource %d to be invalid", i)
+		}
+	}
+}
+
+func TestSpecChanged(t *testing.T) {
+	before := models.Resource{CPUCores: 8, Memory: 32, CostPerMinute: 1, Tags: []string{"spot"}}
+
+	priceOnly := before
+	priceOnly.CostPerMinute = 2
+	priceOnly.Available = true
+	if SpecChanged(before, priceOnly) {
+		t.Errorf("Expected cost and availability changes not to count as spec changes")
+	}
+
+	sameTags := before
+	sameTags.Tags = []string{"SPOT"}
+	if SpecChanged(before, sameTags) {
+		t.Errorf("Expected equivalent tags not to count as spec changes")
+	}
+
+	moreMemory := before
+	moreMemory.Memory = 64
+	if !SpecChanged(before, moreMemory) {
+		t.Errorf("Expected memory change to count as spec change")
+	}
+}
@@ -0,0 +1,85 @@
+package hardware
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const (
+	maxReportAge    = time.Hour       // reports measured earlier are rejected as replays
+	maxReportSkew   = 5 * time.Minute // tolerated clock drift of an agent
+	memoryTolerance = 0.05            // share of the claimed memory the kernel may reserve
+)
+
+// ParseAgentKey decodes the base64 ed25519 public key an agent signs its reports with
+func ParseAgentKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid agent key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// VerifyReport checks the signature of an encoded hardware report of resource rid and decodes it
+func VerifyReport(agentKey string, encoded []byte, signature string, rid string, now time.Time) (models.HardwareReport, error) {
+	var report models.HardwareReport
+	key, err := ParseAgentKey(agentKey)
+	if err != nil {
+		return report, err
+	}
+	decodedSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(key, encoded, decodedSignature) {
+		return report, errors.New("invalid report signature")
+	}
+
+	if err = json.Unmarshal(encoded, &report); err != nil {
+		return report, errors.New("invalid report")
+	}
+	if report.RID != rid {
+		return report, errors.New("report is for another resource")
+	}
+	if report.MeasuredAt.Before(now.Add(-maxReportAge)) || report.MeasuredAt.After(now.Add(maxReportSkew)) {
+		return report, errors.New("report is stale")
+	}
+	return report, nil
+}
+
+// CompareReport lists the claimed specs of a resource that the measured facts contradict. A resource may offer less
+// than it has but not more.
+func CompareReport(claimed models.Resource, facts models.HardwareFacts) []models.HardwareDivergence {
+	divergences := []models.HardwareDivergence{}
+	diverge := func(field string, claimed string, measured string) {
+		divergences = append(divergences, models.HardwareDivergence{Field: field, Claimed: claimed, Measured: measured})
+	}
+
+	if claimed.CPUCores > facts.CPUCores {
+		diverge("cpuCores", strconv.Itoa(claimed.CPUCores), strconv.Itoa(facts.CPUCores))
+	}
+	if float64(facts.Memory) < math.Floor(float64(claimed.Memory)*(1-memoryTolerance)) {
+		diverge("memory", strconv.Itoa(claimed.Memory), strconv.Itoa(facts.Memory))
+	}
+	if claimed.Storage > facts.Storage {
+		diverge("storage", strconv.Itoa(claimed.Storage), strconv.Itoa(facts.Storage))
+	}
+
+	measured := Normalize(models.Resource{CPUArch: facts.CPUArch, CPUModel: facts.CPUModel, OS: facts.OS})
+	claimed = Normalize(claimed)
+	if claimed.CPUArch != "" && claimed.CPUArch != measured.CPUArch {
+		diverge("cpuArch", claimed.CPUArch, measured.CPUArch)
+	}
+	if claimed.CPUModel != "" && !strings.EqualFold(claimed.CPUModel, measured.CPUModel) {
+		diverge("cpuModel", claimed.CPUModel, measured.CPUModel)
+	}
+	if claimed.OS != "" && measured.OS != "" && !strings.EqualFold(claimed.OS, measured.OS) {
+		diverge("os", claimed.OS, measured.OS)
+	}
+	return divergences
+}
@@ -0,0 +1,61 @@
+package hardware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestVerifyReport(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	agentKey := base64.StdEncoding.EncodeToString(publicKey)
+	now := time.Now()
+
+	encoded, _ := json.Marshal(models.HardwareReport{RID: "7", Facts: models.HardwareFacts{CPUCores: 8}, MeasuredAt: now})
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, encoded))
+
+	report, err := VerifyReport(agentKey, encoded, signature, "7", now)
+	if err != nil {
+		t.Fatalf("Expected a valid report, got %v", err)
+	}
+	if report.Facts.CPUCores != 8 {
+		t.Errorf("Expected 8 cores, got %d", report.Facts.CPUCores)
+	}
+
+	if _, err := VerifyReport(agentKey, append(encoded, ' '), signature, "7", now); err == nil || err.Error() != "invalid report signature" {
+		t.Errorf("Expected a tampered report to be rejected, got %v", err)
+	}
+	if _, err := VerifyReport(agentKey, encoded, signature, "8", now); err == nil || err.Error() != "report is for another resource" {
+		t.Errorf("Expected a report of another resource to be rejected, got %v", err)
+	}
+	if _, err := VerifyReport(agentKey, encoded, signature, "7", now.Add(2*time.Hour)); err == nil || err.Error() != "report is stale" {
+		t.Errorf("Expected a replayed report to be rejected, got %v", err)
+	}
+}
+
+func TestCompareReport(t *testing.T) {
+	facts := models.HardwareFacts{CPUCores: 16, CPUModel: "AMD EPYC 7763", CPUArch: "amd64", Memory: 63, Storage: 500, OS: "ubuntu-22.04"}
+
+	honest := models.Resource{CPUCores: 16, Memory: 64, Storage: 400, CPUArch: "x86_64", CPUModel: "amd epyc 7763", OS: "ubuntu-22.04"}
+	if divergences := CompareReport(honest, facts); len(divergences) != 0 {
+		t.Errorf("Expected no divergences, got %v", divergences)
+	}
+
+	inflated := models.Resource{CPUCores: 32, Memory: 128, Storage: 500, CPUArch: "arm64"}
+	divergences := CompareReport(inflated, facts)
+	fields := map[string]bool{}
+	for _, divergence := range divergences {
+		fields[divergence.Field] = true
+	}
+	if len(divergences) != 3 || !fields["cpuCores"] || !fields["memory"] || !fields["cpuArch"] {
+		t.Errorf("Expected cpuCores, memory and cpuArch divergences, got %v", divergences)
+	}
+}
@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is an email sent by the server.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers the emails of the server, e.g. account verification links.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// LogMailer writes emails to the server log, for local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(message Message) error {
+	log.Printf("Mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileMailer writes each email to a file of its directory, for local development and tests.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(message Message) error {
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return errors.New("failed to create mail directory")
+	}
+	file, err := os.CreateTemp(m.Dir, time.Now().UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return errors.New("failed to write mail")
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n", message.To, message.Subject, time.Now().Format(time.RFC1123Z), message.Body)
+	if err != nil {
+		return errors.New("failed to write mail")
+	}
+	return nil
+}
+
+// FromEnv returns the mailer chosen with MAILER, either log (the default) or file writing to MAILER_DIR.
+func FromEnv() Mailer {
+	if strings.ToLower(os.Getenv("MAILER")) == "file" {
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return FileMailer{Dir: dir}
+	}
+	return LogMailer{}
+}
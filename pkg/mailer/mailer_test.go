@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer := FileMailer{Dir: dir}
+	for i := 0; i < 2; i++ {
+		err := mailer.Send(Message{To: "alice@example.com", Subject: "Verify your email", Body: "https://cycloud.test/verify-email/abc"})
+		if err != nil {
+			t.Fatalf("Failed to send mail: %v", err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected a file per mail, got %d, %v", len(files), err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if !strings.Contains(string(content), "To: alice@example.com") || !strings.Contains(string(content), "verify-email/abc") {
+		t.Errorf("Unexpected mail content %q", content)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("MAILER", "file")
+	t.Setenv("MAILER_DIR", "/tmp/cycloud-mail")
+	if mailer, ok := FromEnv().(FileMailer); !ok || mailer.Dir != "/tmp/cycloud-mail" {
+		t.Errorf("Expected a file mailer, got %#v", FromEnv())
+	}
+	t.Setenv("MAILER", "")
+	if _, ok := FromEnv().(LogMailer); !ok {
+		t.Errorf("Expected the log mailer by default")
+	}
+}
@@ -1,29 +1,114 @@
 package models
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// GPU represents a group of identical GPUs installed in a Resource.
+type GPU struct {
+	Model string `json:"model"` // e.g., "NVIDIA A100"
+	Count int    `json:"count"`
+	VRAM  int    `json:"vram"` // per GPU, in GB
+}
+
 // Resource represents a computing resource offered by a Supplier.
 type Resource struct {
-	CPUCores      int     `json:"cpuCores"`
-	Memory        int     `json:"memory"`    // in GB
-	Storage       int     `json:"storage"`   // in GB
-	GPU           string  `json:"gpu"`       // e.g., "NVIDIA GeForce RTX 3080"
-	Bandwidth     int     `json:"bandwidth"` // in Mbps
-	CostPerMinute float64 `json:"costPerHour"`
-	Available     bool    `json:"available"`
-	Computing     bool    `json:"computing"`
+	CPUCores      int               `json:"cpuCores"`
+	Memory        int               `json:"memory"`    // in GB
+	Storage       int               `json:"storage"`   // in GB
+	GPU           string            `json:"gpu"`       // e.g., "NVIDIA GeForce RTX 3080", derived from GPUs when empty
+	Bandwidth     int               `json:"bandwidth"` // in Mbps
+	CostPerMinute float64           `json:"costPerHour"`
+	Available     bool              `json:"available"`
+	Computing     bool              `json:"computing"`
+	Region        string            `json:"region"` // e.g., "eu-west"
+	Tags          []string          `json:"tags"`
+	GPUs          []GPU             `json:"gpus"`
+	CPUArch       string            `json:"cpuArch"`  // e.g., "x86_64", "arm64"
+	CPUModel      string            `json:"cpuModel"` // e.g., "AMD EPYC 7763"
+	OS            string            `json:"os"`       // OS image, e.g., "ubuntu-22.04"
+	CUDAVersion   string            `json:"cudaVersion"`
+	Zone          string            `json:"zone"`        // e.g., "eu-west-1a"
+	EgressLimit   int               `json:"egressLimit"` // in GB per lease, 0 for unlimited
+	Labels        map[string]string `json:"labels"`
+}
+
+// Resource statuses
+const (
+	ResourceActive      = "active"
+	ResourceDraining    = "draining"    // no new leases, moves to maintenance once the running lease ends
+	ResourceMaintenance = "maintenance" // off the market until the supplier ends the maintenance
+	ResourceArchived    = "archived"    // retired, kept for historical leases and price history
+)
+
+// MaintenanceWindow represents a scheduled maintenance of a Resource, it starts draining at Start.
+type MaintenanceWindow struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
 }
 
+// Hardware verification statuses of a Resource
+const (
+	VerificationUnverified = "unverified" // no hardware report matches the current spec
+	VerificationVerified   = "verified"   // the latest signed hardware report matches the claimed spec
+	VerificationDiverged   = "diverged"   // the latest signed hardware report contradicts the claimed spec
+)
+
 // ResourceWithID represents a computing resource with an ID.
 type ResourceWithID struct {
 	RID string `json:"rid"`
 	Resource
-	CreatedAt time.Time `json:"createdAt"`
+	Status       string             `json:"status"`
+	Verification string             `json:"verification"`
+	Maintenance  *MaintenanceWindow `json:"maintenance,omitempty"` // scheduled or ongoing maintenance
+	Reputation   float64            `json:"reputation"`            // score of the supplier
+	ArchivedAt   *time.Time         `json:"archivedAt,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt"`
+}
+
+// HardwareFacts represents the hardware of a machine as measured by its supplier agent.
+type HardwareFacts struct {
+	CPUCores int    `json:"cpuCores"`
+	CPUModel string `json:"cpuModel"`
+	CPUArch  string `json:"cpuArch"`
+	Memory   int    `json:"memory"`  // in GB
+	Storage  int    `json:"storage"` // in GB
+	OS       string `json:"os"`
+}
+
+// Benchmark represents the results of the short benchmark a supplier agent runs.
+type Benchmark struct {
+	CPUScore        float64 `json:"cpuScore"`        // SHA-256 throughput over all cores, in MB/s
+	MemoryBandwidth float64 `json:"memoryBandwidth"` // in MB/s
+	DiskWrite       float64 `json:"diskWrite"`       // sequential synced writes, in MB/s
+}
+
+// HardwareReport represents a hardware self-report signed by a supplier agent.
+type HardwareReport struct {
+	RID        string        `json:"rid"`
+	Facts      HardwareFacts `json:"facts"`
+	Benchmark  Benchmark     `json:"benchmark"`
+	MeasuredAt time.Time     `json:"measuredAt"`
+}
+
+// HardwareDivergence represents a claimed spec contradicted by a HardwareReport.
+type HardwareDivergence struct {
+	Field    string `json:"field"`
+	Claimed  string `json:"claimed"`
+	Measured string `json:"measured"`
+}
+
+// HardwareVerification represents a stored HardwareReport and the outcome of checking it against the claimed spec.
+type HardwareVerification struct {
+	HID         string               `json:"hid"`
+	Report      HardwareReport       `json:"report"`
+	Divergences []HardwareDivergence `json:"divergences"`
+	CreatedAt   time.Time            `json:"createdAt"`
 }
 
 // ResourceWithUID represents a computing resource with a UID.
@@ -32,11 +117,117 @@ type ResourceWithUID struct {
 	ResourceWithID
 }
 
+// ResourceRevision represents the spec of a Resource as it was between two updates.
+type ResourceRevision struct {
+	RID       string    `json:"rid"`
+	Revision  int       `json:"revision"`
+	Resource  Resource  `json:"resource"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ResourceTemplate represents a saved Resource spec a Supplier provisions identical resources from.
+type ResourceTemplate struct {
+	TID       string    `json:"tid"`
+	Name      string    `json:"name"`
+	Resource  Resource  `json:"resource"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Bulk resource actions
+const (
+	BulkCreate          = "create"
+	BulkSetAvailability = "set-availability"
+	BulkRetire          = "retire"
+)
+
+// BulkResourceItem represents one action of a bulk provisioning request.
+type BulkResourceItem struct {
+	Action    string          `json:"action"`    // one of create, set-availability, retire
+	RID       string          `json:"rid"`       // resource to update or retire
+	Template  string          `json:"template"`  // name of the template to create from
+	Count     int             `json:"count"`     // number of resources to create, defaults to 1
+	Available *bool           `json:"available"` // availability to set
+	Resource  json.RawMessage `json:"resource"`  // spec to create, overrides the template's fields
+	Spec      Resource        `json:"-"`         // resolved spec of a create action
+}
+
+// BulkResourceResult represents the outcome of one BulkResourceItem.
+type BulkResourceResult struct {
+	Index  int      `json:"index"`
+	Action string   `json:"action"`
+	Status string   `json:"status"` // "ok", "error", "rolled back" or "skipped"
+	RIDs   []string `json:"rids,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// TimeOfDayPrice overrides a resource's cost per hour between StartHour and EndHour (UTC, end exclusive).
+type TimeOfDayPrice struct {
+	StartHour   int     `json:"startHour"`
+	EndHour     int     `json:"endHour"`
+	CostPerHour float64 `json:"costPerHour"`
+}
+
+// DurationDiscount applies a percentage discount to bids of at least MinDuration hours.
+type DurationDiscount struct {
+	MinDuration int     `json:"minDuration"` // in hours
+	Percent     float64 `json:"percent"`
+}
+
+// PricingRules represents supplier-defined pricing on top of a resource's cost per hour.
+type PricingRules struct {
+	TimeOfDay         []TimeOfDayPrice   `json:"timeOfDay"`
+	MinimumCharge     float64            `json:"minimumCharge"` // minimum total charge of a lease
+	DurationDiscounts []DurationDiscount `json:"durationDiscounts"`
+}
+
+// ClearingPrice represents the price a Resource was leased at.
+type ClearingPrice struct {
+	RID string `json:"rid"`
+	Resource
+	Amount    float64   `json:"amount"`   // Clearing amount per hour
+	Duration  int       `json:"duration"` // in hours
+	ClearedAt time.Time `json:"clearedAt"`
+}
+
 // Bid represents a bid made by a User for a Resource.
 type Bid struct {
-	RID      string  `json:"rid"`
-	Amount   float64 `json:"amount"`   // Bid amount per hour
-	Duration int     `json:"duration"` // in hours
+	RID      string    `json:"rid"`
+	Amount   float64   `json:"amount"`             // Bid amount per hour
+	Duration int       `json:"duration"`           // in hours
+	Workload *Workload `json:"workload,omitempty"` // job to run on the resource, none for interactive use
+}
+
+// Workload represents the job a User intends to run on a leased Resource.
+type Workload struct {
+	Image      string            `json:"image"` // container image reference, e.g. "docker.io/library/python:3.12"
+	Command    []string          `json:"command"`
+	Env        map[string]string `json:"env"`
+	Ports      []int             `json:"ports"`      // ports the workload must be reachable on
+	VolumeSize int               `json:"volumeSize"` // data volume, in GB
+}
+
+// Workload types a Supplier may allow on a Resource
+const (
+	WorkloadInteractive = "interactive" // no workload, the renter connects to the machine
+	WorkloadBatch       = "batch"       // a container exposing no ports
+	WorkloadService     = "service"     // a container reachable on ports
+)
+
+// AcceptancePolicy represents the loan requests a Supplier accepts on a Resource.
+type AcceptancePolicy struct {
+	AllowedRenters       []string `json:"allowedRenters"`       // uids, anyone not blocked when empty
+	BlockedRenters       []string `json:"blockedRenters"`       // uids
+	MinReputation        float64  `json:"minReputation"`        // renters with a lower score are rejected
+	AllowedWorkloadTypes []string `json:"allowedWorkloadTypes"` // any type when empty
+	MaxDuration          int      `json:"maxDuration"`          // in hours, unlimited when 0
+}
+
+// WorkloadPolicy represents the workloads a Supplier accepts on a Resource.
+type WorkloadPolicy struct {
+	RequireWorkload bool     `json:"requireWorkload"` // reject loan requests without a workload
+	AllowedImages   []string `json:"allowedImages"`   // image repositories, or prefixes ending with "/", any image when empty
+	AllowedPorts    []int    `json:"allowedPorts"`    // any port when empty
+	MaxVolumeSize   int      `json:"maxVolumeSize"`   // in GB, up to the resource storage when 0
 }
 
 // BidWithID represents a bid with an ID.
@@ -53,6 +244,178 @@ type BidWithUID struct {
 	BidWithID
 }
 
+// Pool represents a group of a Supplier's resources rented together as one lease.
+type Pool struct {
+	PID            string    `json:"pid"`
+	Name           string    `json:"name"`
+	RIDs           []string  `json:"rids"`
+	AvailableNodes int       `json:"availableNodes"`
+	MinCostPerHour float64   `json:"minCostPerHour"` // lowest cost per hour of the available nodes
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// PoolBid represents a request by a User to rent several nodes of a Pool.
+type PoolBid struct {
+	PID      string    `json:"pid"`
+	Nodes    int       `json:"nodes"`
+	Amount   float64   `json:"amount"`             // Bid amount per node per hour
+	Duration int       `json:"duration"`           // in hours
+	Workload *Workload `json:"workload,omitempty"` // job to run on every node
+}
+
+// PoolLease represents the nodes allocated to a PoolBid, one accepted bid per node.
+type PoolLease struct {
+	PLID string `json:"plid"`
+	PoolBid
+	NodeBids  []BidWithID `json:"nodeBids"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// Rating represents the score and comment one party of a lease gives the other once it is over.
+type Rating struct {
+	BID       string    `json:"bid"`
+	Score     int       `json:"score"` // 1 to 5
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Reputation represents the trust signals of a user, derived from ratings and lease outcomes.
+type Reputation struct {
+	Score                 float64 `json:"score"`  // 0 to 100, checked by acceptance policies
+	Rating                float64 `json:"rating"` // average rating, 0 when unrated
+	Ratings               int     `json:"ratings"`
+	Connections           int     `json:"connections"` // leases in which the user connected to its peer
+	ConnectionSuccessRate float64 `json:"connectionSuccessRate"`
+	EarlyTerminations     int     `json:"earlyTerminations"`
+	NoShows               int     `json:"noShows"`
+	Disputes              int     `json:"disputes"` // disputes upheld against the user
+}
+
+const (
+	DisputeOpen     = "open"
+	DisputeResolved = "resolved"
+)
+
+// Dispute represents a complaint of a party of a lease, resolved by an admin while the lease payout is in escrow.
+type Dispute struct {
+	DID        string           `json:"did"`
+	BID        string           `json:"bid"`
+	Opener     string           `json:"opener"`
+	Against    string           `json:"against"`
+	Reason     string           `json:"reason"`
+	Status     string           `json:"status"`
+	Escrow     float64          `json:"escrow"` // 0 while the lease is running
+	Refund     float64          `json:"refund"`
+	Upheld     bool             `json:"upheld"` // counted against the reputation of the other party
+	Resolution string           `json:"resolution"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	ResolvedAt *time.Time       `json:"resolvedAt,omitempty"`
+	Evidence   *DisputeEvidence `json:"evidence,omitempty"`
+}
+
+// LeaseLogEntry represents something that happened to a lease, on the signaling server or when it was settled.
+type LeaseLogEntry struct {
+	UID       string    `json:"uid"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DisputeEvidence represents what the server recorded of a disputed lease.
+type DisputeEvidence struct {
+	Events     []LeaseLogEntry `json:"events"`
+	Signaling  []LeaseLogEntry `json:"signaling"`
+	Heartbeats []time.Time     `json:"heartbeats"` // supplier agent heartbeats received during the lease
+}
+
+// LedgerEntry represents a movement of the credits of a user.
+type LedgerEntry struct {
+	LID       string    `json:"lid"`
+	BID       *string   `json:"bid,omitempty"`
+	Amount    float64   `json:"amount"` // negative when credits left the wallet
+	Reason    string    `json:"reason"`
+	Note      string    `json:"note,omitempty"` // given by the admin who adjusted the wallet
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKey represents a long-lived key a machine client or supplier agent authenticates with, only its hash is kept.
+type APIKey struct {
+	KID        string     `json:"kid"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"` // permissions the key is limited to
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIp,omitempty"`
+}
+
+// Session represents a signed in device of a user, kept alive by its refresh token.
+type Session struct {
+	SID        string    `json:"sid"`
+	Device     string    `json:"device"` // the User-Agent the session was signed in from
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"` // when the session ends unless it is refreshed
+	Current    bool      `json:"current"`   // whether the request listing the sessions was made with it
+}
+
+// UserSummary represents a user as listed to admins, its username is only known as a hash.
+type UserSummary struct {
+	UID              string     `json:"uid"`
+	Email            string     `json:"email,omitempty"`
+	EmailVerified    bool       `json:"emailVerified"`
+	Roles            []string   `json:"roles"`
+	Credits          float64    `json:"credits"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	SuspendedAt      *time.Time `json:"suspendedAt,omitempty"`
+	SuspensionReason string     `json:"suspensionReason,omitempty"`
+}
+
+// UserFilter narrows the users listed to admins, zero values match every user.
+type UserFilter struct {
+	Username  string // hash of the exact username
+	Email     string // part of the email
+	Role      string
+	Suspended *bool
+	Limit     int
+	Offset    int
+}
+
+// AuditEntry represents an action taken by an admin.
+type AuditEntry struct {
+	AID       string                 `json:"aid"`
+	Admin     string                 `json:"admin"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target"` // the user, lease, resource or dispute acted on
+	Details   map[string]interface{} `json:"details"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+// LoginEvent represents a failed or throttled login, recorded for admins to spot credential stuffing.
+type LoginEvent struct {
+	EID       string    `json:"eid"`
+	UID       string    `json:"uid,omitempty"` // empty when the username does not exist
+	Username  string    `json:"username"`      // the hash the username is stored under
+	IP        string    `json:"ip"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SystemStats represents the state of the exchange as seen by admins.
+type SystemStats struct {
+	Users              int     `json:"users"`
+	SuspendedUsers     int     `json:"suspendedUsers"`
+	Resources          int     `json:"resources"` // not archived
+	ListedResources    int     `json:"listedResources"`
+	ComputingResources int     `json:"computingResources"`
+	PendingBids        int     `json:"pendingBids"`
+	RunningLeases      int     `json:"runningLeases"`
+	ActiveAuctions     int     `json:"activeAuctions"`
+	OpenDisputes       int     `json:"openDisputes"`
+	EscrowHeld         float64 `json:"escrowHeld"` // credits of finished leases not yet paid out
+	WalletCredits      float64 `json:"walletCredits"`
+	LiveSessions       int     `json:"liveSessions"`
+}
+
 // Credintials represents a user credintials.
 type Credintials struct {
 	Username string `json:"username"`
@@ -77,8 +440,8 @@ type UserWallet struct {
 }
 
 type BidWithLock struct {
-	MaxBid BidWithID
-	Lock   sync.Mutex
+	MaxBid   BidWithID
+	Lock     sync.Mutex
 	LoanerWS *websocket.Conn
 	RenterWS *websocket.Conn
 }
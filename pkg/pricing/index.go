@@ -0,0 +1,125 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// Candle summarises the clearing prices of one group during one interval.
+type Candle struct {
+	Start  time.Time `json:"start"`
+	Open   float64   `json:"open"`
+	High   float64   `json:"high"`
+	Low    float64   `json:"low"`
+	Close  float64   `json:"close"`
+	Volume int       `json:"volume"` // number of leases cleared
+	Hours  int       `json:"hours"`  // total leased hours
+}
+
+// PercentileSummary holds the clearing price percentiles of one group.
+type PercentileSummary struct {
+	Group       string             `json:"group"`
+	Count       int                `json:"count"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// GroupKey returns the group a resource falls in: "gpu" groups by GPU model, "cores" by CPU core count
+// and "" puts every resource in the same group.
+func GroupKey(resource models.Resource, groupBy string) (string, error) {
+	switch groupBy {
+	case "gpu":
+		gpu := strings.ToLower(strings.TrimSpace(resource.GPU))
+		if gpu == "" {
+			return "none", nil
+		}
+		return gpu, nil
+	case "cores":
+		return strconv.Itoa(resource.CPUCores), nil
+	case "":
+		return "all", nil
+	}
+	return "", errors.New("invalid group, expected gpu or cores")
+}
+
+// Series buckets clearing prices, which must be ordered by clearing time, into per-group candles of the given interval.
+func Series(prices []models.ClearingPrice, groupBy string, interval time.Duration) (map[string][]Candle, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+
+	series := map[string][]Candle{}
+	for _, price := range prices {
+		group, err := GroupKey(price.Resource, groupBy)
+		if err != nil {
+			return nil, err
+		}
+
+		start := price.ClearedAt.UTC().Truncate(interval)
+		candles := series[group]
+		if len(candles) == 0 || !candles[len(candles)-1].Start.Equal(start) {
+			candles = append(candles, Candle{Start: start, Open: price.Amount, High: price.Amount, Low: price.Amount})
+		}
+
+		candle := &candles[len(candles)-1]
+		if price.Amount > candle.High {
+			candle.High = price.Amount
+		}
+		if price.Amount < candle.Low {
+			candle.Low = price.Amount
+		}
+		candle.Close = price.Amount
+		candle.Volume++
+		candle.Hours += price.Duration
+		series[group] = candles
+	}
+	return series, nil
+}
+
+// Percentiles computes the requested percentiles (0-100) of the clearing prices of every group.
+func Percentiles(prices []models.ClearingPrice, groupBy string, percentiles []float64) ([]PercentileSummary, error) {
+	for _, p := range percentiles {
+		if p < 0 || p > 100 {
+			return nil, errors.New("percentiles must be between 0 and 100")
+		}
+	}
+
+	amounts := map[string][]float64{}
+	for _, price := range prices {
+		group, err := GroupKey(price.Resource, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		amounts[group] = append(amounts[group], price.Amount)
+	}
+
+	summaries := []PercentileSummary{}
+	for group, values := range amounts {
+		sort.Float64s(values)
+		summary := PercentileSummary{Group: group, Count: len(values), Percentiles: map[string]float64{}}
+		for _, p := range percentiles {
+			summary.Percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = percentile(values, p)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Group < summaries[j].Group })
+	return summaries, nil
+}
+
+// ParsePercentiles parses a comma separated list of percentiles such as "50,90,99".
+func ParsePercentiles(list string) ([]float64, error) {
+	percentiles := []float64{}
+	for _, field := range strings.Split(list, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid percentile %q", field)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
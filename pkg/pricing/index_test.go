@@ -0,0 +1,63 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestSeries(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a100 := models.Resource{CPUCores: 8, GPU: "A100"}
+	prices := []models.ClearingPrice{
+		{Resource: a100, Amount: 2, Duration: 1, ClearedAt: start.Add(10 * time.Minute)},
+		{Resource: a100, Amount: 5, Duration: 2, ClearedAt: start.Add(20 * time.Minute)},
+		{Resource: a100, Amount: 1, Duration: 1, ClearedAt: start.Add(30 * time.Minute)},
+		{Resource: a100, Amount: 3, Duration: 4, ClearedAt: start.Add(90 * time.Minute)},
+		{Resource: models.Resource{CPUCores: 4}, Amount: 7, Duration: 1, ClearedAt: start},
+	}
+
+	series, err := Series(prices, "gpu", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to build series: %v", err)
+	}
+	candles := series["a100"]
+	if len(candles) != 2 {
+		t.Fatalf("Expected 2 candles, got %d", len(candles))
+	}
+	first := candles[0]
+	if first.Open != 2 || first.High != 5 || first.Low != 1 || first.Close != 1 || first.Volume != 3 || first.Hours != 4 {
+		t.Errorf("Unexpected first candle %+v", first)
+	}
+	if !candles[1].Start.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected second candle to start at %v, got %v", start.Add(time.Hour), candles[1].Start)
+	}
+	if len(series["none"]) != 1 {
+		t.Errorf("Expected resources without GPU to be grouped under none")
+	}
+
+	if _, err = Series(prices, "region", time.Hour); err == nil {
+		t.Errorf("Expected error for invalid group")
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	prices := []models.ClearingPrice{
+		{Resource: models.Resource{CPUCores: 8}, Amount: 1},
+		{Resource: models.Resource{CPUCores: 8}, Amount: 2},
+		{Resource: models.Resource{CPUCores: 8}, Amount: 3},
+		{Resource: models.Resource{CPUCores: 16}, Amount: 10},
+	}
+
+	summaries, err := Percentiles(prices, "cores", []float64{50, 100})
+	if err != nil {
+		t.Fatalf("Failed to compute percentiles: %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].Group != "16" || summaries[1].Group != "8" {
+		t.Fatalf("Unexpected groups %+v", summaries)
+	}
+	if summaries[1].Percentiles["p50"] != 2 || summaries[1].Percentiles["p100"] != 3 {
+		t.Errorf("Unexpected percentiles %+v", summaries[1].Percentiles)
+	}
+}
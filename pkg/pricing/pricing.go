@@ -0,0 +1,129 @@
+package pricing
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// ValidateRules checks that the supplier-defined pricing rules are consistent.
+func ValidateRules(rules models.PricingRules) error {
+	for _, rule := range rules.TimeOfDay {
+		if rule.StartHour < 0 || rule.StartHour > 23 || rule.EndHour < 0 || rule.EndHour > 24 {
+			return errors.New("time of day hours must be between 0 and 24")
+		}
+		if rule.StartHour == rule.EndHour {
+			return errors.New("time of day start and end hours must differ")
+		}
+		if rule.CostPerHour < 0 {
+			return errors.New("time of day cost per hour must not be negative")
+		}
+	}
+	if rules.MinimumCharge < 0 {
+		return errors.New("minimum charge must not be negative")
+	}
+	for _, discount := range rules.DurationDiscounts {
+		if discount.MinDuration <= 0 {
+			return errors.New("discount minimum duration must be positive")
+		}
+		if discount.Percent < 0 || discount.Percent > 100 {
+			return errors.New("discount percent must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+// inHourRange reports whether hour falls in [start, end), wrapping around midnight when start > end.
+func inHourRange(hour, start, end int) bool {
+	if start < end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// CostPerHour returns the cost per hour in force at the given time, the first matching time of day rule wins.
+func CostPerHour(base float64, rules models.PricingRules, at time.Time) float64 {
+	hour := at.UTC().Hour()
+	for _, rule := range rules.TimeOfDay {
+		if inHourRange(hour, rule.StartHour, rule.EndHour) {
+			return rule.CostPerHour
+		}
+	}
+	return base
+}
+
+// MinimumAmount returns the lowest amount per hour a bid of the given duration placed at the given time may offer.
+func MinimumAmount(base float64, rules models.PricingRules, at time.Time, duration int) float64 {
+	amount := CostPerHour(base, rules, at)
+
+	// Apply the largest discount the duration qualifies for
+	var discount float64
+	for _, d := range rules.DurationDiscounts {
+		if duration >= d.MinDuration && d.Percent > discount {
+			discount = d.Percent
+		}
+	}
+	amount *= 1 - discount/100
+
+	// Raise the hourly amount so the lease covers the minimum charge
+	if duration > 0 && amount*float64(duration) < rules.MinimumCharge {
+		amount = rules.MinimumCharge / float64(duration)
+	}
+	return amount
+}
+
+// isComparable reports whether a leased resource is similar enough to the spec to inform its price.
+func isComparable(spec models.Resource, other models.Resource) bool {
+	if !strings.EqualFold(strings.TrimSpace(spec.GPU), strings.TrimSpace(other.GPU)) {
+		return false
+	}
+	within := func(a, b int) bool {
+		return a == b || (a > 0 && b > 0 && a <= 2*b && b <= 2*a)
+	}
+	return within(spec.CPUCores, other.CPUCores) && within(spec.Memory, other.Memory)
+}
+
+// Suggestion is a suggested cost per hour derived from recent clearing prices.
+type Suggestion struct {
+	CostPerHour float64 `json:"costPerHour"`
+	Low         float64 `json:"low"`
+	High        float64 `json:"high"`
+	Comparables int     `json:"comparables"`
+}
+
+// percentile returns the p-th percentile (0-100) of sorted values using linear interpolation.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// Suggest derives a cost per hour for the spec from the clearing prices of comparable resources.
+func Suggest(spec models.Resource, prices []models.ClearingPrice) (Suggestion, error) {
+	amounts := []float64{}
+	for _, price := range prices {
+		if isComparable(spec, price.Resource) {
+			amounts = append(amounts, price.Amount)
+		}
+	}
+	if len(amounts) == 0 {
+		return Suggestion{}, errors.New("no comparable resources found")
+	}
+	sort.Float64s(amounts)
+
+	return Suggestion{
+		CostPerHour: percentile(amounts, 50),
+		Low:         percentile(amounts, 25),
+		High:        percentile(amounts, 75),
+		Comparables: len(amounts),
+	}, nil
+}
@@ -0,0 +1,73 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestMinimumAmount(t *testing.T) {
+	rules := models.PricingRules{
+		TimeOfDay:         []models.TimeOfDayPrice{{StartHour: 22, EndHour: 6, CostPerHour: 1}},
+		MinimumCharge:     10,
+		DurationDiscounts: []models.DurationDiscount{{MinDuration: 10, Percent: 10}, {MinDuration: 20, Percent: 20}},
+	}
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+
+	if amount := MinimumAmount(2, rules, day, 5); amount != 2 {
+		t.Errorf("Expected base amount 2, got %f", amount)
+	}
+	if amount := MinimumAmount(2, rules, night, 30); amount != 0.8 {
+		t.Errorf("Expected discounted night amount 0.8, got %f", amount)
+	}
+	if amount := MinimumAmount(2, rules, night, 2); amount != 5 {
+		t.Errorf("Expected minimum charge to raise amount to 5, got %f", amount)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	invalid := []models.PricingRules{
+		{TimeOfDay: []models.TimeOfDayPrice{{StartHour: 5, EndHour: 5}}},
+		{TimeOfDay: []models.TimeOfDayPrice{{StartHour: 0, EndHour: 25}}},
+		{MinimumCharge: -1},
+		{DurationDiscounts: []models.DurationDiscount{{MinDuration: 0, Percent: 10}}},
+		{DurationDiscounts: []models.DurationDiscount{{MinDuration: 1, Percent: 101}}},
+	}
+	for i, rules := range invalid {
+		if err := ValidateRules(rules); err == nil {
+			t.Errorf("Expected rules %d to be invalid", i)
+		}
+	}
+	if err := ValidateRules(models.PricingRules{}); err != nil {
+		t.Errorf("Expected empty rules to be valid, got %v", err)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	spec := models.Resource{CPUCores: 8, Memory: 32, GPU: "RTX 3080"}
+	prices := []models.ClearingPrice{
+		{Resource: models.Resource{CPUCores: 8, Memory: 32, GPU: "rtx 3080"}, Amount: 1},
+		{Resource: models.Resource{CPUCores: 16, Memory: 32, GPU: "RTX 3080"}, Amount: 3},
+		{Resource: models.Resource{CPUCores: 4, Memory: 16, GPU: "RTX 3080"}, Amount: 2},
+		{Resource: models.Resource{CPUCores: 64, Memory: 32, GPU: "RTX 3080"}, Amount: 100},
+		{Resource: models.Resource{CPUCores: 8, Memory: 32, GPU: "A100"}, Amount: 50},
+	}
+
+	suggestion, err := Suggest(spec, prices)
+	if err != nil {
+		t.Fatalf("Failed to suggest price: %v", err)
+	}
+	if suggestion.Comparables != 3 {
+		t.Errorf("Expected 3 comparables, got %d", suggestion.Comparables)
+	}
+	if suggestion.CostPerHour != 2 {
+		t.Errorf("Expected suggested cost 2, got %f", suggestion.CostPerHour)
+	}
+
+	_, err = Suggest(models.Resource{GPU: "H100"}, prices)
+	if err == nil {
+		t.Errorf("Expected error when no comparable resources exist")
+	}
+}
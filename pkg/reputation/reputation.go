@@ -0,0 +1,60 @@
+package reputation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// Lease events counted against a party's reputation, besides the settlement outcomes
+const (
+	EarlyTermination = "early_termination" // the renter left before the lease ran out
+	Dispute          = "dispute"           // a dispute against the party was upheld
+)
+
+const (
+	DefaultScore     = 100 // score of users without any lease history
+	MinRating        = 1
+	MaxRating        = 5
+	MaxCommentLength = 1000
+
+	earlyTerminationPenalty = 2
+	disputePenalty          = 10
+)
+
+// ValidateRating checks the score and comment one party of a lease gives the other.
+func ValidateRating(score int, comment string) error {
+	if score < MinRating || score > MaxRating {
+		return errors.New("rating must be between 1 and 5")
+	}
+	if len(strings.TrimSpace(comment)) > MaxCommentLength {
+		return errors.New("comment is too long")
+	}
+	return nil
+}
+
+// ConnectionSuccessRate returns the share of leases in which the user showed up, users without leases have a full rate.
+func ConnectionSuccessRate(connections, noShows int) float64 {
+	if connections+noShows == 0 {
+		return 1
+	}
+	return float64(connections) / float64(connections+noShows)
+}
+
+// Score derives the 0 to 100 score of a reputation from its signals and the penalties settled against the user.
+// Ratings scale the score down to 60% for an average of one star.
+func Score(rep models.Reputation, penalty float64) float64 {
+	score := DefaultScore*rep.ConnectionSuccessRate - penalty
+	score -= float64(rep.EarlyTerminations*earlyTerminationPenalty + rep.Disputes*disputePenalty)
+	if rep.Ratings > 0 {
+		score *= (rep.Rating + MaxRating) / (2 * MaxRating)
+	}
+	if score < 0 {
+		return 0
+	}
+	if score > DefaultScore {
+		return DefaultScore
+	}
+	return score
+}
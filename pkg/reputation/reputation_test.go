@@ -0,0 +1,53 @@
+package reputation
+
+import (
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestValidateRating(t *testing.T) {
+	if err := ValidateRating(4, "Fast machine"); err != nil {
+		t.Errorf("Expected valid rating, got %v", err)
+	}
+	if err := ValidateRating(0, ""); err == nil {
+		t.Errorf("Expected error for rating below 1")
+	}
+	if err := ValidateRating(6, ""); err == nil {
+		t.Errorf("Expected error for rating above 5")
+	}
+	long := make([]byte, MaxCommentLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if err := ValidateRating(3, string(long)); err == nil {
+		t.Errorf("Expected error for long comment")
+	}
+}
+
+func TestConnectionSuccessRate(t *testing.T) {
+	if rate := ConnectionSuccessRate(0, 0); rate != 1 {
+		t.Errorf("Expected full rate without leases, got %v", rate)
+	}
+	if rate := ConnectionSuccessRate(3, 1); rate != 0.75 {
+		t.Errorf("Expected 0.75, got %v", rate)
+	}
+}
+
+func TestScore(t *testing.T) {
+	if score := Score(models.Reputation{ConnectionSuccessRate: 1}, 0); score != DefaultScore {
+		t.Errorf("Expected default score for a new user, got %v", score)
+	}
+	if score := Score(models.Reputation{ConnectionSuccessRate: 1, Rating: 5, Ratings: 2}, 0); score != DefaultScore {
+		t.Errorf("Expected five stars to keep the score, got %v", score)
+	}
+	if score := Score(models.Reputation{ConnectionSuccessRate: 1, Rating: 1, Ratings: 1}, 0); score != 60 {
+		t.Errorf("Expected one star to scale the score to 60, got %v", score)
+	}
+	if score := Score(models.Reputation{ConnectionSuccessRate: 0.5, EarlyTerminations: 1, Disputes: 1}, 5); score != 33 {
+		t.Errorf("Expected 33, got %v", score)
+	}
+	if score := Score(models.Reputation{ConnectionSuccessRate: 0, Disputes: 3}, 0); score != 0 {
+		t.Errorf("Expected score to stop at 0, got %v", score)
+	}
+}
@@ -0,0 +1,25 @@
+package settlement
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+)
+
+// defaultDisputeWindow is how long the payout of a lease stays in escrow once it ends
+const defaultDisputeWindow = 24 * time.Hour
+
+// DisputeWindow reads how long either party of a lease may dispute it after it ends from the environment.
+func DisputeWindow() time.Duration {
+	return env.Duration("DISPUTE_WINDOW_HOURS", defaultDisputeWindow, time.Hour, env.NonNegative)
+}
+
+// SplitEscrow splits the escrow of a disputed lease into the refund paid back to the renter and the payout of the
+// supplier.
+func SplitEscrow(escrow float64, refund float64) (float64, float64, error) {
+	if refund < 0 || refund > escrow {
+		return 0, 0, errors.New("refund must be between 0 and the escrowed amount")
+	}
+	return refund, escrow - refund, nil
+}
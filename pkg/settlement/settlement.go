@@ -0,0 +1,68 @@
+package settlement
+
+import (
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/env"
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+// Outcome describes how a signaling session for an accepted bid ended.
+type Outcome string
+
+const (
+	Connected       Outcome = "connected"
+	SupplierNoShow  Outcome = "supplier_no_show"
+	RenterNoShow    Outcome = "renter_no_show"
+	SupplierOffline Outcome = "supplier_offline" // the supplier's agent stopped sending heartbeats during the lease
+)
+
+// NoShowPolicy holds the rules applied when one side of a lease never connects.
+type NoShowPolicy struct {
+	FeePercent      float64       // share of the renter's escrow paid to the supplier when the renter never shows
+	SupplierPenalty float64       // reputation points taken from a supplier that never opens the offer socket
+	GracePeriod     time.Duration // how long a peer waits for the other side before declaring a no-show
+}
+
+// Settlement is the result of applying a NoShowPolicy to an accepted bid.
+type Settlement struct {
+	Outcome         Outcome
+	RenterCharge    float64 // credits moved from the renter to the supplier
+	SupplierPenalty float64 // reputation points deducted from the supplier
+}
+
+const (
+	defaultFeePercent      = 10
+	defaultSupplierPenalty = 5
+	defaultGracePeriod     = 5 * time.Minute
+)
+
+// LoadNoShowPolicy reads the no-show policy from the environment, falling back to defaults.
+func LoadNoShowPolicy() NoShowPolicy {
+	feePercent := env.Number("NO_SHOW_FEE_PERCENT", defaultFeePercent, env.NonNegative)
+	if feePercent > 100 {
+		feePercent = 100
+	}
+	return NoShowPolicy{
+		FeePercent:      feePercent,
+		SupplierPenalty: env.Number("NO_SHOW_SUPPLIER_PENALTY", defaultSupplierPenalty, env.NonNegative),
+		GracePeriod:     env.Duration("NO_SHOW_GRACE_SECONDS", defaultGracePeriod, time.Second, env.NonNegative),
+	}
+}
+
+// Escrow returns the credits reserved by the renter for the given bid.
+func Escrow(bid models.BidWithID) float64 {
+	return bid.Amount * float64(bid.Duration)
+}
+
+// Settle decides who pays what for the given outcome of an accepted bid.
+func (p NoShowPolicy) Settle(outcome Outcome, bid models.BidWithID) Settlement {
+	switch outcome {
+	case SupplierNoShow, SupplierOffline:
+		// The renter is refunded in full: nothing is charged and the supplier is penalised
+		return Settlement{Outcome: outcome, SupplierPenalty: p.SupplierPenalty}
+	case RenterNoShow:
+		return Settlement{Outcome: outcome, RenterCharge: Escrow(bid) * p.FeePercent / 100}
+	}
+	return Settlement{Outcome: outcome}
+}
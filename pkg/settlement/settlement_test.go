@@ -0,0 +1,64 @@
+package settlement
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestSettleNoShow(t *testing.T) {
+	policy := NoShowPolicy{FeePercent: 20, SupplierPenalty: 5, GracePeriod: time.Minute}
+	bid := models.BidWithID{Bid: models.Bid{RID: "1", Amount: 2, Duration: 10}}
+
+	supplierNoShow := policy.Settle(SupplierNoShow, bid)
+	if supplierNoShow.RenterCharge != 0 {
+		t.Errorf("Expected full refund for renter, got charge %f", supplierNoShow.RenterCharge)
+	}
+	if supplierNoShow.SupplierPenalty != 5 {
+		t.Errorf("Expected supplier penalty 5, got %f", supplierNoShow.SupplierPenalty)
+	}
+
+	supplierOffline := policy.Settle(SupplierOffline, bid)
+	if supplierOffline.RenterCharge != 0 || supplierOffline.SupplierPenalty != 5 {
+		t.Errorf("Expected an offline supplier to be settled like a no-show, got %+v", supplierOffline)
+	}
+
+	renterNoShow := policy.Settle(RenterNoShow, bid)
+	if renterNoShow.RenterCharge != 4 {
+		t.Errorf("Expected no-show fee 4, got %f", renterNoShow.RenterCharge)
+	}
+	if renterNoShow.SupplierPenalty != 0 {
+		t.Errorf("Expected no supplier penalty, got %f", renterNoShow.SupplierPenalty)
+	}
+}
+
+func TestLoadNoShowPolicy(t *testing.T) {
+	t.Setenv("NO_SHOW_FEE_PERCENT", "150")
+	t.Setenv("NO_SHOW_SUPPLIER_PENALTY", "invalid")
+	t.Setenv("NO_SHOW_GRACE_SECONDS", "30")
+
+	policy := LoadNoShowPolicy()
+	if policy.FeePercent != 100 {
+		t.Errorf("Expected fee percent capped at 100, got %f", policy.FeePercent)
+	}
+	if policy.SupplierPenalty != defaultSupplierPenalty {
+		t.Errorf("Expected default supplier penalty, got %f", policy.SupplierPenalty)
+	}
+	if policy.GracePeriod != 30*time.Second {
+		t.Errorf("Expected grace period of 30s, got %v", policy.GracePeriod)
+	}
+}
+
+func TestSplitEscrow(t *testing.T) {
+	refund, payout, err := SplitEscrow(20, 5)
+	if err != nil || refund != 5 || payout != 15 {
+		t.Errorf("Expected refund 5 and payout 15, got %f, %f, %v", refund, payout, err)
+	}
+	if _, _, err = SplitEscrow(20, 25); err == nil {
+		t.Errorf("Expected error for refund above the escrow")
+	}
+	if _, _, err = SplitEscrow(20, -1); err == nil {
+		t.Errorf("Expected error for negative refund")
+	}
+}
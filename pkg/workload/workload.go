@@ -0,0 +1,193 @@
+package workload
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+const (
+	maxImageLength  = 255
+	maxCommandArgs  = 64
+	maxEnvVars      = 64
+	maxValueLength  = 4096
+	maxPorts        = 32
+	maxPolicyImages = 32
+	defaultRegistry = "docker.io"
+)
+
+var (
+	// A subset of the OCI image reference grammar: [registry[:port]/]path[:tag][@sha256:digest]
+	imagePattern  = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+	envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// NormalizeImage expands a short image reference to its full form, e.g. "python:3.12" to
+// "docker.io/library/python:3.12", so policies can match on the registry
+func NormalizeImage(image string) string {
+	image = strings.TrimSpace(image)
+	first, rest, found := strings.Cut(image, "/")
+	if !found {
+		return defaultRegistry + "/library/" + image
+	}
+	// The first component is a registry when it looks like a host
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return image
+	}
+	return defaultRegistry + "/" + first + "/" + rest
+}
+
+// Normalize returns the workload with its image reference expanded
+func Normalize(workload models.Workload) models.Workload {
+	workload.Image = NormalizeImage(workload.Image)
+	return workload
+}
+
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return nil
+}
+
+// Type returns the workload type of a loan request's workload, a nil workload is an interactive lease
+func Type(workload *models.Workload) string {
+	if workload == nil {
+		return models.WorkloadInteractive
+	}
+	if len(workload.Ports) > 0 {
+		return models.WorkloadService
+	}
+	return models.WorkloadBatch
+}
+
+// Validate checks that a workload is well formed
+func Validate(workload models.Workload) error {
+	if workload.Image == "" {
+		return errors.New("workload image is required")
+	}
+	if len(workload.Image) > maxImageLength || !imagePattern.MatchString(workload.Image) {
+		return errors.New("invalid workload image reference " + workload.Image)
+	}
+	if len(workload.Command) > maxCommandArgs {
+		return fmt.Errorf("at most %d command arguments are allowed", maxCommandArgs)
+	}
+	for _, arg := range workload.Command {
+		if len(arg) > maxValueLength {
+			return fmt.Errorf("command arguments are limited to %d bytes", maxValueLength)
+		}
+	}
+	if len(workload.Env) > maxEnvVars {
+		return fmt.Errorf("at most %d environment variables are allowed", maxEnvVars)
+	}
+	for key, value := range workload.Env {
+		if !envKeyPattern.MatchString(key) {
+			return errors.New("invalid environment variable name " + key)
+		}
+		if len(value) > maxValueLength {
+			return fmt.Errorf("environment variable values are limited to %d bytes", maxValueLength)
+		}
+	}
+	if len(workload.Ports) > maxPorts {
+		return fmt.Errorf("at most %d ports are allowed", maxPorts)
+	}
+	seen := map[int]bool{}
+	for _, port := range workload.Ports {
+		if err := validatePort(port); err != nil {
+			return err
+		}
+		if seen[port] {
+			return fmt.Errorf("duplicate port %d", port)
+		}
+		seen[port] = true
+	}
+	if workload.VolumeSize < 0 {
+		return errors.New("volume size must not be negative")
+	}
+	return nil
+}
+
+// ValidatePolicy checks that a workload policy is well formed
+func ValidatePolicy(policy models.WorkloadPolicy) error {
+	if len(policy.AllowedImages) > maxPolicyImages {
+		return fmt.Errorf("at most %d allowed images are allowed", maxPolicyImages)
+	}
+	for _, image := range policy.AllowedImages {
+		if strings.TrimSpace(image) == "" {
+			return errors.New("allowed images must not be empty")
+		}
+	}
+	if len(policy.AllowedPorts) > maxPorts*8 {
+		return fmt.Errorf("at most %d allowed ports are allowed", maxPorts*8)
+	}
+	for _, port := range policy.AllowedPorts {
+		if err := validatePort(port); err != nil {
+			return err
+		}
+	}
+	if policy.MaxVolumeSize < 0 {
+		return errors.New("max volume size must not be negative")
+	}
+	return nil
+}
+
+// imageAllowed reports whether an image reference is the allowed one or within it: the allowed prefix must end the
+// reference or be followed by a path, tag or digest separator, so "pytorch" allows "pytorch:2.3" but not
+// "pytorch-miner". A prefix ending with a separator, like "pytorch/", allows anything under it.
+func imageAllowed(image string, allowed string) bool {
+	if !strings.HasPrefix(image, allowed) {
+		return false
+	}
+	if len(image) == len(allowed) || strings.ContainsAny(allowed[len(allowed)-1:], "/:@") {
+		return true
+	}
+	return strings.ContainsAny(image[len(allowed):len(allowed)+1], "/:@")
+}
+
+// Check decides whether a policy accepts a workload on a resource, the error gives the reason of a rejection.
+// A nil workload is an interactive lease.
+func Check(policy models.WorkloadPolicy, workload *models.Workload, resource models.Resource) error {
+	if workload == nil {
+		if policy.RequireWorkload {
+			return errors.New("the supplier requires a workload specification")
+		}
+		return nil
+	}
+
+	if len(policy.AllowedImages) > 0 {
+		allowed := false
+		for _, prefix := range policy.AllowedImages {
+			if imageAllowed(workload.Image, NormalizeImage(prefix)) || imageAllowed(workload.Image, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.New("image " + workload.Image + " is not allowed by the supplier")
+		}
+	}
+
+	if len(policy.AllowedPorts) > 0 {
+		allowedPorts := map[int]bool{}
+		for _, port := range policy.AllowedPorts {
+			allowedPorts[port] = true
+		}
+		for _, port := range workload.Ports {
+			if !allowedPorts[port] {
+				return fmt.Errorf("port %d is not allowed by the supplier", port)
+			}
+		}
+	}
+
+	maxVolumeSize := resource.Storage
+	if policy.MaxVolumeSize > 0 && policy.MaxVolumeSize < maxVolumeSize {
+		maxVolumeSize = policy.MaxVolumeSize
+	}
+	if workload.VolumeSize > maxVolumeSize {
+		return fmt.Errorf("volume size %d GB exceeds the %d GB the supplier allows", workload.VolumeSize, maxVolumeSize)
+	}
+	return nil
+}
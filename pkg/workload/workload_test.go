@@ -0,0 +1,107 @@
+package workload
+
+import (
+	"testing"
+
+	"github.com/gunrgnhsr/Cycloud/pkg/models"
+)
+
+func TestNormalizeImage(t *testing.T) {
+	cases := map[string]string{
+		"python:3.12":            "docker.io/library/python:3.12",
+		"pytorch/pytorch:latest": "docker.io/pytorch/pytorch:latest",
+		"ghcr.io/org/app:v1":     "ghcr.io/org/app:v1",
+		"localhost:5000/app":     "localhost:5000/app",
+		"localhost/app":          "localhost/app",
+	}
+	for image, expected := range cases {
+		if normalized := NormalizeImage(image); normalized != expected {
+			t.Errorf("Expected %s to normalize to %s, got %s", image, expected, normalized)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := models.Workload{Image: "docker.io/library/python:3.12", Command: []string{"python", "train.py"}, Env: map[string]string{"EPOCHS": "10"}, Ports: []int{8888}, VolumeSize: 50}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Expected valid workload, got %v", err)
+	}
+
+	invalid := []models.Workload{
+		{},
+		{Image: "Python:3.12"},
+		{Image: "python", Env: map[string]string{"1BAD": "x"}},
+		{Image: "python", Ports: []int{70000}},
+		{Image: "python", Ports: []int{80, 80}},
+		{Image: "python", VolumeSize: -1},
+	}
+	for _, workload := range invalid {
+		if err := Validate(workload); err == nil {
+			t.Errorf("Expected %+v to be invalid", workload)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	resource := models.Resource{Storage: 100}
+	policy := models.WorkloadPolicy{RequireWorkload: true, AllowedImages: []string{"pytorch/"}, AllowedPorts: []int{8888}, MaxVolumeSize: 80}
+
+	if err := Check(policy, nil, resource); err == nil {
+		t.Errorf("Expected interactive lease to be rejected")
+	}
+
+	workload := Normalize(models.Workload{Image: "pytorch/pytorch:latest", Ports: []int{8888}, VolumeSize: 80})
+	if err := Check(policy, &workload, resource); err != nil {
+		t.Errorf("Expected workload to be accepted, got %v", err)
+	}
+
+	rejected := []models.Workload{
+		Normalize(models.Workload{Image: "python:3.12"}),
+		Normalize(models.Workload{Image: "pytorch/pytorch", Ports: []int{22}}),
+		Normalize(models.Workload{Image: "pytorch/pytorch", VolumeSize: 90}),
+	}
+	for _, workload := range rejected {
+		if err := Check(policy, &workload, resource); err == nil {
+			t.Errorf("Expected %+v to be rejected", workload)
+		}
+	}
+
+	// Without a policy the volume is only bounded by the resource storage
+	large := models.Workload{Image: "docker.io/library/python", VolumeSize: 101}
+	if err := Check(models.WorkloadPolicy{}, &large, resource); err == nil {
+		t.Errorf("Expected volume larger than the storage to be rejected")
+	}
+}
+
+func TestCheckAllowedImagesMatchWholeRepositories(t *testing.T) {
+	resource := models.Resource{Storage: 100}
+	policy := models.WorkloadPolicy{AllowedImages: []string{"python", "ghcr.io/acme/", "nvcr.io/nvidia/pytorch:24.01"}}
+
+	allowed := []string{"python", "python:3.12", "python@sha256:abc", "ghcr.io/acme/trainer:1", "nvcr.io/nvidia/pytorch:24.01"}
+	for _, image := range allowed {
+		workload := Normalize(models.Workload{Image: image})
+		if err := Check(policy, &workload, resource); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", image, err)
+		}
+	}
+
+	lookalikes := []string{"python-miner", "pythonista:latest", "ghcr.io/acme-evil/trainer", "nvcr.io/nvidia/pytorch:24.01-evil", "nvcr.io/nvidia/pytorch-miner:24.01"}
+	for _, image := range lookalikes {
+		workload := Normalize(models.Workload{Image: image})
+		if err := Check(policy, &workload, resource); err == nil {
+			t.Errorf("Expected lookalike %s to be rejected", image)
+		}
+	}
+}
+
+func TestType(t *testing.T) {
+	if workloadType := Type(nil); workloadType != models.WorkloadInteractive {
+		t.Errorf("Expected interactive, got %s", workloadType)
+	}
+	if workloadType := Type(&models.Workload{Image: "python"}); workloadType != models.WorkloadBatch {
+		t.Errorf("Expected batch, got %s", workloadType)
+	}
+	if workloadType := Type(&models.Workload{Image: "nginx", Ports: []int{80}}); workloadType != models.WorkloadService {
+		t.Errorf("Expected service, got %s", workloadType)
+	}
+}